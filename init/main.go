@@ -1,17 +1,30 @@
-// Write a config file with a wallet key path as well as URIs of services.
+// Write a config file with a wallet key path as well as URIs of services,
+// and create the wallet key from a new or existing mnemonic.
+//
+// Usage: init [dev] [restore]
 package main;
 
 import (
+  "bufio"
   "fmt"
   "os"
   "log"
   "config"
+  "hdwallet"
+  "keystore"
   "path/filepath"
+  "strings"
+  "golang.org/x/term"
 )
 
 
 func main() {
+  var restore = false
+  for _, arg := range os.Args[1:] {
+    if arg == "restore" { restore = true }
+  }
   writeConfigFile()
+  createWallet(restore)
 }
 
 
@@ -39,7 +52,8 @@ func writeConfigFile() {
 gridplus_api = "%s"
 rpc_provider = "%s"
 [wallet]
-key_path = "%s/../src/config"`, api, rpc, dir)
+key_path = "%s/../src/config"
+derivation_path = "%s"`, api, rpc, dir, walletPath())
 
   _, err2 := f.WriteString(s)
   if err2 != nil {
@@ -48,6 +62,60 @@ key_path = "%s/../src/config"`, api, rpc, dir)
   return
 }
 
+/**
+ * Create the wallet keystore next to the config file. Its key is derived from
+ * a new mnemonic, which is shown once, or from one the owner types in.
+ *
+ * @param restore    Ask for an existing mnemonic instead of generating one
+ */
+func createWallet(restore bool) {
+  dir, _ := filepath.Abs(filepath.Dir(os.Args[0]))
+  key_path := filepath.Join(dir, "..", "src", "config")
+  if _, err := os.Stat(filepath.Join(key_path, config.KEYSTORE_FILE)); err == nil {
+    fmt.Println("Wallet key already exists. Not creating a new one.")
+    return
+  }
+  passphrase, err := keystore.Passphrase("", true)
+  if err != nil {
+    log.Panic("Could not get wallet passphrase: ", err)
+  }
+  if restore {
+    mnemonic, err := readMnemonic()
+    if err != nil {
+      log.Panic("Could not read mnemonic: ", err)
+    }
+    addr, err := config.RestoreWallet(key_path, mnemonic, passphrase, false, walletPath())
+    if err != nil {
+      log.Panic("Could not restore wallet: ", err)
+    }
+    fmt.Printf("Restored wallet %s (%s)\n", addr, walletPath())
+    return
+  }
+  mnemonic, addr, err := config.NewWallet(key_path, passphrase, false, walletPath())
+  if err != nil {
+    log.Panic("Could not create wallet: ", err)
+  }
+  fmt.Printf("Created wallet %s\n", addr)
+  config.ShowMnemonic(mnemonic, walletPath())
+}
+
+// Read a mnemonic without echoing it, unless it is piped in
+func readMnemonic() (string, error) {
+  if !term.IsTerminal(int(os.Stdin.Fd())) {
+    line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+    return strings.TrimSpace(line), err
+  }
+  fmt.Fprint(os.Stderr, "Mnemonic: ")
+  b, err := term.ReadPassword(int(os.Stdin.Fd()))
+  fmt.Fprintln(os.Stderr)
+  return strings.TrimSpace(string(b)), err
+}
+
+// Derivation path of the wallet key
+func walletPath() (string) {
+  return hdwallet.KeyPath(hdwallet.BASE_PATH, hdwallet.WALLET_INDEX)
+}
+
 /**
  * Generate a key and an address
  */
@@ -12,4 +12,6 @@ echo "Services configured."
 
 cd src && go build -ldflags -s && cd ..
 
+cd signer && go build -ldflags -s && cd ..
+
 echo "Client installed. Fill out your setup_keys.toml file and run with 'run.sh'"
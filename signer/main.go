@@ -0,0 +1,126 @@
+// Signing daemon. Holds the agent's wallet key and signs over a UNIX socket
+// what the rules in signer.toml allow, so the agent never sees the key.
+package main;
+
+import (
+  "fmt"
+  "keystore"
+  "log"
+  "math/big"
+  "net"
+  "os"
+  "os/signal"
+  "path/filepath"
+  "sig"
+  "signer"
+  "syscall"
+  "github.com/spf13/viper"
+)
+
+// Socket path under the user's home directory. Its directory is only
+// accessible to the user running the daemon.
+const DEFAULT_SOCKET = ".agent-signer/signer.sock"
+
+
+func main() {
+  viper.SetConfigName("signer")
+  viper.AddConfigPath("config")
+  viper.AddConfigPath("../src/config")
+  if err := viper.ReadInConfig(); err != nil {
+    log.Fatal("Could not read signer.toml: ", err)
+  }
+  // The agent logs in to the hub with a personal message
+  viper.SetDefault("rules.allow_text", true)
+  socket := viper.GetString("signer.socket")
+  if socket == "" {
+    home, err := os.UserHomeDir()
+    if err != nil {
+      log.Fatal("No signer.socket set and no home directory: ", err)
+    }
+    socket = filepath.Join(home, DEFAULT_SOCKET)
+  }
+  rules := &signer.Rules{
+    ChannelId: viper.GetString("rules.channel_id"),
+    ChannelContract: viper.GetString("rules.channel_contract"),
+    Token: viper.GetString("rules.token"),
+    Hub: viper.GetString("rules.hub"),
+    AllowText: viper.GetBool("rules.allow_text"),
+    ChainId: viper.GetInt64("rules.chain_id"),
+    MaxGas: big.NewInt(viper.GetInt64("rules.max_gas")),
+    MaxGasPrice: big.NewInt(viper.GetInt64("rules.max_gas_price")),
+    MaxFee: big.NewInt(viper.GetInt64("rules.max_fee")),
+  }
+
+  wallet, err := loadKey(viper.GetString("signer.keystore"), viper.GetString("signer.passphrase_file"))
+  if err != nil {
+    log.Fatal("Could not unlock wallet key: ", err)
+  }
+  server, err := signer.NewServer(wallet, rules)
+  if err != nil {
+    log.Fatal("Invalid rules: ", err)
+  }
+
+  l, err := listen(socket)
+  if err != nil {
+    log.Fatal("Could not listen on ", socket, ": ", err)
+  }
+  stop := make(chan os.Signal, 1)
+  stopped := make(chan struct{})
+  signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+  go func() {
+    <-stop
+    close(stopped)
+    l.Close()
+  }()
+
+  log.Printf("Signing for %s on %s", wallet.Address(), socket)
+  err = server.Serve(l)
+  os.Remove(socket)
+  select {
+  case <-stopped:
+  default:
+    log.Fatal("Stopped serving: ", err)
+  }
+}
+
+
+/**
+ * Listen on a UNIX socket only this user may connect to. The socket is
+ * created inside a directory closed to other users, and with a umask that
+ * leaves it 0600 from the start, so no one can connect before it is locked
+ * down.
+ */
+func listen(socket string) (net.Listener, error) {
+  dir := filepath.Dir(socket)
+  if err := os.MkdirAll(dir, 0700); err != nil {
+    return nil, err
+  }
+  info, err := os.Stat(dir)
+  if err != nil {
+    return nil, err
+  }
+  if info.Mode().Perm() & 0077 != 0 {
+    return nil, fmt.Errorf("%s is accessible to other users (mode %o); use a directory only you can open", dir, info.Mode().Perm())
+  }
+  os.Remove(socket)
+  old := syscall.Umask(0177)
+  l, err := net.Listen("unix", socket)
+  syscall.Umask(old)
+  return l, err
+}
+
+/**
+ * Decrypt the wallet keystore. The key stays in this process only.
+ */
+func loadKey(path string, passphrase_file string) (sig.Signer, error) {
+  passphrase, err := keystore.Passphrase(passphrase_file, false)
+  if err != nil {
+    return nil, err
+  }
+  key, err := keystore.Load(path, passphrase)
+  if err != nil {
+    return nil, err
+  }
+  defer func() { for i := range key { key[i] = 0 } }()
+  return sig.NewKeySigner(key)
+}
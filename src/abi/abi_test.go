@@ -0,0 +1,188 @@
+package abi
+
+import (
+  "encoding/hex"
+  "math/big"
+  "strings"
+  "testing"
+)
+
+// The examples from the "Formal Specification of the Encoding" section of the
+// Solidity ABI docs, one line per selector or word
+var specExamples = []struct {
+  decl string
+  args []interface{}
+  words []string
+}{
+  {
+    decl: "baz(uint32 x, bool y)",
+    args: []interface{}{69, true},
+    words: []string{
+      "cdcd77c0",
+      "0000000000000000000000000000000000000000000000000000000000000045",
+      "0000000000000000000000000000000000000000000000000000000000000001",
+    },
+  },
+  {
+    decl: "bar(bytes3[2])",
+    args: []interface{}{[]interface{}{[]byte("abc"), []byte("def")}},
+    words: []string{
+      "fce353f6",
+      "6162630000000000000000000000000000000000000000000000000000000000",
+      "6465660000000000000000000000000000000000000000000000000000000000",
+    },
+  },
+  {
+    decl: "sam(bytes, bool, uint256[])",
+    args: []interface{}{[]byte("dave"), true, []int{1, 2, 3}},
+    words: []string{
+      "a5643bf2",
+      "0000000000000000000000000000000000000000000000000000000000000060",
+      "0000000000000000000000000000000000000000000000000000000000000001",
+      "00000000000000000000000000000000000000000000000000000000000000a0",
+      "0000000000000000000000000000000000000000000000000000000000000004",
+      "6461766500000000000000000000000000000000000000000000000000000000",
+      "0000000000000000000000000000000000000000000000000000000000000003",
+      "0000000000000000000000000000000000000000000000000000000000000001",
+      "0000000000000000000000000000000000000000000000000000000000000002",
+      "0000000000000000000000000000000000000000000000000000000000000003",
+    },
+  },
+  {
+    decl: "f(uint, uint32[], bytes10, bytes)",
+    args: []interface{}{"0x123", []uint32{0x456, 0x789}, []byte("1234567890"), []byte("Hello, world!")},
+    words: []string{
+      "8be65246",
+      "0000000000000000000000000000000000000000000000000000000000000123",
+      "0000000000000000000000000000000000000000000000000000000000000080",
+      "3132333435363738393000000000000000000000000000000000000000000000",
+      "00000000000000000000000000000000000000000000000000000000000000e0",
+      "0000000000000000000000000000000000000000000000000000000000000002",
+      "0000000000000000000000000000000000000000000000000000000000000456",
+      "0000000000000000000000000000000000000000000000000000000000000789",
+      "000000000000000000000000000000000000000000000000000000000000000d",
+      "48656c6c6f2c20776f726c642100000000000000000000000000000000000000",
+    },
+  },
+  {
+    decl: "g(uint[][], string[])",
+    args: []interface{}{[][]int{{1, 2}, {3}}, []string{"one", "two", "three"}},
+    words: []string{
+      "2289b18c",
+      "0000000000000000000000000000000000000000000000000000000000000040",
+      "0000000000000000000000000000000000000000000000000000000000000140",
+      "0000000000000000000000000000000000000000000000000000000000000002",
+      "0000000000000000000000000000000000000000000000000000000000000040",
+      "00000000000000000000000000000000000000000000000000000000000000a0",
+      "0000000000000000000000000000000000000000000000000000000000000002",
+      "0000000000000000000000000000000000000000000000000000000000000001",
+      "0000000000000000000000000000000000000000000000000000000000000002",
+      "0000000000000000000000000000000000000000000000000000000000000001",
+      "0000000000000000000000000000000000000000000000000000000000000003",
+      "0000000000000000000000000000000000000000000000000000000000000003",
+      "0000000000000000000000000000000000000000000000000000000000000060",
+      "00000000000000000000000000000000000000000000000000000000000000a0",
+      "00000000000000000000000000000000000000000000000000000000000000e0",
+      "0000000000000000000000000000000000000000000000000000000000000003",
+      "6f6e650000000000000000000000000000000000000000000000000000000000",
+      "0000000000000000000000000000000000000000000000000000000000000003",
+      "74776f0000000000000000000000000000000000000000000000000000000000",
+      "0000000000000000000000000000000000000000000000000000000000000005",
+      "7468726565000000000000000000000000000000000000000000000000000000",
+    },
+  },
+}
+
+func TestPackSpecExamples(t *testing.T) {
+  for _, c := range specExamples {
+    method, err := ParseMethod(c.decl)
+    if err != nil {
+      t.Fatalf("%s: %s", c.decl, err)
+    }
+    data, err := method.Pack(c.args...)
+    if err != nil {
+      t.Fatalf("%s: %s", c.decl, err)
+    }
+    want := "0x" + strings.Join(c.words, "")
+    if data != want {
+      t.Errorf("%s: got\n%s\nwant\n%s", method.Signature(), data, want)
+    }
+  }
+}
+
+// Decoding the spec encodings and packing the values again must give the
+// same data back
+func TestUnpackSpecExamples(t *testing.T) {
+  for _, c := range specExamples {
+    method := MustMethod(c.decl)
+    data := "0x" + strings.Join(c.words, "")
+    values, err := method.UnpackInput(data)
+    if err != nil {
+      t.Fatalf("%s: %s", c.decl, err)
+    }
+    repacked, err := method.Pack(values...)
+    if err != nil {
+      t.Fatalf("%s: %s", c.decl, err)
+    }
+    if repacked != data {
+      t.Errorf("%s: decoded %v, which packs to\n%s", method.Signature(), values, repacked)
+    }
+  }
+  values, err := MustMethod(specExamples[3].decl).UnpackInput("0x" + strings.Join(specExamples[3].words, ""))
+  if err != nil {
+    t.Fatal(err)
+  }
+  if values[0].(*big.Int).Int64() != 0x123 || values[2] != "0x31323334353637383930" ||
+    string(values[3].([]byte)) != "Hello, world!" {
+    t.Errorf("Decoded %v", values)
+  }
+}
+
+func TestSignatures(t *testing.T) {
+  var cases = []struct {
+    decl string
+    signature string
+    selector string
+  }{
+    {"transfer(address to, uint256 value) returns (bool)", "transfer(address,uint256)", "a9059cbb"},
+    {"function approve(address spender, uint value) external", "approve(address,uint256)", "095ea7b3"},
+    {"balanceOf(address)", "balanceOf(address)", "70a08231"},
+    {"f(uint, uint32[], bytes10, bytes)", "f(uint256,uint32[],bytes10,bytes)", "8be65246"},
+  }
+  for _, c := range cases {
+    method := MustMethod(c.decl)
+    if method.Signature() != c.signature {
+      t.Errorf("Signature of %q is %s, want %s", c.decl, method.Signature(), c.signature)
+    }
+    if got := hex.EncodeToString(method.Selector()); got != c.selector {
+      t.Errorf("Selector of %q is %s, want %s", c.decl, got, c.selector)
+    }
+  }
+  transfer := MustEvent("Transfer(address indexed from, address indexed to, uint256 value)")
+  if transfer.Topic() != "0xddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef" {
+    t.Errorf("Transfer topic is %s", transfer.Topic())
+  }
+}
+
+func TestIntRange(t *testing.T) {
+  neg, err := Encode([]Type{MustType("int256")}, []interface{}{-1})
+  if err != nil {
+    t.Fatal(err)
+  }
+  if hex.EncodeToString(neg) != strings.Repeat("ff", 32) {
+    t.Errorf("int256 -1 encodes to %x", neg)
+  }
+  values, err := Decode([]Type{MustType("int256")}, neg)
+  if err != nil || values[0].(*big.Int).Int64() != -1 {
+    t.Errorf("Decoded %v (%v)", values, err)
+  }
+  if _, err := Encode([]Type{MustType("uint8")}, []interface{}{256}); err == nil {
+    t.Errorf("256 encoded as a uint8")
+  }
+  if _, err := Encode([]Type{MustType("int8")}, []interface{}{-129}); err == nil {
+    t.Errorf("-129 encoded as an int8")
+  }
+  if _, err := Encode([]Type{MustType("uint256")}, []interface{}{-1}); err == nil {
+    t.Errorf("-1 encoded as a uint256")
+  }
+}
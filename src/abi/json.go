@@ -0,0 +1,145 @@
+package abi
+
+import (
+  "encoding/json"
+  "fmt"
+  "io"
+  "os"
+  "strings"
+)
+
+// A contract interface loaded from a JSON ABI (e.g. solc --abi output)
+type ABI struct {
+  Methods map[string]*Method
+  Events map[string]*Event
+}
+
+type jsonArgument struct {
+  Name string `json:"name"`
+  Type string `json:"type"`
+  Indexed bool `json:"indexed"`
+  Components []jsonArgument `json:"components"`
+}
+
+type jsonEntry struct {
+  Type string `json:"type"`
+  Name string `json:"name"`
+  Inputs []jsonArgument `json:"inputs"`
+  Outputs []jsonArgument `json:"outputs"`
+  Anonymous bool `json:"anonymous"`
+}
+
+/**
+ * Read a JSON ABI.
+ *
+ * Overloaded functions are keyed by name for the first one and name0, name1...
+ * for the rest, in the order they appear.
+ *
+ * @param r    JSON ABI
+ * @return     ABI, error
+ */
+func ParseJSON(r io.Reader) (*ABI, error) {
+  var entries []jsonEntry
+  if err := json.NewDecoder(r).Decode(&entries); err != nil {
+    return nil, fmt.Errorf("Error reading ABI (%w)", err)
+  }
+  contract := ABI{Methods: map[string]*Method{}, Events: map[string]*Event{}}
+  for _, entry := range entries {
+    inputs, err := jsonArguments(entry.Inputs)
+    if err != nil { return nil, fmt.Errorf("Error reading %s (%w)", entry.Name, err) }
+    outputs, err := jsonArguments(entry.Outputs)
+    if err != nil { return nil, fmt.Errorf("Error reading %s (%w)", entry.Name, err) }
+    switch entry.Type {
+    case "function", "":
+      key := entry.Name
+      for i := 0; contract.Methods[key] != nil; i++ {
+        key = fmt.Sprintf("%s%d", entry.Name, i)
+      }
+      contract.Methods[key] = &Method{Name: entry.Name, Inputs: inputs, Outputs: outputs}
+    case "event":
+      key := entry.Name
+      for i := 0; contract.Events[key] != nil; i++ {
+        key = fmt.Sprintf("%s%d", entry.Name, i)
+      }
+      contract.Events[key] = &Event{Name: entry.Name, Inputs: inputs, Anonymous: entry.Anonymous}
+    }
+    // Constructors, fallbacks and errors aren't needed by the agent
+  }
+  return &contract, nil
+}
+
+/**
+ * Read a JSON ABI file. Truffle/hardhat artifacts, which hold the ABI under
+ * an "abi" key, are also accepted.
+ *
+ * @param path    Path to the file
+ * @return        ABI, error
+ */
+func LoadJSON(path string) (*ABI, error) {
+  f, err := os.Open(path)
+  if err != nil { return nil, err }
+  defer f.Close()
+  var artifact struct {
+    ABI json.RawMessage `json:"abi"`
+  }
+  var raw json.RawMessage
+  if err := json.NewDecoder(f).Decode(&raw); err != nil {
+    return nil, fmt.Errorf("Error reading ABI %s (%w)", path, err)
+  }
+  if json.Unmarshal(raw, &artifact) == nil && artifact.ABI != nil {
+    raw = artifact.ABI
+  }
+  return ParseJSON(strings.NewReader(string(raw)))
+}
+
+/**
+ * Get a method by name.
+ */
+func (contract *ABI) Method(name string) (*Method, error) {
+  method, ok := contract.Methods[name]
+  if !ok { return nil, fmt.Errorf("No method %s in ABI", name) }
+  return method, nil
+}
+
+/**
+ * Get an event by name.
+ */
+func (contract *ABI) Event(name string) (*Event, error) {
+  event, ok := contract.Events[name]
+  if !ok { return nil, fmt.Errorf("No event %s in ABI", name) }
+  return event, nil
+}
+
+/**
+ * Encode a call to a method by name. See Method.Pack.
+ */
+func (contract *ABI) Pack(name string, args ...interface{}) (string, error) {
+  method, err := contract.Method(name)
+  if err != nil { return "", err }
+  return method.Pack(args...)
+}
+
+func jsonArguments(args []jsonArgument) (Arguments, error) {
+  var parsed Arguments
+  for _, arg := range args {
+    t, err := jsonType(arg)
+    if err != nil { return nil, err }
+    parsed = append(parsed, Argument{Name: arg.Name, Type: t, Indexed: arg.Indexed})
+  }
+  return parsed, nil
+}
+
+// Tuples are written as "tuple" (or "tuple[]"...) with their members in components
+func jsonType(arg jsonArgument) (Type, error) {
+  if !strings.HasPrefix(arg.Type, "tuple") {
+    return ParseType(arg.Type)
+  }
+  t := Type{Kind: TupleTy}
+  for _, c := range arg.Components {
+    ct, err := jsonType(c)
+    if err != nil { return Type{}, err }
+    t.Components = append(t.Components, ct)
+    t.Names = append(t.Names, c.Name)
+  }
+  return withDims(t, arg.Type[len("tuple"):])
+}
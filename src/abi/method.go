@@ -0,0 +1,312 @@
+package abi
+
+import (
+  "bytes"
+  "encoding/hex"
+  "fmt"
+  "github.com/ethereum/go-ethereum/crypto/sha3"
+  "strings"
+)
+
+type Argument struct {
+  Name string
+  Type Type
+  Indexed bool          // Only used by events
+}
+
+type Arguments []Argument
+
+type Method struct {
+  Name string
+  Inputs Arguments
+  Outputs Arguments
+  selector []byte
+}
+
+type Event struct {
+  Name string
+  Inputs Arguments
+  Anonymous bool
+}
+
+/**
+ * Parse a function declaration, e.g.
+ * "balanceOf(address owner) returns (uint256)"
+ *
+ * @param decl    Declaration. Parameter names and "returns" are optional.
+ * @return        Method, error
+ */
+func ParseMethod(decl string) (*Method, error) {
+  decl = strings.TrimPrefix(strings.TrimSpace(decl), "function ")
+  name, inputs, rest, err := parseDecl(decl)
+  if err != nil { return nil, err }
+  method := Method{Name: name, Inputs: inputs}
+  // Anything between the inputs and "returns" (view, external...) is ignored
+  if i := strings.Index(rest, "returns"); i >= 0 {
+    _, outputs, _, err := parseDecl(strings.TrimSpace(rest[i+len("returns"):]))
+    if err != nil { return nil, err }
+    method.Outputs = outputs
+  }
+  return &method, nil
+}
+
+// Same as ParseMethod, but panics on bad declarations. For package-level methods.
+func MustMethod(decl string) (*Method) {
+  method, err := ParseMethod(decl)
+  if err != nil { panic(err) }
+  return method
+}
+
+/**
+ * Use a known selector instead of the one computed from the signature. For
+ * deployed contracts whose source (and so whose function names) we don't have.
+ *
+ * @param selector    4 byte hex selector, e.g. "0x095ea7b3"
+ * @return            The method
+ */
+func (method *Method) WithSelector(selector string) (*Method) {
+  b, err := hex.DecodeString(strings.TrimPrefix(selector, "0x"))
+  if err != nil || len(b) != 4 {
+    panic(fmt.Sprintf("Invalid selector %q for %s", selector, method.Name))
+  }
+  method.selector = b
+  return method
+}
+
+/**
+ * Get the canonical signature, e.g. "transfer(address,uint256)"
+ */
+func (method *Method) Signature() (string) {
+  return method.Name + method.Inputs.signature()
+}
+
+/**
+ * Get the 4 byte selector: the start of keccak256 of the signature
+ */
+func (method *Method) Selector() ([]byte) {
+  if method.selector != nil {
+    return method.selector
+  }
+  return Keccak256([]byte(method.Signature()))[:4]
+}
+
+/**
+ * Encode a call to the method.
+ *
+ * @param args    One value per input (see Encode for accepted types)
+ * @return        0x-prefixed hex call data, error
+ */
+func (method *Method) Pack(args ...interface{}) (string, error) {
+  enc, err := method.Inputs.Pack(args...)
+  if err != nil {
+    return "", fmt.Errorf("Error encoding %s (%w)", method.Name, err)
+  }
+  return "0x" + hex.EncodeToString(append(method.Selector(), enc...)), nil
+}
+
+/**
+ * Same as Pack, but panics on bad arguments. For arguments that are known to
+ * be well formed, e.g. addresses read from config.
+ */
+func (method *Method) MustPack(args ...interface{}) (string) {
+  data, err := method.Pack(args...)
+  if err != nil { panic(err) }
+  return data
+}
+
+/**
+ * Decode the return values of a call to the method.
+ *
+ * @param result    0x-prefixed hex result of eth_call
+ * @return          One value per output (see Decode), error
+ */
+func (method *Method) Unpack(result string) ([]interface{}, error) {
+  values, err := DecodeHex(method.Outputs.Types(), result)
+  if err != nil {
+    return nil, fmt.Errorf("Error decoding %s result (%w)", method.Name, err)
+  }
+  return values, nil
+}
+
+/**
+ * Decode the arguments of a call to the method, e.g. the data of a
+ * transaction about to be signed.
+ *
+ * @param data    0x-prefixed hex call data, selector included
+ * @return        One value per input (see Decode), error
+ */
+func (method *Method) UnpackInput(data string) ([]interface{}, error) {
+  b, err := hex.DecodeString(strings.TrimPrefix(data, "0x"))
+  if err != nil {
+    return nil, fmt.Errorf("Invalid hex data (%s)", err)
+  }
+  if len(b) < 4 || !bytes.Equal(b[:4], method.Selector()) {
+    return nil, fmt.Errorf("Data is not a call to %s", method.Name)
+  }
+  values, err := Decode(method.Inputs.Types(), b[4:])
+  if err != nil {
+    return nil, fmt.Errorf("Error decoding %s arguments (%w)", method.Name, err)
+  }
+  return values, nil
+}
+
+/**
+ * Parse an event declaration, e.g.
+ * "Transfer(address indexed from, address indexed to, uint256 value)"
+ *
+ * @param decl    Declaration
+ * @return        Event, error
+ */
+func ParseEvent(decl string) (*Event, error) {
+  decl = strings.TrimPrefix(strings.TrimSpace(decl), "event ")
+  name, inputs, rest, err := parseDecl(decl)
+  if err != nil { return nil, err }
+  return &Event{Name: name, Inputs: inputs, Anonymous: strings.Contains(rest, "anonymous")}, nil
+}
+
+// Same as ParseEvent, but panics on bad declarations. For package-level events.
+func MustEvent(decl string) (*Event) {
+  event, err := ParseEvent(decl)
+  if err != nil { panic(err) }
+  return event
+}
+
+/**
+ * Get the canonical signature, e.g. "Transfer(address,address,uint256)"
+ */
+func (event *Event) Signature() (string) {
+  return event.Name + event.Inputs.signature()
+}
+
+/**
+ * Get topic 0 of the event's logs: keccak256 of the signature
+ */
+func (event *Event) Topic() (string) {
+  return "0x" + hex.EncodeToString(Keccak256([]byte(event.Signature())))
+}
+
+/**
+ * Decode the values of a log emitted as this event.
+ *
+ * Indexed dynamic values (strings, bytes, arrays) are only logged as their
+ * hash, which is returned as a hex string.
+ *
+ * @param topics    Topics of the log, including the event topic
+ * @param data      0x-prefixed hex data of the log
+ * @return          Values keyed by parameter name (or "argN"), error
+ */
+func (event *Event) Unpack(topics []string, data string) (map[string]interface{}, error) {
+  if !event.Anonymous {
+    if len(topics) == 0 || !strings.EqualFold(topics[0], event.Topic()) {
+      return nil, fmt.Errorf("Log is not a %s event", event.Name)
+    }
+    topics = topics[1:]
+  }
+  var unindexed Arguments
+  var positions []int
+  values := map[string]interface{}{}
+  for i, arg := range event.Inputs {
+    if !arg.Indexed {
+      unindexed = append(unindexed, arg)
+      positions = append(positions, i)
+      continue
+    }
+    if len(topics) == 0 {
+      return nil, fmt.Errorf("%s log is missing topics", event.Name)
+    }
+    w, err := hex.DecodeString(strings.TrimPrefix(topics[0], "0x"))
+    if err != nil || len(w) != 32 {
+      return nil, fmt.Errorf("Invalid topic %s in %s log", topics[0], event.Name)
+    }
+    topics = topics[1:]
+    if arg.Type.Dynamic() || arg.Type.Kind == ArrayTy || arg.Type.Kind == TupleTy {
+      values[arg.name(i)] = fmt.Sprintf("0x%x", w)
+      continue
+    }
+    values[arg.name(i)], err = arg.Type.decodeWord(w)
+    if err != nil { return nil, err }
+  }
+  decoded, err := DecodeHex(unindexed.Types(), data)
+  if err != nil {
+    return nil, fmt.Errorf("Error decoding %s log (%w)", event.Name, err)
+  }
+  for i, arg := range unindexed {
+    values[arg.name(positions[i])] = decoded[i]
+  }
+  return values, nil
+}
+
+/**
+ * Get the types of the arguments, in order
+ */
+func (args Arguments) Types() ([]Type) {
+  types := make([]Type, len(args))
+  for i, arg := range args {
+    types[i] = arg.Type
+  }
+  return types
+}
+
+/**
+ * Encode values for the arguments
+ */
+func (args Arguments) Pack(values ...interface{}) ([]byte, error) {
+  return Encode(args.Types(), values)
+}
+
+// Argument types in parentheses, e.g. "(address,uint256)"
+func (args Arguments) signature() (string) {
+  return Type{Kind: TupleTy, Components: args.Types()}.String()
+}
+
+// Name of the argument, or a placeholder if it is unnamed
+func (arg Argument) name(i int) (string) {
+  if arg.Name != "" { return arg.Name }
+  return fmt.Sprintf("arg%d", i)
+}
+
+/**
+ * Hash a byte array. This is a keccak 256 sha3 hash.
+ */
+func Keccak256(data []byte) ([]byte) {
+  d := sha3.NewKeccak256()
+  d.Write(data)
+  return d.Sum(nil)
+}
+
+// Split "name(args) rest" into its parts
+func parseDecl(decl string) (string, Arguments, string, error) {
+  open := strings.Index(decl, "(")
+  if open < 0 {
+    return "", nil, "", fmt.Errorf("Invalid declaration %q", decl)
+  }
+  // Find the matching parenthesis
+  var depth = 0
+  var close = -1
+  for i := open; i < len(decl) && close < 0; i++ {
+    switch decl[i] {
+    case '(':
+      depth++
+    case ')':
+      depth--
+      if depth == 0 { close = i }
+    }
+  }
+  if close < 0 {
+    return "", nil, "", fmt.Errorf("Unbalanced parentheses in %q", decl)
+  }
+  var args Arguments
+  if params := strings.TrimSpace(decl[open+1:close]); params != "" {
+    for _, param := range splitTopLevel(params) {
+      typ, name := splitName(param)
+      t, err := ParseType(typ)
+      if err != nil { return "", nil, "", err }
+      indexed := false
+      for _, field := range strings.Fields(param[len(typ):]) {
+        if field == "indexed" { indexed = true }
+      }
+      args = append(args, Argument{Name: name, Type: t, Indexed: indexed})
+    }
+  }
+  return strings.TrimSpace(decl[:open]), args, decl[close+1:], nil
+}
@@ -0,0 +1,200 @@
+package abi
+
+import (
+  "encoding/hex"
+  "fmt"
+  "math/big"
+  "reflect"
+  "strings"
+)
+
+/**
+ * Encode values as a tuple of the given types, e.g. function arguments.
+ *
+ * Integers may be given as *big.Int, Go ints or decimal/0x-hex strings.
+ * Addresses and fixed bytes may be given as hex strings (with or without 0x)
+ * or byte slices. Arrays, slices and tuples take a slice of values.
+ *
+ * @param types     Types to encode
+ * @param values    One value per type
+ * @return          Encoded data, error
+ */
+func Encode(types []Type, values []interface{}) ([]byte, error) {
+  if len(types) != len(values) {
+    return nil, fmt.Errorf("Expected %d values, got %d", len(types), len(values))
+  }
+  var headSize = 0
+  for _, t := range types {
+    headSize += t.headSize()
+  }
+  var head, tail []byte
+  for i, t := range types {
+    enc, err := t.encode(values[i])
+    if err != nil { return nil, err }
+    if t.Dynamic() {
+      // The head holds the offset of the value in the tail
+      head = append(head, word(big.NewInt(int64(headSize + len(tail))))...)
+      tail = append(tail, enc...)
+    } else {
+      head = append(head, enc...)
+    }
+  }
+  return append(head, tail...), nil
+}
+
+// Encode a single value of the type
+func (t Type) encode(v interface{}) ([]byte, error) {
+  switch t.Kind {
+  case UintTy, IntTy:
+    i, err := toBig(v)
+    if err != nil { return nil, err }
+    return t.encodeInt(i)
+  case AddressTy:
+    b, err := toBytes(v)
+    if err != nil || len(b) > 20 {
+      return nil, fmt.Errorf("Invalid address %v", v)
+    }
+    return leftPad(b), nil
+  case BoolTy:
+    b, ok := v.(bool)
+    if !ok { return nil, fmt.Errorf("Invalid bool %v", v) }
+    if b { return word(big.NewInt(1)), nil }
+    return word(big.NewInt(0)), nil
+  case FixedBytesTy:
+    b, err := toBytes(v)
+    if err != nil || len(b) > t.Size {
+      return nil, fmt.Errorf("Invalid bytes%d %v", t.Size, v)
+    }
+    return rightPad(b), nil
+  case BytesTy, StringTy:
+    var b []byte
+    if s, ok := v.(string); ok && t.Kind == StringTy {
+      b = []byte(s)
+    } else {
+      var err error
+      b, err = toBytes(v)
+      if err != nil { return nil, fmt.Errorf("Invalid %s %v", t, v) }
+    }
+    return append(word(big.NewInt(int64(len(b)))), rightPad(b)...), nil
+  case SliceTy:
+    elems, err := toSlice(v)
+    if err != nil { return nil, err }
+    enc, err := Encode(repeat(*t.Elem, len(elems)), elems)
+    if err != nil { return nil, err }
+    return append(word(big.NewInt(int64(len(elems)))), enc...), nil
+  case ArrayTy:
+    elems, err := toSlice(v)
+    if err != nil { return nil, err }
+    if len(elems) != t.Size {
+      return nil, fmt.Errorf("Expected %d values for %s, got %d", t.Size, t, len(elems))
+    }
+    return Encode(repeat(*t.Elem, t.Size), elems)
+  case TupleTy:
+    elems, err := toSlice(v)
+    if err != nil { return nil, err }
+    return Encode(t.Components, elems)
+  }
+  return nil, fmt.Errorf("Unsupported type %s", t)
+}
+
+// Encode an integer, checking that it fits in the type
+func (t Type) encodeInt(i *big.Int) ([]byte, error) {
+  if t.Kind == UintTy {
+    if i.Sign() < 0 || i.BitLen() > t.Size {
+      return nil, fmt.Errorf("%s out of range for %s", i, t)
+    }
+    return word(i), nil
+  }
+  limit := new(big.Int).Lsh(big.NewInt(1), uint(t.Size-1))
+  if i.Cmp(limit) >= 0 || i.Cmp(new(big.Int).Neg(limit)) < 0 {
+    return nil, fmt.Errorf("%s out of range for %s", i, t)
+  }
+  if i.Sign() < 0 {
+    // Two's complement
+    i = new(big.Int).Add(i, new(big.Int).Lsh(big.NewInt(1), 256))
+  }
+  return word(i), nil
+}
+
+// A 32 byte big endian word
+func word(i *big.Int) ([]byte) {
+  return leftPad(i.Bytes())
+}
+
+func leftPad(b []byte) ([]byte) {
+  padded := make([]byte, 32)
+  copy(padded[32-len(b):], b)
+  return padded
+}
+
+// Pad to a multiple of 32 bytes
+func rightPad(b []byte) ([]byte) {
+  size := (len(b) + 31) / 32 * 32
+  padded := make([]byte, size)
+  copy(padded, b)
+  return padded
+}
+
+func repeat(t Type, n int) ([]Type) {
+  types := make([]Type, n)
+  for i := range types {
+    types[i] = t
+  }
+  return types
+}
+
+func toBig(v interface{}) (*big.Int, error) {
+  switch i := v.(type) {
+  case *big.Int:
+    if i == nil { return nil, fmt.Errorf("Invalid integer <nil>") }
+    return i, nil
+  case big.Int:
+    return &i, nil
+  case string:
+    n, ok := new(big.Int).SetString(i, 0)
+    if !ok { return nil, fmt.Errorf("Invalid integer %q", i) }
+    return n, nil
+  }
+  rv := reflect.ValueOf(v)
+  switch rv.Kind() {
+  case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+    return big.NewInt(rv.Int()), nil
+  case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+    return new(big.Int).SetUint64(rv.Uint()), nil
+  }
+  return nil, fmt.Errorf("Invalid integer %v", v)
+}
+
+func toBytes(v interface{}) ([]byte, error) {
+  switch b := v.(type) {
+  case []byte:
+    return b, nil
+  case string:
+    b = strings.TrimPrefix(b, "0x")
+    if len(b) % 2 == 1 { b = "0" + b }
+    return hex.DecodeString(b)
+  }
+  // Fixed size arrays, e.g. [32]byte
+  rv := reflect.ValueOf(v)
+  if rv.Kind() == reflect.Array && rv.Type().Elem().Kind() == reflect.Uint8 {
+    b := make([]byte, rv.Len())
+    reflect.Copy(reflect.ValueOf(b), rv)
+    return b, nil
+  }
+  return nil, fmt.Errorf("Invalid bytes %v", v)
+}
+
+func toSlice(v interface{}) ([]interface{}, error) {
+  if s, ok := v.([]interface{}); ok {
+    return s, nil
+  }
+  rv := reflect.ValueOf(v)
+  if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+    return nil, fmt.Errorf("Expected a slice, got %T", v)
+  }
+  s := make([]interface{}, rv.Len())
+  for i := range s {
+    s[i] = rv.Index(i).Interface()
+  }
+  return s, nil
+}
@@ -0,0 +1,251 @@
+// Encode and decode data for the Solidity contract ABI
+package abi
+
+import (
+  "fmt"
+  "strconv"
+  "strings"
+)
+
+// Kinds of ABI types
+const (
+  UintTy = iota
+  IntTy
+  AddressTy
+  BoolTy
+  FixedBytesTy
+  BytesTy
+  StringTy
+  SliceTy
+  ArrayTy
+  TupleTy
+)
+
+type Type struct {
+  Kind int
+  Size int              // Bits of ints, bytes of fixed bytes, length of arrays
+  Elem *Type            // Element type of arrays and slices
+  Components []Type     // Member types of tuples
+  Names []string        // Member names of tuples, if known
+}
+
+/**
+ * Parse a Solidity type, e.g. "uint256", "bytes32[]", "(address,uint256)[2]"
+ *
+ * @param s    Type string. Tuple members may be named, e.g. "(address to)".
+ * @return     Type, error
+ */
+func ParseType(s string) (Type, error) {
+  s = strings.TrimSpace(s)
+  if s == "" {
+    return Type{}, fmt.Errorf("Empty type")
+  }
+  // Split off any array dimensions, e.g. "[]" or "[2][]"
+  base := s
+  var dims = ""
+  if strings.HasSuffix(s, "]") {
+    close := strings.LastIndex(s, ")")
+    open := strings.Index(s[close+1:], "[")
+    if open < 0 {
+      return Type{}, fmt.Errorf("Invalid type %q", s)
+    }
+    base = s[:close+1+open]
+    dims = s[close+1+open:]
+  }
+  t, err := parseBase(base)
+  if err != nil { return Type{}, err }
+  return withDims(t, dims)
+}
+
+// Same as ParseType, but panics on bad types
+func MustType(s string) (Type) {
+  t, err := ParseType(s)
+  if err != nil { panic(err) }
+  return t
+}
+
+/**
+ * Get the canonical name of the type, as used in signatures
+ */
+func (t Type) String() (string) {
+  switch t.Kind {
+  case UintTy:
+    return fmt.Sprintf("uint%d", t.Size)
+  case IntTy:
+    return fmt.Sprintf("int%d", t.Size)
+  case AddressTy:
+    return "address"
+  case BoolTy:
+    return "bool"
+  case FixedBytesTy:
+    return fmt.Sprintf("bytes%d", t.Size)
+  case BytesTy:
+    return "bytes"
+  case StringTy:
+    return "string"
+  case SliceTy:
+    return t.Elem.String() + "[]"
+  case ArrayTy:
+    return fmt.Sprintf("%s[%d]", t.Elem.String(), t.Size)
+  case TupleTy:
+    members := make([]string, len(t.Components))
+    for i, c := range t.Components {
+      members[i] = c.String()
+    }
+    return "(" + strings.Join(members, ",") + ")"
+  }
+  return "unknown"
+}
+
+/**
+ * Check if the type is dynamic, i.e. encoded at an offset after the head
+ */
+func (t Type) Dynamic() (bool) {
+  switch t.Kind {
+  case BytesTy, StringTy, SliceTy:
+    return true
+  case ArrayTy:
+    return t.Elem.Dynamic()
+  case TupleTy:
+    for _, c := range t.Components {
+      if c.Dynamic() { return true }
+    }
+  }
+  return false
+}
+
+// Number of bytes the type takes up in the head of an encoding
+func (t Type) headSize() (int) {
+  if t.Dynamic() { return 32 }
+  switch t.Kind {
+  case ArrayTy:
+    return t.Size * t.Elem.headSize()
+  case TupleTy:
+    var size = 0
+    for _, c := range t.Components {
+      size += c.headSize()
+    }
+    return size
+  }
+  return 32
+}
+
+// Parse a type without array dimensions
+func parseBase(s string) (Type, error) {
+  if strings.HasPrefix(s, "(") && strings.HasSuffix(s, ")") {
+    return parseTuple(s[1:len(s)-1])
+  }
+  switch {
+  case s == "address":
+    return Type{Kind: AddressTy, Size: 20}, nil
+  case s == "bool":
+    return Type{Kind: BoolTy}, nil
+  case s == "string":
+    return Type{Kind: StringTy}, nil
+  case s == "bytes":
+    return Type{Kind: BytesTy}, nil
+  case strings.HasPrefix(s, "bytes"):
+    size, err := strconv.Atoi(s[5:])
+    if err != nil || size < 1 || size > 32 {
+      return Type{}, fmt.Errorf("Invalid type %q", s)
+    }
+    return Type{Kind: FixedBytesTy, Size: size}, nil
+  case strings.HasPrefix(s, "uint"):
+    size, err := intSize(s[4:])
+    if err != nil { return Type{}, fmt.Errorf("Invalid type %q", s) }
+    return Type{Kind: UintTy, Size: size}, nil
+  case strings.HasPrefix(s, "int"):
+    size, err := intSize(s[3:])
+    if err != nil { return Type{}, fmt.Errorf("Invalid type %q", s) }
+    return Type{Kind: IntTy, Size: size}, nil
+  }
+  return Type{}, fmt.Errorf("Unsupported type %q", s)
+}
+
+// Bit size of an int type. "" is an alias for 256.
+func intSize(s string) (int, error) {
+  if s == "" { return 256, nil }
+  size, err := strconv.Atoi(s)
+  if err != nil { return 0, err }
+  if size < 8 || size > 256 || size % 8 != 0 {
+    return 0, fmt.Errorf("Invalid int size %d", size)
+  }
+  return size, nil
+}
+
+// Parse the members of a tuple, e.g. "address to,uint256 value"
+func parseTuple(s string) (Type, error) {
+  t := Type{Kind: TupleTy}
+  if strings.TrimSpace(s) == "" {
+    return t, nil
+  }
+  for _, member := range splitTopLevel(s) {
+    typ, name := splitName(member)
+    c, err := ParseType(typ)
+    if err != nil { return Type{}, err }
+    t.Components = append(t.Components, c)
+    t.Names = append(t.Names, name)
+  }
+  return t, nil
+}
+
+// Wrap a type in array dimensions, e.g. "[2][]" is a slice of 2-arrays
+func withDims(t Type, dims string) (Type, error) {
+  for dims != "" {
+    close := strings.Index(dims, "]")
+    if dims[0] != '[' || close < 0 {
+      return Type{}, fmt.Errorf("Invalid array dimensions %q", dims)
+    }
+    elem := t
+    if close == 1 {
+      t = Type{Kind: SliceTy, Elem: &elem}
+    } else {
+      size, err := strconv.Atoi(dims[1:close])
+      if err != nil || size < 1 {
+        return Type{}, fmt.Errorf("Invalid array dimensions %q", dims)
+      }
+      t = Type{Kind: ArrayTy, Size: size, Elem: &elem}
+    }
+    dims = dims[close+1:]
+  }
+  return t, nil
+}
+
+// Split a comma separated list, ignoring commas inside parentheses
+func splitTopLevel(s string) ([]string) {
+  var parts []string
+  var depth = 0
+  var start = 0
+  for i, c := range s {
+    switch c {
+    case '(':
+      depth++
+    case ')':
+      depth--
+    case ',':
+      if depth == 0 {
+        parts = append(parts, strings.TrimSpace(s[start:i]))
+        start = i + 1
+      }
+    }
+  }
+  return append(parts, strings.TrimSpace(s[start:]))
+}
+
+// Split a parameter, e.g. "address indexed to", into its type and name
+func splitName(param string) (string, string) {
+  param = strings.TrimSpace(param)
+  // The type may be a tuple containing spaces
+  end := strings.LastIndex(param, ")")
+  if end < 0 { end = 0 }
+  fields := strings.Fields(param[end:])
+  if len(fields) <= 1 {
+    return param, ""
+  }
+  typ := param[:end] + fields[0]
+  name := fields[len(fields)-1]
+  if name == "indexed" || name == "memory" || name == "calldata" {
+    name = ""
+  }
+  return typ, name
+}
@@ -0,0 +1,120 @@
+package abi
+
+import (
+  "encoding/hex"
+  "fmt"
+  "math/big"
+  "strings"
+)
+
+/**
+ * Decode a tuple of the given types, e.g. function return values.
+ *
+ * Integers decode to *big.Int, addresses and fixed bytes to 0x-prefixed hex
+ * strings, bytes to []byte, and arrays, slices and tuples to []interface{}.
+ *
+ * @param types    Types to decode
+ * @param data     Encoded data
+ * @return         One value per type, error
+ */
+func Decode(types []Type, data []byte) ([]interface{}, error) {
+  values := make([]interface{}, len(types))
+  var offset = 0
+  for i, t := range types {
+    var err error
+    if t.Dynamic() {
+      // The head holds the offset of the value
+      var start int
+      start, err = readInt(data, offset)
+      if err != nil { return nil, err }
+      values[i], err = t.decode(data[start:])
+    } else {
+      if offset > len(data) {
+        return nil, fmt.Errorf("Data too short for %s", t)
+      }
+      values[i], err = t.decode(data[offset:])
+    }
+    if err != nil { return nil, err }
+    offset += t.headSize()
+  }
+  return values, nil
+}
+
+/**
+ * Same as Decode, but from a 0x-prefixed hex string, e.g. an eth_call result
+ */
+func DecodeHex(types []Type, data string) ([]interface{}, error) {
+  b, err := hex.DecodeString(strings.TrimPrefix(data, "0x"))
+  if err != nil {
+    return nil, fmt.Errorf("Invalid hex data (%s)", err)
+  }
+  return Decode(types, b)
+}
+
+// Decode a single value of the type from the start of data
+func (t Type) decode(data []byte) (interface{}, error) {
+  switch t.Kind {
+  case SliceTy:
+    n, err := readInt(data, 0)
+    if err != nil { return nil, err }
+    return Decode(repeat(*t.Elem, n), data[32:])
+  case ArrayTy:
+    return Decode(repeat(*t.Elem, t.Size), data)
+  case TupleTy:
+    return Decode(t.Components, data)
+  case BytesTy, StringTy:
+    n, err := readInt(data, 0)
+    if err != nil { return nil, err }
+    if 32 + n > len(data) {
+      return nil, fmt.Errorf("Data too short for %s of length %d", t, n)
+    }
+    if t.Kind == StringTy {
+      return string(data[32:32+n]), nil
+    }
+    return append([]byte{}, data[32:32+n]...), nil
+  }
+  if len(data) < 32 {
+    return nil, fmt.Errorf("Data too short for %s", t)
+  }
+  return t.decodeWord(data[:32])
+}
+
+/**
+ * Decode a single 32 byte word of a static type, e.g. an indexed event topic
+ */
+func (t Type) decodeWord(w []byte) (interface{}, error) {
+  switch t.Kind {
+  case UintTy:
+    return new(big.Int).SetBytes(w), nil
+  case IntTy:
+    i := new(big.Int).SetBytes(w)
+    if w[0] & 0x80 != 0 {
+      // Two's complement
+      i.Sub(i, new(big.Int).Lsh(big.NewInt(1), 256))
+    }
+    return i, nil
+  case AddressTy:
+    return fmt.Sprintf("0x%x", w[12:]), nil
+  case BoolTy:
+    i := new(big.Int).SetBytes(w)
+    if i.BitLen() > 1 {
+      return nil, fmt.Errorf("Invalid bool 0x%x", w)
+    }
+    return i.Sign() == 1, nil
+  case FixedBytesTy:
+    return fmt.Sprintf("0x%x", w[:t.Size]), nil
+  }
+  return nil, fmt.Errorf("Cannot decode %s from a single word", t)
+}
+
+// Read a word as an offset or length
+func readInt(data []byte, offset int) (int, error) {
+  if offset + 32 > len(data) {
+    return 0, fmt.Errorf("Data too short")
+  }
+  i := new(big.Int).SetBytes(data[offset:offset+32])
+  if !i.IsInt64() || i.Int64() > int64(len(data)) {
+    return 0, fmt.Errorf("Invalid offset or length %s", i)
+  }
+  return int(i.Int64()), nil
+}
@@ -4,14 +4,10 @@
 package api
 
 import (
-  "bytes"
-	"encoding/json"
-	"fmt"
-	"io/ioutil"
-	"net/http"
+  "context"
+  "fmt"
   "sig"
 )
-import "github.com/ethereum/go-ethereum/crypto"
 
 type StringRes struct {
   Result string
@@ -26,49 +22,76 @@ type FaucetReq struct {
   SerialHash string `json:"serial_hash"`
 }
 
+type AuthReq struct {
+  Owner string `json:"owner"`
+  Sig string `json:"sig"`
+  Personal bool `json:"personal,omitempty"`     // Signed with personal_sign (EIP-191)
+}
+
 type SaveAgentReq struct {
   SerialHash string `json:"serial_hash"`
 }
 
+/**
+ * Get the data the agent must sign to authenticate.
+ *
+ * @param ctx    Cancels the request
+ * @return       Auth data, error
+ */
+func (c *Client) AuthDatum(ctx context.Context) (string, error) {
+  var data = new(StringRes)
+  err := c.do(ctx, "GET", "/AuthDatum", false, nil, data)
+  if err != nil { return "", fmt.Errorf("Could not get authentication data: (%w)", err) }
+  return data.Result, nil
+}
+
+/**
+ * Trade a signature over the auth data for a JSON web token.
+ *
+ * @param ctx      Cancels the request
+ * @param owner       Address that signed the auth data
+ * @param _sig        0x-prefixed signature
+ * @param personal    Whether the auth data was signed with personal_sign
+ * @return            JSON web token, error
+ */
+func (c *Client) Authenticate(ctx context.Context, owner string, _sig string, personal bool) (string, error) {
+  var authdata = new(StringRes)
+  err := c.do(ctx, "POST", "/Authenticate", false, AuthReq{owner, _sig, personal}, authdata)
+  if err != nil { return "", fmt.Errorf("Could not authenticate: (%w)", err) }
+  return authdata.Result, nil
+}
+
 // Authenticate the battery with the API.
 // @returns - JSON web token string that must be included in authenticated endpoints.
 //            This token will only be valid for a finite period of time. And once it
 //            expires, this function will need to be called again for a new one.
-func GetAuthToken(address string, pkey string, API string) (string, error) {
-  var data = new(StringRes)
+func (c *Client) Login(ctx context.Context, signer sig.Signer) (string, error) {
   // 1: Get the auth data to sign
   // ----------------------------
-  res_data, err := http.Get(API+"/AuthDatum")
-  // Data will need to be hashed
-  if err != nil { return "", fmt.Errorf("Could not get authentication data: (%s)", err) }
-  body, err1 := ioutil.ReadAll(res_data.Body)
-  if err != nil { return "", fmt.Errorf("Could not parse authentication data: (%s)", err1) }
-  err2 := json.Unmarshal(body, &data)
-  if err2 != nil { return "", fmt.Errorf("Could not unmarshal authentication data: (%s)", err2) }
+  data, err := c.AuthDatum(ctx)
+  if err != nil { return "", err }
+
+  // Signers that refuse raw hashes can still sign a personal message
+  _, text := signer.(sig.TextSigner)
+  if c.PersonalSign || text {
+    // Sign like a wallet would, so the token can't be replayed as a raw hash signature
+    signature, err := sig.SignPersonal([]byte(data), signer)
+    if err != nil { return "", err }
+    return c.Authenticate(ctx, signer.Address(), signature.Hex(), true)
+  }
 
   // Hash the data. Keep the byte array
-  data_hash := sig.Keccak256Hash([]byte(data.Result))
-  // Sign the data with the private key
-  privkey, err3 := crypto.HexToECDSA(pkey)
-  if err3 != nil { return "", fmt.Errorf("Could not parse private key: (%s)", err3) }
+  data_hash := sig.Keccak256Hash([]byte(data))
   // Sign the auth data
-  _sig, err4 := sig.Ecsign(data_hash, privkey)
-  if err4 != nil { return "", fmt.Errorf("Could not sign with private key: (%s)", err4) }
+  signature, err := signer.SignHash(data_hash)
+  if err != nil { return "", err }
+  // [R || S || V] where V is 0 or 1
+  _sig, err := signature.Bytes()
+  if err != nil { return "", err }
 
   // 2: Send sigature, get token
   // ---------------------
-  var authdata = new(StringRes)
-  var jsonStr = []byte(`{"owner":"`+address+`","sig":"0x`+_sig+`"}`)
-  res, err5 := http.Post(API+"/Authenticate", "application/json", bytes.NewBuffer(jsonStr))
-  if err5 != nil { return "", fmt.Errorf("Could not hit POST /Authenticate: (%s)", err5) }
-  if res.StatusCode != 200 { return "", fmt.Errorf("(%s): Error in POST /Authenticate", res.StatusCode)}
-  body, err6 := ioutil.ReadAll(res.Body)
-  if err6 != nil { return "" , fmt.Errorf("Could not read /Authenticate body: (%s)", err6)}
-  err7 := json.Unmarshal(body, &authdata)
-  if err7 != nil { return "", fmt.Errorf("Could not unmarshal /Authenticate body: (%s)", err7) }
-
-  // Return the JSON web token
-  return string(authdata.Result), nil
+  return c.Authenticate(ctx, signer.Address(), fmt.Sprintf("0x%x", _sig), false)
 }
 
 
@@ -76,33 +99,19 @@ func GetAuthToken(address string, pkey string, API string) (string, error) {
 /**
  * Ask the faucet for some ether
  *
+ * @param ctx            Cancels the request
  * @param serial_hash
  * @param wallet
- * @param auth_token     JSON web token
- * @param api            Full base URI of api
  * @return               Transaction hash, error
  */
-func Faucet(serial_hash string, wallet string, auth_token string, API string) (string, error) {
+func (c *Client) Faucet(ctx context.Context, serial_hash string, wallet string) (string, error) {
   payload := FaucetReq{wallet, serial_hash}
-  b, _ := json.Marshal(payload)
-
   var result = new(StringRes)
-  client := &http.Client{}
-  req, _ := http.NewRequest("POST", API+"/Faucet", bytes.NewBuffer(b))
-  req.Header.Set("x-access-token", auth_token)
-  req.Header.Set("Content-Type", "application/json")
-  res, _ := client.Do(req)
-  body, err := ioutil.ReadAll(res.Body)
+  err := c.do(ctx, "POST", "/Faucet", true, payload, result)
   if err != nil {
-    return "", fmt.Errorf("Could not read response body (%s)", err)
-  } else {
-    err2 := json.Unmarshal(body, &result)
-    if err2 != nil {
-      return "", fmt.Errorf("Could not unmarshal body (%s)", err)
-    }
+    return "", fmt.Errorf("Could not get ether from faucet: (%w)", err)
   }
   return result.Result, nil
-
 }
 
 /**
@@ -110,30 +119,16 @@ func Faucet(serial_hash string, wallet string, auth_token string, API string) (s
  * with a physical agent. At this point, Grid+ will start collecting usage
  * data for the household.
  *
+ * @param  ctx            Cancels the request
  * @param  serial_hash    Hash of the agent's serial number
- * @param  auth_token     JSON-Web-Token
- * @param  API            Base URL for the Grid+ API
  * @return                nil for success, error for failure
  */
-func SaveAgent(serial_hash string, auth_token string, API string) (int, error) {
+func (c *Client) SaveAgent(ctx context.Context, serial_hash string) (int, error) {
   payload := SaveAgentReq{serial_hash}
-  b, _ := json.Marshal(payload)
-
   var result = new(SuccessRes)
-  client := &http.Client{}
-  req, _ := http.NewRequest("POST", API+"/SaveAgent", bytes.NewBuffer(b))
-  req.Header.Set("x-access-token", auth_token)
-  req.Header.Set("Content-Type", "application/json")
-  res, _ := client.Do(req)
-  body, err := ioutil.ReadAll(res.Body)
+  err := c.do(ctx, "POST", "/SaveAgent", true, payload, result)
   if err != nil {
-    return 0, fmt.Errorf("Could not read response body (%s)", err)
-  } else {
-    err2 := json.Unmarshal(body, &result)
-    if err2 != nil {
-      return 0, fmt.Errorf("Could not unmarshal body (%s)", err)
-    }
+    return 0, fmt.Errorf("Could not save agent: (%w)", err)
   }
-
   return result.Success, nil
 }
@@ -2,16 +2,16 @@
 package api
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
-	"net/http"
+	"math/big"
+	"units"
 )
 
 type Bill struct {
   BillId int `json:"bill_id"`
-  Amount float64 `json:"amount"`
+  Amount json.Number `json:"amount"`     // Decimal amount in tokens
 }
 
 type GetBillReq struct {
@@ -23,7 +23,7 @@ type ChannelSumReq struct  {
 }
 
 type ChannelSumRes struct {
-	Result float64 `json:"result"`
+	Result json.Number `json:"result"`
 }
 
 type GetBillRes struct {
@@ -32,7 +32,7 @@ type GetBillRes struct {
 
 type PayBillsData struct {
 	PaidIds []int `json:"paid_ids"`
-	BalanceRemaining int `json:"bal_remaining"`
+	BalanceRemaining json.Number `json:"bal_remaining"`
 }
 
 type PayBillsRes struct {
@@ -49,97 +49,64 @@ type BillPayReq struct {
 }
 
 /**
- * Get an array of Bill objects from the API. This is an authenticated request,
- * so a valid JSON web token must be included
+ * Get an array of unpaid Bill objects from the API. This is an authenticated
+ * request, so the client needs a token source
  *
+ * @param  ctx            Cancels the request
  * @param  serial_hash    Needed for request
- * @param  api            Base URI for the hub API
- * @param  token          JSON web token for the agent
  * @return                (array of bills, error)
  */
-func GetBills(serial_hash string, api string, token string) (*[]Bill, error) {
+func (c *Client) Bills(ctx context.Context, serial_hash string) (*[]Bill, error) {
 	var result = new(GetBillRes)
-
 	payload := GetBillReq{serial_hash}
-	b, _ := json.Marshal(payload)
-
-  client := &http.Client{}
-	req, _ := http.NewRequest("POST", api+"/Bills", bytes.NewBuffer(b))
-  req.Header.Set("x-access-token", token)
-	req.Header.Set("Content-Type", "application/json")
-	res, _ := client.Do(req)
-	body, err := ioutil.ReadAll(res.Body)
-  if err != nil {
-    return nil, fmt.Errorf("Could not read response body (%s)", err)
-  } else {
-    err2 := json.Unmarshal(body, &result)
-    if err2 != nil {
-      return nil, fmt.Errorf("Could not unmarshal body (%s)", err)
-    }
-  }
-  return &result.Result, nil
+	err := c.do(ctx, "POST", "/Bills", true, payload, result)
+	if err != nil {
+		return nil, fmt.Errorf("Could not get bills: (%w)", err)
+	}
+	return &result.Result, nil
 }
 
 /**
- * Get an array of Bill objects from the API. This is an authenticated request,
- * so a valid JSON web token must be included
+ * Pay a set of bills from the payment channel. This is an authenticated
+ * request, so the client needs a token source
  *
+ * @param  ctx           Cancels the request
  * @param  payload       Filled in BillPayReq object
- * @param  api           Base URI for the hub API
- * @param  auth_token    JSON web token for the agent
- * @return               (array of bill ids, error)
+ * @return               (error, array of bill ids, balance remaining in the channel)
  */
-func PayBills(payload *BillPayReq, api string, auth_token string) (error, []int, int) {
-	b, _ := json.Marshal(payload)
+func (c *Client) PayBills(ctx context.Context, payload *BillPayReq) (error, []int, *big.Int) {
 	var result = new(PayBillsRes)
-	client := &http.Client{}
-	req, _ := http.NewRequest("POST", api+"/PayBills", bytes.NewBuffer(b))
-  req.Header.Set("x-access-token", auth_token)
-	req.Header.Set("Content-Type", "application/json")
-	res, _ := client.Do(req)
-  body, err := ioutil.ReadAll(res.Body)
-  if err != nil {
-    return fmt.Errorf("Could not read response body (%s)", err), nil, 0
-  } else {
-    err2 := json.Unmarshal(body, &result)
-    if err2 != nil {
-      return fmt.Errorf("Could not unmarshal body (%s)", err2), nil, 0
-    }
-  }
-  return nil, result.Result.PaidIds, result.Result.BalanceRemaining
+	err := c.do(ctx, "POST", "/PayBills", true, payload, result)
+	if err != nil {
+		return fmt.Errorf("Could not pay bills: (%w)", err), nil, nil
+	}
+	remaining, err2 := units.ParseInt(result.Result.BalanceRemaining.String())
+	if err2 != nil {
+		return fmt.Errorf("Could not parse remaining balance (%s)", err2), result.Result.PaidIds, nil
+	}
+	return nil, result.Result.PaidIds, remaining
 }
 
 
 
 /**
- * Get the total amount of USD that has been commited to the channel.
- * Note that this is in USD, not tokens
+ * Get the total amount that has been commited to the channel, in atomic units
+ * of the token
  *
+ * @param  ctx           Cancels the request
  * @param  id            bytes32 id of the payment channel in question
- * @param  api           Full base uri of hub API
- * @param  auth_token    JSON web token
- * @return
+ * @return               (amount, error)
  */
-func GetChannelSum(id string, api string, auth_token string) (float64, error) {
+func (c *Client) ChannelSum(ctx context.Context, id string) (*big.Int, error) {
 	var result = new(ChannelSumRes)
-
 	payload := ChannelSumReq{id}
-	b, _ := json.Marshal(payload)
-
-	client := &http.Client{}
-	req, _ := http.NewRequest("POST", api+"/ChannelSum", bytes.NewBuffer(b))
-	req.Header.Set("x-access-token", auth_token)
-	req.Header.Set("Content-Type", "application/json")
-	res, _ := client.Do(req)
-	body, err := ioutil.ReadAll(res.Body)
+	err := c.do(ctx, "POST", "/ChannelSum", true, payload, result)
 	if err != nil {
-		return 0, fmt.Errorf("Could not read response body (%s)", err)
-	} else {
-		err2 := json.Unmarshal(body, &result)
-		if err2 != nil {
-			return 0, fmt.Errorf("Could not unmarshal body (%s)", err)
-		}
+		return nil, fmt.Errorf("Could not get channel sum: (%w)", err)
 	}
-	return result.Result, nil
-
+	if result.Result == "" {
+		// Nothing committed yet
+		return new(big.Int), nil
+	}
+	return units.ParseInt(result.Result.String())
 }
@@ -0,0 +1,222 @@
+package api
+
+import (
+  "bytes"
+  "context"
+  "encoding/json"
+  "errors"
+  "fmt"
+  "httpclient"
+  "io"
+  "io/ioutil"
+  "log"
+  "net/http"
+  "retry"
+  "strings"
+  "time"
+)
+
+const DEFAULT_USER_AGENT = "gridplus-agent"
+
+// Anything that can send an HTTP request. *http.Client is one.
+type Transport interface {
+  Do(req *http.Request) (*http.Response, error)
+}
+
+// Use a function as a Transport
+type TransportFunc func(req *http.Request) (*http.Response, error)
+
+func (f TransportFunc) Do(req *http.Request) (*http.Response, error) {
+  return f(req)
+}
+
+// Wraps a transport, e.g. to log or retry requests
+type Middleware func(next Transport) Transport
+
+// Supplies the JSON web token for authenticated routes
+type TokenSource interface {
+  Token(ctx context.Context) (string, error)
+}
+
+// A token that never changes
+type StaticToken string
+
+func (t StaticToken) Token(ctx context.Context) (string, error) {
+  return string(t), nil
+}
+
+type Client struct {
+  BaseURL string              // Full base URI of the hub API
+  Tokens TokenSource          // Token for authenticated routes (nil for none)
+  Transport Transport         // Sends requests. nil for the shared HTTP client
+  UserAgent string
+  Retry *retry.Policy         // Retries failed requests (nil for none)
+  PersonalSign bool           // Log in with personal_sign signatures instead of raw hash signatures
+  middleware []Middleware
+}
+
+/**
+ * Create a client for the hub API using the shared HTTP client.
+ *
+ * @param baseURL    Full base URI of the API
+ * @return           Client
+ */
+func NewClient(baseURL string) (*Client) {
+  policy := retry.Default
+  return &Client{
+    BaseURL: strings.TrimSuffix(baseURL, "/"),
+    UserAgent: DEFAULT_USER_AGENT,
+    Retry: &policy,
+  }
+}
+
+/**
+ * Wrap the transport in middleware. The first middleware added sees each
+ * request first.
+ *
+ * @param mw    Middleware to add
+ * @return      The client
+ */
+func (c *Client) Use(mw ...Middleware) (*Client) {
+  c.middleware = append(c.middleware, mw...)
+  return c
+}
+
+// Transport with all middleware applied
+func (c *Client) transport() (Transport) {
+  var t Transport = c.Transport
+  if t == nil {
+    t = httpclient.Client()
+  }
+  for i := len(c.middleware) - 1; i >= 0; i-- {
+    t = c.middleware[i](t)
+  }
+  return t
+}
+
+/**
+ * Send a request to the API and decode the JSON response. The response body
+ * is always closed. An authenticated request the hub rejects with a 401 is
+ * retried once with a fresh token if the token source can be invalidated.
+ * Failed requests return an *Error.
+ *
+ * Retryable failures are retried according to c.Retry. Only GETs, which have
+ * no side effects, and requests the hub rate limited (so never handled) are
+ * retried; a repeated POST could e.g. pay bills twice.
+ *
+ * @param ctx        Cancels the request
+ * @param method     HTTP method
+ * @param path       Route, e.g. "/Bills"
+ * @param auth       Whether to send the token from c.Tokens
+ * @param payload    Request body, marshalled to JSON (nil for none)
+ * @param result     Where to decode the response body
+ * @return           error
+ */
+func (c *Client) do(ctx context.Context, method string, path string, auth bool,
+payload interface{}, result interface{}) (error) {
+  var body []byte
+  if payload != nil {
+    var err error
+    body, err = json.Marshal(payload)
+    if err != nil {
+      return fmt.Errorf("Could not marshal request body (%w)", err)
+    }
+  }
+  if c.Retry == nil {
+    return c.attempt(ctx, method, path, auth, body, result)
+  }
+  policy := *c.Retry
+  custom := policy.Retryable
+  policy.Retryable = func(err error) (bool) {
+    if custom != nil && !custom(err) { return false }
+    return Retryable(err) && (method == "GET" || errors.Is(err, ErrRateLimited))
+  }
+  return policy.Do(ctx, func(ctx context.Context) (error) {
+    return c.attempt(ctx, method, path, auth, body, result)
+  })
+}
+
+// Send a request once (or twice, with a fresh token after a 401) and decode the response
+func (c *Client) attempt(ctx context.Context, method string, path string, auth bool,
+body []byte, result interface{}) (error) {
+  res, token, err := c.send(ctx, method, path, auth, body)
+  if err != nil { return err }
+  if res.StatusCode == http.StatusUnauthorized && token != "" {
+    // The token may have expired early or been revoked. Log in and try once more.
+    if inv, ok := c.Tokens.(Invalidator); ok {
+      res.Body.Close()
+      inv.Invalidate(token)
+      res, _, err = c.send(ctx, method, path, auth, body)
+      if err != nil { return err }
+    }
+  }
+  defer res.Body.Close()
+  resBody, err := ioutil.ReadAll(res.Body)
+  if err != nil {
+    return fmt.Errorf("Could not read response body (%w)", err)
+  }
+  if res.StatusCode < 200 || res.StatusCode > 299 {
+    return newError(res, method, path, resBody)
+  }
+  if err := json.Unmarshal(resBody, result); err != nil {
+    return fmt.Errorf("Could not unmarshal body (%w)", err)
+  }
+  return nil
+}
+
+// Send a single request. Returns the response and the token it was sent with.
+func (c *Client) send(ctx context.Context, method string, path string, auth bool,
+body []byte) (*http.Response, string, error) {
+  var reader io.Reader
+  if body != nil {
+    reader = bytes.NewReader(body)
+  }
+  req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, reader)
+  if err != nil {
+    return nil, "", fmt.Errorf("Could not form request (%w)", err)
+  }
+  if body != nil {
+    req.Header.Set("Content-Type", "application/json")
+  }
+  if c.UserAgent != "" {
+    req.Header.Set("User-Agent", c.UserAgent)
+  }
+  var token = ""
+  if auth && c.Tokens != nil {
+    token, err = c.Tokens.Token(ctx)
+    if err != nil {
+      return nil, "", fmt.Errorf("Could not get auth token (%w)", err)
+    }
+    req.Header.Set("x-access-token", token)
+  }
+  res, err := c.transport().Do(req)
+  if err != nil {
+    // Worth retrying unless we gave up on the request ourselves
+    return nil, "", &Error{Method: method, Endpoint: path, Err: err, Retryable: ctx.Err() == nil}
+  }
+  return res, token, nil
+}
+
+/**
+ * Middleware that logs each request with its status and duration.
+ *
+ * @param logger    Where to log. nil for the standard logger
+ * @return          Middleware
+ */
+func LogRequests(logger *log.Logger) (Middleware) {
+  if logger == nil {
+    logger = log.New(log.Writer(), log.Prefix(), log.Flags())
+  }
+  return func(next Transport) (Transport) {
+    return TransportFunc(func(req *http.Request) (*http.Response, error) {
+      start := time.Now()
+      res, err := next.Do(req)
+      if err != nil {
+        logger.Printf("%s %s failed after %s: %s", req.Method, req.URL.Path, time.Since(start), err)
+      } else {
+        logger.Printf("%s %s %s in %s", req.Method, req.URL.Path, res.Status, time.Since(start))
+      }
+      return res, err
+    })
+  }
+}
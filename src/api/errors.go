@@ -0,0 +1,126 @@
+// Errors returned by the hub API
+package api
+
+import (
+  "encoding/json"
+  "errors"
+  "fmt"
+  "net/http"
+  "strconv"
+  "strings"
+  "time"
+)
+
+// Error classes reported by the hub. Compare with errors.Is, e.g.
+// errors.Is(err, api.ErrUnauthorized)
+var (
+  ErrUnauthorized = errors.New("unauthorized")
+  ErrNotFound = errors.New("not found")
+  ErrRateLimited = errors.New("rate limited")
+  ErrInsufficientBalance = errors.New("insufficient balance")
+)
+
+// A failed hub API request
+type Error struct {
+  StatusCode int              // 0 if the hub could not be reached
+  Method string
+  Endpoint string             // Route, e.g. "/Bills"
+  Message string              // Error message from the hub, if it sent one
+  Retryable bool              // Whether the same request may succeed later
+  RetryAfter time.Duration    // How long the hub asked us to wait, if it did
+  Err error                   // Underlying transport error, if any
+}
+
+func (err *Error) Error() (string) {
+  if err.StatusCode == 0 {
+    return fmt.Sprintf("Could not reach %s %s (%s)", err.Method, err.Endpoint, err.Err)
+  }
+  if err.Message != "" {
+    return fmt.Sprintf("(%d): Error in %s %s: %s", err.StatusCode, err.Method, err.Endpoint, err.Message)
+  }
+  return fmt.Sprintf("(%d): Error in %s %s", err.StatusCode, err.Method, err.Endpoint)
+}
+
+// How long the hub asked us to wait; see retry.Delayer
+func (err *Error) RetryDelay() (time.Duration) {
+  return err.RetryAfter
+}
+
+func (err *Error) Unwrap() (error) {
+  return err.Err
+}
+
+// Match an Error against the error classes above by status code, or by the
+// hub's message where the status is ambiguous
+func (err *Error) Is(target error) (bool) {
+  switch target {
+  case ErrUnauthorized:
+    return err.StatusCode == http.StatusUnauthorized || err.StatusCode == http.StatusForbidden
+  case ErrNotFound:
+    return err.StatusCode == http.StatusNotFound
+  case ErrRateLimited:
+    return err.StatusCode == http.StatusTooManyRequests
+  case ErrInsufficientBalance:
+    return err.StatusCode == http.StatusPaymentRequired ||
+      (err.StatusCode >= 400 && strings.Contains(strings.ToLower(err.Message), "insufficient"))
+  }
+  return false
+}
+
+/**
+ * Check if a failed request is worth sending again.
+ *
+ * @param err    Error returned by a Client method
+ * @return       true if the same request may succeed later
+ */
+func Retryable(err error) (bool) {
+  var apiErr *Error
+  return errors.As(err, &apiErr) && apiErr.Retryable
+}
+
+// Build an Error from a non-2xx response
+func newError(res *http.Response, method string, endpoint string, body []byte) (*Error) {
+  err := &Error{
+    StatusCode: res.StatusCode,
+    Method: method,
+    Endpoint: endpoint,
+    Message: errorMessage(body),
+  }
+  switch {
+  case res.StatusCode == http.StatusTooManyRequests,
+    res.StatusCode == http.StatusRequestTimeout,
+    res.StatusCode >= 500:
+    err.Retryable = true
+  }
+  if secs, err2 := strconv.Atoi(res.Header.Get("Retry-After")); err2 == nil && secs > 0 {
+    err.RetryAfter = time.Duration(secs) * time.Second
+  }
+  return err
+}
+
+// Pull the message out of an error body. The hub answers with
+// {"error": "..."} or {"message": "..."}, and proxies in front of it with text.
+func errorMessage(body []byte) (string) {
+  var res struct {
+    Error interface{} `json:"error"`
+    Message string `json:"message"`
+  }
+  if json.Unmarshal(body, &res) == nil {
+    switch e := res.Error.(type) {
+    case string:
+      if e != "" {
+        return e
+      }
+    case map[string]interface{}:
+      if msg, ok := e["message"].(string); ok {
+        return msg
+      }
+    }
+    return res.Message
+  }
+  msg := strings.TrimSpace(string(body))
+  if len(msg) > 200 {
+    msg = msg[:200] + "..."
+  }
+  return msg
+}
@@ -1,116 +1,82 @@
 package api
 
 import (
-  "encoding/json"
+  "context"
   "fmt"
-  "io/ioutil"
-  "net/http"
+  "math/big"
 )
 
 type GetRes struct {
   Result string
 }
 
+type GasRes struct {
+  Gas int64 `json:"gas"`
+  GasPrice int64 `json:"gasPrice"`
+}
+
 
 /**
  * Query the API for the registry contract address.
  *
- * @param  api    Full base URI of the api
+ * @param  ctx    Cancels the request
  * @return        (contract address, error)
  */
-func GetRegistry(api string) (string, error) {
-  var result = new(GetRes)
-  res, err := http.Get(api+"/Registry")
-  if err != nil {
-    return "", fmt.Errorf("Could not get registry address: ", err)
-  } else {
-    body, err2 := ioutil.ReadAll(res.Body)
-    if err2 != nil {
-      return "", fmt.Errorf("Could not read response body: ", err2)
-    } else {
-      err3 := json.Unmarshal(body, &result)
-      if err3 != nil {
-        return "", fmt.Errorf("Could not unmarshal response body: ", err3)
-      }
-    }
-  }
-  return result.Result, nil
+func (c *Client) Registry(ctx context.Context) (string, error) {
+  return c.address(ctx, "/Registry", "registry")
 }
 
 /**
  * Query the API for the BOLT token contract address.
  *
- * @param  api    Full base URI of the api
+ * @param  ctx    Cancels the request
  * @return        (contract address, error)
  */
-func GetBOLT(api string) (string, error) {
-  var result = new(GetRes)
-  res, err := http.Get(api+"/BOLT")
-  if err != nil {
-    return "", fmt.Errorf("Could not get registry address: ", err)
-  } else {
-    body, err2 := ioutil.ReadAll(res.Body)
-    if err2 != nil {
-      return "", fmt.Errorf("Could not read response body: ", err2)
-    } else {
-      err3 := json.Unmarshal(body, &result)
-      if err3 != nil {
-        return "", fmt.Errorf("Could not unmarshal response body: ", err3)
-      }
-    }
-  }
-  return result.Result, nil
+func (c *Client) BOLT(ctx context.Context) (string, error) {
+  return c.address(ctx, "/BOLT", "BOLT")
 }
 
-
 /**
  * Get the Ethereum address to send payments to (a.k.a. the hub address)
  *
- * @param  api    Full base URI of the api
+ * @param  ctx    Cancels the request
  * @return        (hub address, error)
  */
-func GetHubAddr(api string) (string, error) {
-  var result = new(GetRes)
-  res, err := http.Get(api+"/Hub")
-  if err != nil {
-    return "", fmt.Errorf("Could not get registry address: ", err)
-  } else {
-    body, err2 := ioutil.ReadAll(res.Body)
-    if err2 != nil {
-      return "", fmt.Errorf("Could not read response body: ", err2)
-    } else {
-      err3 := json.Unmarshal(body, &result)
-      if err3 != nil {
-        return "", fmt.Errorf("Could not unmarshal response body: ", err3)
-      }
-    }
-  }
-  return result.Result, nil
+func (c *Client) HubAddr(ctx context.Context) (string, error) {
+  return c.address(ctx, "/Hub", "hub")
+}
 
+/**
+ * Get the address of the payment channel contract
+ *
+ * @param  ctx    Cancels the request
+ * @return        (contract address, error)
+ */
+func (c *Client) ChannelsAddr(ctx context.Context) (string, error) {
+  return c.address(ctx, "/Channels", "channels")
 }
 
 /**
- * Get the Ethereum address to send payments to (a.k.a. the hub address)
+ * Get the default gas limit and gas price the hub recommends.
  *
- * @param  api    Full base URI of the api
- * @return        (hub address, error)
+ * @param  ctx    Cancels the request
+ * @return        (gas, gas price in wei, error)
  */
-func GetChannelsAddr(api string) (string, error) {
+func (c *Client) Gas(ctx context.Context) (*big.Int, *big.Int, error) {
+  var result = new(GasRes)
+  err := c.do(ctx, "GET", "/Gas", false, nil, result)
+  if err != nil {
+    return nil, nil, fmt.Errorf("Could not get default gas: (%w)", err)
+  }
+  return big.NewInt(result.Gas), big.NewInt(result.GasPrice), nil
+}
+
+// Get one of the address routes
+func (c *Client) address(ctx context.Context, path string, name string) (string, error) {
   var result = new(GetRes)
-  res, err := http.Get(api+"/Channels")
+  err := c.do(ctx, "GET", path, false, nil, result)
   if err != nil {
-    return "", fmt.Errorf("Could not get channels address: ", err)
-  } else {
-    body, err2 := ioutil.ReadAll(res.Body)
-    if err2 != nil {
-      return "", fmt.Errorf("Could not read response body: ", err2)
-    } else {
-      err3 := json.Unmarshal(body, &result)
-      if err3 != nil {
-        return "", fmt.Errorf("Could not unmarshal response body: ", err3)
-      }
-    }
+    return "", fmt.Errorf("Could not get %s address: (%w)", name, err)
   }
   return result.Result, nil
-
 }
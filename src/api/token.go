@@ -0,0 +1,139 @@
+package api
+
+import (
+  "context"
+  "encoding/base64"
+  "encoding/json"
+  "fmt"
+  "log"
+  "strings"
+  "sync"
+  "time"
+)
+import "sig"
+
+// Refresh tokens this long before they expire
+const TOKEN_REFRESH_MARGIN = time.Minute
+
+// A token source that can be told the hub rejected one of its tokens
+type Invalidator interface {
+  Invalidate(token string)
+}
+
+// Logs in with the wallet key and keeps the token fresh. Safe to share
+// between goroutines.
+type LoginSource struct {
+  Margin time.Duration        // Refresh this long before expiry
+  client *Client
+  signer sig.Signer
+  mu sync.Mutex
+  token string
+  expiry time.Time            // Zero if the token has no exp claim
+}
+
+/**
+ * Create a token source that runs the /AuthDatum + /Authenticate handshake
+ * whenever it has no token or its token is about to expire.
+ *
+ * @param c         Client to log in with
+ * @param signer    Signer of the agent's wallet
+ * @return          Token source
+ */
+func NewLoginSource(c *Client, signer sig.Signer) (*LoginSource) {
+  return &LoginSource{Margin: TOKEN_REFRESH_MARGIN, client: c, signer: signer}
+}
+
+/**
+ * Get a token that is valid for at least the refresh margin, logging in
+ * again if needed.
+ */
+func (s *LoginSource) Token(ctx context.Context) (string, error) {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+  if s.token != "" && (s.expiry.IsZero() || time.Until(s.expiry) > s.Margin) {
+    return s.token, nil
+  }
+  if s.token != "" {
+    log.Println("Auth token expires at", s.expiry, "- refreshing")
+  }
+  token, err := s.client.Login(ctx, s.signer)
+  if err != nil {
+    return "", err
+  }
+  s.set(token)
+  return s.token, nil
+}
+
+/**
+ * Use a token obtained elsewhere, e.g. during setup.
+ */
+func (s *LoginSource) SetToken(token string) {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+  s.set(token)
+}
+
+/**
+ * Drop a token the hub rejected, so the next call logs in again. Does nothing
+ * if the token was already replaced.
+ */
+func (s *LoginSource) Invalidate(token string) {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+  if s.token == token {
+    s.token = ""
+    s.expiry = time.Time{}
+  }
+}
+
+/**
+ * Drop the current token, whatever it is, so the next call logs in again.
+ */
+func (s *LoginSource) Reset() {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+  s.token = ""
+  s.expiry = time.Time{}
+}
+
+func (s *LoginSource) set(token string) {
+  expiry, err := TokenExpiry(token)
+  if err != nil {
+    // Still usable; we'll find out it expired when the hub rejects it
+    log.Println("Could not read auth token expiry:", err)
+  }
+  s.token = token
+  s.expiry = expiry
+}
+
+/**
+ * Read the expiry (exp claim) of a JSON web token. The signature is not
+ * checked; that's the hub's job.
+ *
+ * @param token    JSON web token
+ * @return         Expiry (zero if the token has none), error
+ */
+func TokenExpiry(token string) (time.Time, error) {
+  parts := strings.Split(token, ".")
+  if len(parts) != 3 {
+    return time.Time{}, fmt.Errorf("Malformed JSON web token")
+  }
+  payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+  if err != nil {
+    return time.Time{}, fmt.Errorf("Could not decode JSON web token (%w)", err)
+  }
+  var claims struct {
+    Exp json.Number `json:"exp"`
+  }
+  if err := json.Unmarshal(payload, &claims); err != nil {
+    return time.Time{}, fmt.Errorf("Could not unmarshal JSON web token claims (%w)", err)
+  }
+  if claims.Exp == "" {
+    return time.Time{}, nil
+  }
+  exp, err := claims.Exp.Float64()
+  if err != nil {
+    return time.Time{}, fmt.Errorf("Invalid exp claim %q", claims.Exp)
+  }
+  return time.Unix(int64(exp), 0), nil
+}
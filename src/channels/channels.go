@@ -1,136 +1,206 @@
 // Functions for dealing with payment channels
 package channels
 
+import "context"
+import "contracts"
+import "errors"
+import "fmt"
 import "log"
+import "retry"
 import "rpc"
+import "sig"
 import "time"
-import "fmt"
-import "strconv"
+import "math/big"
 
 type Channel struct {
   Id string `json:"id"`
   Token string `json:"token"`
   Recipient string `json:"recipient"`
-  Deposit uint64 `json:"deposit"`
+  Deposit *big.Int `json:"deposit"`
 }
 
 var channel = Channel{}
 
 /**
- * Make initial connection to RPC provider. Save that connection in memory.
+ * Open a payment channel and deposit tokens in it. The channel contract is
+ * first given an allowance for the deposit.
  *
- * @param provider    Full URI of the RPC provider, including the protocol
- *                    and port
+ * @param signer     Signer of the channel spender (the agent's wallet)
+ * @param manager    Payment channel contract
+ * @param token      Token to deposit (BOLT)
+ * @param to         Channel recipient
+ * @param amount     Deposit in atomic units of the token
+ * @param API        Full base URI of the hub API
+ * @return           Id of the new channel, error if a transaction would revert
+ *                   (e.g. the allowance isn't visible yet) or was dropped,
+ *                   replaced or failed. Retry it later.
  */
-func OpenChannel(from string, channel_addr string, token string,
-to string, _amount uint64, pkey string, API string) (string) {
-  amount := fmt.Sprintf("%x", _amount)
-  // 1. Set an allowance
-  var allowance_data = "0x095ea7b3" + rpc.Zfill(channel_addr) + rpc.Zfill(string(amount))
-  allowance_tx := rpc.DefaultRawTx(from, token, allowance_data, pkey, API)
-  var allowance_txhash = ""
-  for allowance_txhash == "" {
-    err, _allow_txhash := rpc.SendRaw(allowance_tx)
+func OpenChannel(signer sig.Signer, manager *contracts.ChannelManager, token *contracts.ERC20,
+to string, amount *big.Int, API string) (string, error) {
+  opts := &contracts.TransactOpts{Signer: signer, API: API}
+  // 1. Set an allowance, unless an earlier attempt already did
+  allowance, err := token.Allowance(signer.Address(), manager.Address)
+  if err != nil || allowance.Cmp(amount) < 0 {
+    allowance_txhash, err := submit("setting allowance", func() (string, error) {
+      return token.Approve(opts, manager.Address, amount)
+    })
     if err != nil {
-      log.Print("Error setting allowance (%s)", err)
-      time.Sleep(time.Second*10)
-    } else {
-      allowance_txhash = _allow_txhash
+      return "", err
     }
+    // Wait until the tx is mined
+    outcome := wait(allowance_txhash, signer)
+    if outcome.Status != rpc.TxMined {
+      return "", fmt.Errorf("Could not set allowance: tx %s %s", allowance_txhash, outcome.Status)
+    }
+  }
+  // 2. Open the channel
+  txhash, err := submit("opening channel", func() (string, error) {
+    return manager.OpenChannel(opts, token.Address, to, amount)
+  })
+  if err != nil {
+    return "", err
   }
   // Wait until the tx is mined
-  var mined = false
-  for mined == false {
-    success, _ := rpc.CheckReceipt(allowance_txhash)
-    if success == 1 {
-      mined = true
-    } else if success == -1 {
-      log.Panic("Error: Could not set allowance")
-    } else {
-      time.Sleep(time.Second*10)
+  outcome := wait(txhash, signer)
+  if outcome.Status != rpc.TxMined {
+    return "", fmt.Errorf("Could not open payment channel: tx %s %s", txhash, outcome.Status)
+  }
+  // Get the channel id from the contract
+  for channel.Id == "" {
+    id, err := manager.ChannelId(signer.Address(), to)
+    if err != nil {
+      log.Print("Could not get channel id ", err)
+    }
+    channel.Id = id
+    if channel.Id == "" {
+      // Look again once the channel contract logs something
+      rpc.WaitForLog(context.Background(), manager.Address, nil, time.Second*10)
     }
   }
-  // 2. Open the channel
-  var data = "0xcfa40e4f" + rpc.Zfill(token) + rpc.Zfill(to) + rpc.Zfill(string(amount))
-  var gas = uint64(200000)
-  _, _gasPrice := rpc.DefaultGas(API)
-  var gasPrice = _gasPrice.Uint64()
+  // Fill in the rest of the channel info
+  channel.Token = token.Address
+  channel.Recipient = to
+  channel.Deposit = amount
+  return channel.Id, nil
+}
+
+
+/**
+ * Sign and submit a transaction until the node accepts it. The transaction is
+ * re-signed (with a fresh nonce) if the node rejects the nonce it was given.
+ *
+ * @param action    Description of the transaction, used for logging
+ * @param sign      Returns a newly signed raw transaction
+ * @return          Transaction hash, error if the transaction would revert
+ */
+func submit(action string, sign func() (string, error)) (string, error) {
   var txhash = ""
+  var rawtx = ""
+  backoff := retry.Backoff{Policy: retry.Forever}
   for txhash == "" {
-    rawtx := rpc.RawTx(from, channel_addr, data, pkey, gas, gasPrice, 0)
+    if rawtx == "" {
+      _rawtx, err := sign()
+      if errors.Is(err, rpc.ErrExecutionReverted) {
+        // Don't spend ether on a transaction that is going to throw
+        return "", fmt.Errorf("Transaction for %s would revert (%w)", action, err)
+      } else if err != nil {
+        log.Printf("Could not sign tx for %s (%s)", action, err)
+        backoff.Wait(context.Background())
+        continue
+      }
+      rawtx = _rawtx
+    }
     err, _txhash := rpc.SendRaw(rawtx)
-    if err != nil || _txhash == "" {
-      log.Print("Error opening channel (%s)", err)
-      time.Sleep(time.Second*10)
-    } else {
+    if _txhash != "" {
+      // Accepted, or the node already had it
       txhash = _txhash
-    }
-  }
-  // Wait until the tx is mined
-  mined = false
-  for mined == false {
-    success, _ := rpc.CheckReceipt(txhash)
-    if success == 1 {
-      mined = true
-      // Get the channel id and record it
-      for channel.Id == "" {
-        var data = "0x2460ee73" + rpc.Zfill(from) + rpc.Zfill(to)
-        _, id := rpc.MakeCall(from, channel_addr, data)
-        channel.Id = id
-        if channel.Id == "" {
-          time.Sleep(time.Second*10)
-        }
-      }
-      // Fill in the rest of the channel info
-      channel.Token = token
-      channel.Recipient = to
-      channel.Deposit = _amount
-    } else if success == -1 {
-      log.Panic("Error: Could not open payment channel")
+    } else if errors.Is(err, rpc.ErrNonceTooLow) || errors.Is(err, rpc.ErrReplacementUnderpriced) {
+      log.Printf("Nonce already used when %s, re-signing (%s)", action, err)
+      rawtx = ""
+    } else if errors.Is(err, rpc.ErrInsufficientFunds) {
+      log.Printf("Insufficient ether for %s. Waiting for funds. (%s)", action, err)
+      rawtx = ""
+      backoff.Wait(context.Background())
     } else {
-      time.Sleep(time.Second*10)
+      log.Printf("Error %s (%s)", action, err)
+      rawtx = ""
+      backoff.Wait(context.Background())
     }
   }
-  return channel.Id
+  return txhash, nil
+}
+
+
+/**
+ * Wait for a transaction to be confirmed, retrying if the node can't be reached
+ * and bumping the gas price if it gets stuck
+ *
+ * @param txhash    Hash of the transaction
+ * @param signer    Signer of the sender
+ * @return          Outcome of the transaction
+ */
+func wait(txhash string, signer sig.Signer) (*rpc.TxOutcome) {
+  var outcome *rpc.TxOutcome
+  policy := retry.Forever
+  policy.OnRetry = func(attempt int, err error, delay time.Duration) {
+    log.Printf("Error waiting for tx %s (%s)", txhash, err)
+  }
+  policy.Do(context.Background(), func(ctx context.Context) (error) {
+    var err error
+    outcome, err = rpc.WaitOrBump(ctx, txhash, signer, rpc.DEFAULT_CONFIRMATIONS)
+    return err
+  })
+  return outcome
 }
 
 
 /**
  * Check the blockchain for an existing channel
  *
- * @param from              Channel spender
- * @param to                Channel recipient
- * @param channels_addr     Channel contract address
- * @return                  Id of existing channel or ""
+ * @param manager    Payment channel contract
+ * @param from       Channel spender
+ * @param to         Channel recipient
+ * @return           Id of existing channel or ""
  */
-func CheckForChanneId(from string, to string, channels_addr string) (string) {
-  var data = "0x2460ee73" + rpc.Zfill(from) + rpc.Zfill(to)
-  err, id := rpc.MakeCall(from, channels_addr, data)
+func CheckForChanneId(manager *contracts.ChannelManager, from string, to string) (string) {
+  id, err := manager.ChannelId(from, to)
   if err != nil {
     log.Print("Could not get channel", err)
     return ""
   } else if id == "" {
     return ""
-  } else if id == "0x0000000000000000000000000000000000000000000000000000000000000000" {
-    return ""
+  }
+  channel.Id = id
+  // Get the deposit too
+  deposit, err2 := manager.Deposit(from, id)
+  if err2 != nil {
+    log.Printf("Could not get deposit from channel %s: %s", id, err2)
   } else {
-    channel.Id = id
-    // Get the deposit too
-    var deposit_data = "0x2f748d7b" + rpc.Zfill(id)
-    err2, deposit := rpc.MakeCall(from, channels_addr, deposit_data)
-    if err2 != nil {
-      log.Printf("Could not get deposit from channel %s: %s", id, err2)
-    } else {
-      channel.Deposit, _ = strconv.ParseUint(deposit, 0, 64)
-    }
-    return id
+    channel.Deposit = deposit
+  }
+  return id
+}
+
+/**
+ * Record a channel looked up elsewhere (e.g. in a batch). An empty or zero id
+ * means there is no channel.
+ *
+ * @param id         Id of the channel
+ * @param deposit    Deposit in the channel
+ */
+func SetChannel(id string, deposit *big.Int) {
+  if id == contracts.NO_CHANNEL {
+    id = ""
   }
+  channel.Id = id
+  channel.Deposit = deposit
 }
 
 func GetChannelId() (string) {
   return channel.Id
 }
 
-func GetDeposit() (uint64) {
+func GetDeposit() (*big.Int) {
+  if channel.Deposit == nil { return new(big.Int) }
   return channel.Deposit
 }
@@ -3,21 +3,42 @@ package config
 
 import (
   "encoding/hex"
+  "fmt"
+  "hdwallet"
   "github.com/spf13/viper"
+  "httpclient"
+  "keystore"
   "log"
   "sig"
+  "time"
 )
 
 type Config struct {
   API string                    // Host of the Grid+ API
+  PersonalSign bool             // Log in to the hub with personal_sign signatures
   Provider string               // RPC provider (including port)
+  Providers []string            // All RPC providers to fail over between
+  WSProvider string             // WebSocket RPC provider for block/log subscriptions (optional)
   SerialNo string               // Serial number of the agent
   HashedSerialNo string         // Keccak256 hash of SerialNo
-  SetupPkey string              // Agent's private key (for setup)
+  SetupSigner sig.Signer        // Signs with the agent's setup key (nil if there is none)
   SetupAddr string              // Ethereum address corresponding to private key
-  WalletKeyPath string          // Absolute file path for wallet key file
-  WalletPkey string             // Agent's permanent wallet key (for moving tokens)
+  WalletKeyPath string          // Absolute path of the directory holding the wallet keystore
+  WalletPassphraseFile string   // File holding the keystore passphrase (optional)
+  LightKDF bool                 // Encrypt new keystores with cheaper scrypt parameters
+  DerivationPath string         // BIP-44 path of the wallet key under its mnemonic
+  SignerMode string             // Where the wallet key lives: "memory" or "remote"
+  SignerEndpoint string         // URL or socket path of the remote signer
+  WalletSigner sig.Signer       // Signs with the agent's permanent wallet key (for moving tokens)
   WalletAddr string             // Agent's wallet address
+  BumpTimeout time.Duration     // Wait this long before bumping a stuck tx's gas price
+  BumpPercent int64             // Gas price increase per bump (at least 10)
+  MaxGasPrice int64             // Highest gas price (wei) a bump may use
+  MaxFee int64                  // Highest fee (wei) a bumped tx may cost
+  FeeMode string                // "legacy", "dynamic" (EIP-1559) or "auto"
+  HTTP httpclient.Config        // Timeouts for hub API and RPC requests (zero for defaults)
+  RegistryABI string            // ABI file of the Registry, to decode its events (optional)
+  ChannelsABI string            // ABI file of the payment channel contract (optional)
 }
 
 // Load the config file and get system-level parameters
@@ -33,17 +54,60 @@ func Load() (Config) {
   } else {
     // Get normal config data
     _config.API = viper.GetString("development.gridplus_api")
+    _config.PersonalSign = viper.GetBool("api.personal_sign")
     _config.Provider = viper.GetString("development.rpc_provider")
+    _config.WSProvider = viper.GetString("development.ws_provider")
+    _config.Providers = viper.GetStringSlice("development.rpc_providers")
+    if len(_config.Providers) == 0 && _config.Provider != "" {
+      _config.Providers = []string{_config.Provider}
+    } else if _config.Provider == "" {
+      _config.Provider = _config.Providers[0]
+    }
     _config.WalletKeyPath = viper.GetString("wallet.key_path")
+    _config.WalletPassphraseFile = viper.GetString("wallet.passphrase_file")
+    _config.LightKDF = viper.GetBool("wallet.light_kdf")
+    _config.DerivationPath = viper.GetString("wallet.derivation_path")
+    if _config.DerivationPath == "" {
+      _config.DerivationPath = hdwallet.KeyPath(hdwallet.BASE_PATH, hdwallet.WALLET_INDEX)
+    }
+    _config.SignerMode = viper.GetString("wallet.signer")
+    if _config.SignerMode == "keystore" {
+      // Decrypting for every signature was dropped: it cost seconds of scrypt
+      // per signature while the passphrase stayed in memory anyway
+      log.Println("WARNING: wallet.signer = \"keystore\" is no longer supported, keeping the key in memory")
+      _config.SignerMode = SIGNER_MEMORY
+    }
+    if _config.SignerMode == "" {
+      _config.SignerMode = SIGNER_MEMORY
+    }
+    _config.SignerEndpoint = viper.GetString("wallet.signer_endpoint")
+    wallet_addr := viper.GetString("wallet.address")
+    // Optional transaction settings
+    _config.BumpTimeout = viper.GetDuration("transactions.bump_timeout")
+    _config.BumpPercent = viper.GetInt64("transactions.bump_percent")
+    _config.MaxGasPrice = viper.GetInt64("transactions.max_gas_price")
+    _config.MaxFee = viper.GetInt64("transactions.max_fee")
+    _config.FeeMode = viper.GetString("transactions.fee_mode")
+    // Optional HTTP settings
+    _config.HTTP.Timeout = viper.GetDuration("http.timeout")
+    _config.HTTP.DialTimeout = viper.GetDuration("http.dial_timeout")
+    _config.HTTP.ResponseHeaderTimeout = viper.GetDuration("http.response_header_timeout")
+    _config.HTTP.MaxIdleConnsPerHost = viper.GetInt("http.max_idle_conns_per_host")
+    // Optional contract ABIs
+    _config.RegistryABI = viper.GetString("contracts.registry_abi")
+    _config.ChannelsABI = viper.GetString("contracts.channels_abi")
 
     // Get setup key
+    var setup_keystore = ""
+    var setup_pkey = ""
     viper.SetConfigName("setup_keys")
     viper.AddConfigPath("config")
     err2 := viper.ReadInConfig()
     if err2 != nil {
       log.Fatal("Could not find crypto keypair at 'config/setup_keys.toml'")
     } else {
-      _config.SetupPkey = viper.GetString("agent.pkey")
+      setup_keystore = viper.GetString("agent.keystore")
+      setup_pkey = viper.GetString("agent.pkey")
       _config.SetupAddr = viper.GetString("agent.addr")
       _config.SerialNo = viper.GetString("agent.serial_no")
       if _config.SerialNo == "" {
@@ -55,35 +119,73 @@ func Load() (Config) {
       log.Println("hashed serial", _config.HashedSerialNo)
     }
 
-    // Create (or get) wallet key
-    wallet_key, err := getKey(_config.WalletKeyPath)
-    if err != nil || wallet_key == "" {
-      // We can assume that any read errors mean there's no key. Let's create one.
-      err2 := createKey(_config.WalletKeyPath, 32)
-      if err2 != nil {
-        log.Panic("Could not create wallet key:", err2)
-      } else {
-        wallet_key, err3 := getKey(_config.WalletKeyPath)
-        if err3 != nil {
-          log.Panic("Could not retrieve newly created wallet key:", err3)
-        } else {
-          _config.WalletPkey = wallet_key
-          wallet_addr, err4 := getAddr(_config.WalletKeyPath)
-          if err4 != nil {
-            log.Panic("Could not derive address from wallet key", err4)
-          }
-          _config.WalletAddr = wallet_addr
-        }
+    // Unlock the wallet key, unless another process holds it. A new
+    // passphrase is asked for twice, including when a legacy wallet.pem is
+    // about to be encrypted with it.
+    var passphrase = ""
+    if _config.SignerMode != SIGNER_REMOTE || setup_keystore != "" {
+      passphrase, err = keystore.Passphrase(_config.WalletPassphraseFile,
+        _config.SignerMode != SIGNER_REMOTE && !keystoreExists(_config.WalletKeyPath))
+      if err != nil {
+        log.Panic("Could not get wallet passphrase: ", err)
+      }
+    }
+    if _config.SignerMode == SIGNER_REMOTE {
+      _config.WalletSigner, err = sig.NewRemoteSigner(_config.SignerEndpoint, wallet_addr)
+      if err != nil {
+        log.Panic("Could not connect to remote signer: ", err)
       }
     } else {
-      _config.WalletPkey = wallet_key
-      wallet_addr, err5 := getAddr(_config.WalletKeyPath)
-      if err5 != nil {
-        log.Panic("Could not derive address from wallet key", err5)
+      // Earlier versions kept the key unencrypted, so convert that file first
+      // if there is one.
+      migrated, err := migrateKey(_config.WalletKeyPath, passphrase, _config.LightKDF)
+      if err != nil {
+        log.Panic("Could not encrypt existing wallet key: ", err)
+      } else if migrated {
+        log.Println("Moved wallet key from", LEGACY_KEY_FILE, "to encrypted keystore", KEYSTORE_FILE)
+      }
+      if !keyExists(_config.WalletKeyPath) {
+        mnemonic, addr, err2 := NewWallet(_config.WalletKeyPath, passphrase, _config.LightKDF, _config.DerivationPath)
+        if err2 != nil {
+          log.Panic("Could not create wallet key:", err2)
+        }
+        log.Println("Created wallet", addr, "at", _config.DerivationPath)
+        ShowMnemonic(mnemonic, _config.DerivationPath)
       }
-      _config.WalletAddr = wallet_addr
+      _config.WalletSigner, err = getSigner(_config.WalletKeyPath, passphrase, _config.SignerMode)
+      if err != nil {
+        log.Panic("Could not unlock wallet key:", err)
+      }
+    }
+    _config.WalletAddr = _config.WalletSigner.Address()
+
+    // The setup key may be in a keystore too, unlocked with the same passphrase
+    if setup_keystore != "" {
+      _config.SetupSigner, err = sig.LoadKeySigner(setup_keystore, passphrase)
+      if err != nil {
+        log.Panic("Could not unlock setup key:", err)
+      }
+    } else if setup_pkey != "" {
+      log.Println("WARNING: setup key is stored in plaintext in setup_keys.toml. Set agent.keystore to an encrypted keystore instead.")
+      _config.SetupSigner, err = sig.ParseKeySigner(setup_pkey)
+      if err != nil {
+        log.Panic("Could not parse setup key:", err)
+      }
+    }
+    if _config.SetupSigner != nil {
+      _config.SetupAddr = _config.SetupSigner.Address()
     }
 
   };
   return _config
 }
+
+/**
+ * Show a new wallet's mnemonic once, so the owner can write it down. It is
+ * never saved.
+ */
+func ShowMnemonic(mnemonic string, hdpath string) {
+  fmt.Printf("\x1b[33;1mWrite down these words and keep them safe. They are the only\n")
+  fmt.Printf("way to recover the wallet (derivation path %s) if this device is lost:\x1b[0m\n\n", hdpath)
+  fmt.Printf("  %s\n\n", mnemonic)
+}
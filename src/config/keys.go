@@ -5,72 +5,247 @@ as well as recovering an Ethereum address from it
 package config
 
 import (
+    "bytes"
     "crypto/rand"
     "encoding/hex"
+    "fmt"
+    "hdwallet"
     "io/ioutil"
+    "keystore"
+    "os"
+    "path/filepath"
+    "sig"
+    "time"
     secp256k1 "github.com/haltingstate/secp256k1-go"
     "github.com/ebfe/keccak"
 )
 
+// Files in the wallet key directory
+const KEYSTORE_FILE = "wallet.json"       // V3 keystore holding the wallet key
+const LEGACY_KEY_FILE = "wallet.pem"      // Raw key written by earlier versions
+
+const PENDING_KEY_FILE = "wallet.next.json"   // Key being rotated in
+const ARCHIVE_DIR = "archive"                 // Keystores of rotated out keys
+
+// Ways of holding the wallet key (wallet.signer)
+const SIGNER_MEMORY = "memory"            // Decrypted once at startup
+const SIGNER_REMOTE = "remote"            // Held by a Clef-compatible signer
+
 /**
- * Generate a private key and save it to disk
+ * Generate a mnemonic, derive the wallet key from it and save the key to disk
+ * as an encrypted keystore. The mnemonic is the only backup of the key.
  *
- * @param path {string} - absolute file path from which to get the key
- * @param n {int}       - number of bytes to randomly generate. Should be 32 always
- * @returns (error)
+ * @param path {string}       - directory in which to save the key
+ * @param passphrase {string} - passphrase to encrypt the key with
+ * @param light {bool}        - use the light scrypt parameters
+ * @param hdpath {string}     - derivation path of the key
+ * @returns (string, string, error) - mnemonic, wallet address, error
  */
-func createKey(path string, n int) (error) {
-  b, err := GenerateRandomBytes(n)
-  if (err == nil) {
-    err2 := keyToFile(b, path)
-    if (err2 != nil) { return err2 }
-  } else {
-    return err
-  }
-  return nil
+func NewWallet(path string, passphrase string, light bool, hdpath string) (string, string, error) {
+  mnemonic, err := hdwallet.NewMnemonic()
+  if err != nil { return "", "", err }
+  addr, err := RestoreWallet(path, mnemonic, passphrase, light, hdpath)
+  if err != nil { return "", "", err }
+  return mnemonic, addr, nil
 }
 
 /**
- * Get the private key from a file
+ * Derive the wallet key from an existing mnemonic and save it to disk as an
+ * encrypted keystore. An existing wallet key is never overwritten.
  *
- * @param path {string} - absolute file path from which to get the key
- * @returns (string, error) - private key (hex), error
+ * @param path {string}       - directory in which to save the key
+ * @param mnemonic {string}   - BIP-39 mnemonic
+ * @param passphrase {string} - passphrase to encrypt the key with
+ * @param light {bool}        - use the light scrypt parameters
+ * @param hdpath {string}     - derivation path of the key
+ * @returns (string, error) - wallet address, error
  */
-func getKey(path string) (string, error) {
-  b, err := keyFromFile(path)
+func RestoreWallet(path string, mnemonic string, passphrase string, light bool, hdpath string) (string, error) {
+  if keyExists(path) {
+    return "", fmt.Errorf("A wallet key already exists in %s", path)
+  }
+  b, err := hdwallet.DeriveKey(mnemonic, "", hdpath)
   if err != nil { return "", err }
-  key := hex.EncodeToString(b)
-  return key, err
+  defer func() { for i := range b { b[i] = 0 } }()
+  if err := keyToFile(b, path, passphrase, light); err != nil {
+    return "", err
+  }
+  return "0x" + PrivateToAddress(b), nil
 }
 
-func getAddr(path string) (string, error) {
-  b, err := keyFromFile(path)
-  if err != nil { return "", err }
-  addr := PrivateToAddress(b)
-  return "0x"+addr, err
+/**
+ * Generate the key a wallet is being rotated to, from a new mnemonic, and
+ * save it next to the current one. If a rotation was interrupted, its key is
+ * reused (and no mnemonic is returned, as it was shown the first time).
+ *
+ * @param path {string}       - directory of the wallet key
+ * @param passphrase {string} - passphrase to encrypt the key with
+ * @param light {bool}        - use the light scrypt parameters
+ * @param hdpath {string}     - derivation path of the key
+ * @returns (string, string, error) - mnemonic or "", new address, error
+ */
+func NewPendingWallet(path string, passphrase string, light bool, hdpath string) (string, string, error) {
+  pending := filepath.Join(path, PENDING_KEY_FILE)
+  if keyjson, err := ioutil.ReadFile(pending); err == nil {
+    addr, err := keystore.KeystoreAddress(keyjson)
+    return "", addr, err
+  }
+  mnemonic, err := hdwallet.NewMnemonic()
+  if err != nil { return "", "", err }
+  b, err := hdwallet.DeriveKey(mnemonic, "", hdpath)
+  if err != nil { return "", "", err }
+  defer func() { for i := range b { b[i] = 0 } }()
+  scryptN, scryptP := scryptParams(light)
+  if err := keystore.Save(pending, b, passphrase, scryptN, scryptP); err != nil {
+    return "", "", err
+  }
+  return mnemonic, "0x" + PrivateToAddress(b), nil
+}
+
+/**
+ * Finish a rotation: move the current keystore to the archive and make the
+ * pending one the wallet key. The archived keystore stays encrypted.
+ *
+ * @param path {string}    - directory of the wallet key
+ * @param old {string}     - address of the current wallet, to name the archive
+ * @returns (string, error) - path of the archived keystore, error
+ */
+func ActivatePendingWallet(path string, old string) (string, error) {
+  pending := filepath.Join(path, PENDING_KEY_FILE)
+  if _, err := os.Stat(pending); err != nil {
+    return "", fmt.Errorf("No key is being rotated in (%w)", err)
+  }
+  archive := filepath.Join(path, ARCHIVE_DIR)
+  if err := os.MkdirAll(archive, 0700); err != nil {
+    return "", err
+  }
+  archived := filepath.Join(archive, fmt.Sprintf("wallet-%s-%d.json", old, time.Now().Unix()))
+  if err := os.Rename(filepath.Join(path, KEYSTORE_FILE), archived); err != nil {
+    return "", err
+  }
+  return archived, os.Rename(pending, filepath.Join(path, KEYSTORE_FILE))
+}
+
+/**
+ * Get a signer for the wallet keystore
+ *
+ * @param path {string}       - directory from which to get the key
+ * @param passphrase {string} - passphrase the key was encrypted with
+ * @param mode {string}       - SIGNER_MEMORY to decrypt the key once and keep it
+ * @returns (sig.Signer, error)
+ */
+func getSigner(path string, passphrase string, mode string) (sig.Signer, error) {
+  if mode != SIGNER_MEMORY {
+    return nil, fmt.Errorf("Unknown signer %q, expected %q or %q", mode, SIGNER_MEMORY, SIGNER_REMOTE)
+  }
+  b, err := keyFromFile(path, passphrase)
+  if err != nil { return nil, err }
+  defer func() { for i := range b { b[i] = 0 } }()
+  return sig.NewKeySigner(b)
+}
+
+/**
+ * Check whether a wallet key (encrypted or legacy) exists
+ *
+ * @param path {string} - directory of the key
+ * @returns (bool)
+ */
+func keyExists(path string) (bool) {
+  for _, name := range []string{KEYSTORE_FILE, LEGACY_KEY_FILE} {
+    if _, err := os.Stat(filepath.Join(path, name)); err == nil {
+      return true
+    }
+  }
+  return false
+}
+
+/**
+ * Check whether the encrypted wallet keystore exists
+ *
+ * @param path {string} - directory of the key
+ * @returns (bool)
+ */
+func keystoreExists(path string) (bool) {
+  _, err := os.Stat(filepath.Join(path, KEYSTORE_FILE))
+  return err == nil
 }
 
 /**
- * Dump bytes to a file
+ * Encrypt a key and save it as a keystore readable only by its owner
  *
- * @param b {bytes} - arbitrary byte array
- * @param fpath {string} - absolute file path in which to save the key
+ * @param b {bytes}           - private key
+ * @param fpath {string}      - directory in which to save the key
+ * @param passphrase {string} - passphrase to encrypt the key with
+ * @param light {bool}        - use the light scrypt parameters
  * @returns (error)
  */
-func keyToFile(b []byte, fpath string) (error) {
-  err := ioutil.WriteFile(fpath+"/wallet.pem", b, 0644)
-  return err
+func keyToFile(b []byte, fpath string, passphrase string, light bool) (error) {
+  scryptN, scryptP := scryptParams(light)
+  return keystore.Save(filepath.Join(fpath, KEYSTORE_FILE), b, passphrase, scryptN, scryptP)
+}
+
+// scrypt cost of new keystores
+func scryptParams(light bool) (int, int) {
+  if light {
+    return keystore.LightScryptN, keystore.LightScryptP
+  }
+  return keystore.StandardScryptN, keystore.StandardScryptP
 }
 
 /**
- * Read a file containing a byte array
+ * Read and decrypt the keystore
  *
- * @param fpath {string} - absolute file path from which to read the key
+ * @param fpath {string}      - directory from which to read the key
+ * @param passphrase {string} - passphrase the key was encrypted with
  * @returns ([]byte, error) - private key, error
  */
-func keyFromFile(fpath string) ([]byte, error) {
-  b, err := ioutil.ReadFile(fpath+"/wallet.pem")
-  return b, err
+func keyFromFile(fpath string, passphrase string) ([]byte, error) {
+  return keystore.Load(filepath.Join(fpath, KEYSTORE_FILE), passphrase)
+}
+
+/**
+ * Convert a raw wallet.pem left by an earlier version into a keystore. The
+ * keystore is read back before the raw key is wiped and removed. If a
+ * keystore with the same key already exists, only the raw key is removed.
+ *
+ * @param fpath {string}      - directory of the key
+ * @param passphrase {string} - passphrase to encrypt the key with
+ * @param light {bool}        - use the light scrypt parameters
+ * @returns (bool, error) - whether a key was migrated, error
+ */
+func migrateKey(fpath string, passphrase string, light bool) (bool, error) {
+  legacy := filepath.Join(fpath, LEGACY_KEY_FILE)
+  b, err := ioutil.ReadFile(legacy)
+  if os.IsNotExist(err) {
+    return false, nil
+  } else if err != nil {
+    return false, err
+  }
+  if len(b) != 32 {
+    return false, fmt.Errorf("%s holds %d bytes, expected a 32 byte key", legacy, len(b))
+  }
+  if _, err := os.Stat(filepath.Join(fpath, KEYSTORE_FILE)); err == nil {
+    // An earlier migration may have stopped before removing the raw key
+    existing, err := keyFromFile(fpath, passphrase)
+    if err != nil {
+      return false, fmt.Errorf("Both %s and %s exist and the keystore can't be read (%s)", LEGACY_KEY_FILE, KEYSTORE_FILE, err)
+    } else if !bytes.Equal(existing, b) {
+      return false, fmt.Errorf("Both %s and %s exist and hold different keys. Remove the one that is not in use.", LEGACY_KEY_FILE, KEYSTORE_FILE)
+    }
+  } else {
+    if err := keyToFile(b, fpath, passphrase, light); err != nil {
+      return false, err
+    }
+    check, err := keyFromFile(fpath, passphrase)
+    if err != nil || !bytes.Equal(check, b) {
+      return false, fmt.Errorf("Could not read back migrated keystore (%v)", err)
+    }
+  }
+  // Overwrite the raw key before removing it
+  if err := ioutil.WriteFile(legacy, make([]byte, len(b)), 0600); err != nil {
+    return true, err
+  }
+  return true, os.Remove(legacy)
 }
 
 /**
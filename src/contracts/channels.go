@@ -0,0 +1,83 @@
+package contracts
+
+import (
+  "abi"
+  "math/big"
+  "rpc"
+)
+
+// Methods of the payment channel contract. Only the selectors of the
+// deployed contract are known, so they are given explicitly.
+var ChannelManagerABI = struct {
+  OpenChannel *abi.Method
+  ChannelId *abi.Method
+  Deposit *abi.Method
+}{
+  OpenChannel: abi.MustMethod("openChannel(address token, address to, uint256 amount)").WithSelector("0xcfa40e4f"),
+  ChannelId: abi.MustMethod("getChannelId(address from, address to) returns (bytes32)").WithSelector("0x2460ee73"),
+  Deposit: abi.MustMethod("getDeposit(bytes32 id) returns (uint256)").WithSelector("0x2f748d7b"),
+}
+
+// Id returned for a pair of addresses with no channel between them
+const NO_CHANNEL = "0x0000000000000000000000000000000000000000000000000000000000000000"
+
+// The contract holding BOLT deposits for payment channels
+type ChannelManager struct {
+  BoundContract
+}
+
+func NewChannelManager(address string) (*ChannelManager) {
+  return &ChannelManager{BoundContract{Address: address}}
+}
+
+/**
+ * Look up the id of the channel between two addresses.
+ *
+ * @param from    Channel spender
+ * @param to      Channel recipient
+ * @return        Id of the channel, or "" if there is none, error
+ */
+func (manager *ChannelManager) ChannelId(from string, to string) (string, error) {
+  call, err := manager.ChannelIdCall(from, to)
+  if err != nil { return "", err }
+  err, result := rpc.MakeCall(call.From, call.To, call.Data)
+  if err != nil { return "", err }
+  return manager.ParseChannelId(result)
+}
+
+// The call behind ChannelId, for use in a Batch
+func (manager *ChannelManager) ChannelIdCall(from string, to string) (rpc.Call, error) {
+  return manager.Call(from, ChannelManagerABI.ChannelId, from, to)
+}
+
+// Decode the result of a ChannelIdCall. No channel is "".
+func (manager *ChannelManager) ParseChannelId(result string) (string, error) {
+  id, err := stringResult(ChannelManagerABI.ChannelId.Unpack(result))
+  if err != nil || id == NO_CHANNEL { return "", err }
+  return id, nil
+}
+
+/**
+ * Get the deposit of a channel. A majority of providers must agree.
+ *
+ * @param from    Address making the call
+ * @param id      Id of the channel
+ * @return        Deposit in atomic units of the token, error
+ */
+func (manager *ChannelManager) Deposit(from string, id string) (*big.Int, error) {
+  return intResult(manager.quorumCall(from, ChannelManagerABI.Deposit, id))
+}
+
+/**
+ * Sign a transaction opening a channel. The contract must have an allowance
+ * for the amount (see ERC20.Approve).
+ *
+ * @param opts      Sender and gas options
+ * @param token     Address of the token deposited
+ * @param to        Channel recipient
+ * @param amount    Deposit in atomic units of the token
+ * @return          Raw, signed transaction, error
+ */
+func (manager *ChannelManager) OpenChannel(opts *TransactOpts, token string, to string, amount *big.Int) (string, error) {
+  return manager.transact(opts, ChannelManagerABI.OpenChannel, token, to, amount)
+}
@@ -0,0 +1,107 @@
+// Typed bindings for the contracts the agent talks to
+package contracts
+
+import (
+  "abi"
+  "fmt"
+  "math/big"
+  "rpc"
+  "sig"
+)
+
+// Options for signing a transaction to a contract
+type TransactOpts struct {
+  Signer sig.Signer   // Sender of the transaction
+  API string          // Full base URI of the hub API, for default gas values
+  Gas uint64          // Gas limit, or 0 to estimate
+  GasPrice *big.Int   // Gas price in wei, or nil for the hub's price
+}
+
+// A contract at a known address. Embedded by the typed bindings.
+type BoundContract struct {
+  Address string
+}
+
+/**
+ * Build a call to a method of the contract, e.g. for use in an rpc.Batch.
+ *
+ * @param from      Address making the call
+ * @param method    Method to call
+ * @param args      Arguments to the method
+ * @return          Call, error
+ */
+func (contract *BoundContract) Call(from string, method *abi.Method, args ...interface{}) (rpc.Call, error) {
+  data, err := method.Pack(args...)
+  if err != nil { return rpc.Call{}, err }
+  return rpc.Call{From: from, To: contract.Address, Data: data}, nil
+}
+
+/**
+ * Call a method of the contract on the healthiest provider and decode the
+ * result.
+ */
+func (contract *BoundContract) call(from string, method *abi.Method, args ...interface{}) ([]interface{}, error) {
+  call, err := contract.Call(from, method, args...)
+  if err != nil { return nil, err }
+  err, result := rpc.MakeCall(call.From, call.To, call.Data)
+  if err != nil { return nil, err }
+  return method.Unpack(result)
+}
+
+/**
+ * Same as call, but the result must be agreed on by a majority of providers.
+ * For reads the agent acts on without further checks.
+ */
+func (contract *BoundContract) quorumCall(from string, method *abi.Method, args ...interface{}) ([]interface{}, error) {
+  call, err := contract.Call(from, method, args...)
+  if err != nil { return nil, err }
+  err, result := rpc.QuorumCall(call.From, call.To, call.Data)
+  if err != nil { return nil, err }
+  return method.Unpack(result)
+}
+
+/**
+ * Sign a transaction calling a method of the contract. The transaction is not
+ * sent; pass it to rpc.SendRaw.
+ *
+ * @param opts      Sender and gas options
+ * @param method    Method to call
+ * @param args      Arguments to the method
+ * @return          Raw, signed transaction, error
+ */
+func (contract *BoundContract) transact(opts *TransactOpts, method *abi.Method, args ...interface{}) (string, error) {
+  data, err := method.Pack(args...)
+  if err != nil { return "", err }
+  if opts.Gas == 0 && opts.GasPrice == nil {
+    return rpc.DefaultRawTx(opts.Signer, contract.Address, data, opts.API)
+  }
+  var gasPrice = opts.GasPrice
+  if gasPrice == nil {
+    _, gasPrice = rpc.DefaultGas(opts.API)
+  }
+  return rpc.RawTx(opts.Signer, contract.Address, data, opts.Gas, gasPrice, nil)
+}
+
+// Get a single bool out of a decoded result
+func boolResult(values []interface{}, err error) (bool, error) {
+  if err != nil { return false, err }
+  b, ok := values[0].(bool)
+  if !ok { return false, fmt.Errorf("Expected bool, got %T", values[0]) }
+  return b, nil
+}
+
+// Get a single integer out of a decoded result
+func intResult(values []interface{}, err error) (*big.Int, error) {
+  if err != nil { return nil, err }
+  i, ok := values[0].(*big.Int)
+  if !ok { return nil, fmt.Errorf("Expected integer, got %T", values[0]) }
+  return i, nil
+}
+
+// Get a single string (address, fixed bytes) out of a decoded result
+func stringResult(values []interface{}, err error) (string, error) {
+  if err != nil { return "", err }
+  s, ok := values[0].(string)
+  if !ok { return "", fmt.Errorf("Expected string, got %T", values[0]) }
+  return s, nil
+}
@@ -0,0 +1,102 @@
+package contracts
+
+import (
+  "abi"
+  "math/big"
+  "rpc"
+)
+
+// Methods of an ERC20 token contract (e.g. BOLT)
+var ERC20ABI = struct {
+  BalanceOf *abi.Method
+  Decimals *abi.Method
+  Allowance *abi.Method
+  Approve *abi.Method
+  Transfer *abi.Method
+}{
+  BalanceOf: abi.MustMethod("balanceOf(address owner) returns (uint256)"),
+  Decimals: abi.MustMethod("decimals() returns (uint8)"),
+  Allowance: abi.MustMethod("allowance(address owner, address spender) returns (uint256)"),
+  Approve: abi.MustMethod("approve(address spender, uint256 value) returns (bool)"),
+  Transfer: abi.MustMethod("transfer(address to, uint256 value) returns (bool)"),
+}
+
+type ERC20 struct {
+  BoundContract
+}
+
+func NewERC20(address string) (*ERC20) {
+  return &ERC20{BoundContract{Address: address}}
+}
+
+/**
+ * Get the token balance of an address. A majority of providers must agree.
+ *
+ * @param holder    Address to check balance of
+ * @return          Balance in atomic units, error
+ */
+func (token *ERC20) BalanceOf(holder string) (*big.Int, error) {
+  return intResult(token.quorumCall(holder, ERC20ABI.BalanceOf, holder))
+}
+
+/**
+ * Get the number of decimals the token uses.
+ *
+ * @param from    Address making the call
+ * @return        Decimals, error
+ */
+func (token *ERC20) Decimals(from string) (uint8, error) {
+  return uint8Result(token.call(from, ERC20ABI.Decimals))
+}
+
+// The call behind Decimals, for use in a Batch
+func (token *ERC20) DecimalsCall(from string) (rpc.Call, error) {
+  return token.Call(from, ERC20ABI.Decimals)
+}
+
+// Decode the result of a DecimalsCall
+func (token *ERC20) ParseDecimals(result string) (uint8, error) {
+  return uint8Result(ERC20ABI.Decimals.Unpack(result))
+}
+
+/**
+ * Get the amount a spender may still transfer on behalf of a holder.
+ *
+ * @param holder     Address holding the balance to be spent
+ * @param spender    Address we are checking allowance for
+ * @return           Allowance in atomic units, error
+ */
+func (token *ERC20) Allowance(holder string, spender string) (*big.Int, error) {
+  return intResult(token.call(holder, ERC20ABI.Allowance, holder, spender))
+}
+
+/**
+ * Sign a transaction allowing a spender to transfer some of the sender's
+ * tokens.
+ *
+ * @param opts       Sender and gas options
+ * @param spender    Address allowed to spend
+ * @param amount     Allowance in atomic units
+ * @return           Raw, signed transaction, error
+ */
+func (token *ERC20) Approve(opts *TransactOpts, spender string, amount *big.Int) (string, error) {
+  return token.transact(opts, ERC20ABI.Approve, spender, amount)
+}
+
+/**
+ * Sign a transaction sending some of the sender's tokens to another address.
+ *
+ * @param opts      Sender and gas options
+ * @param to        Recipient
+ * @param amount    Amount in atomic units
+ * @return          Raw, signed transaction, error
+ */
+func (token *ERC20) Transfer(opts *TransactOpts, to string, amount *big.Int) (string, error) {
+  return token.transact(opts, ERC20ABI.Transfer, to, amount)
+}
+
+func uint8Result(values []interface{}, err error) (uint8, error) {
+  i, err := intResult(values, err)
+  if err != nil { return 0, err }
+  return uint8(i.Uint64()), nil
+}
@@ -0,0 +1,74 @@
+package contracts
+
+import (
+  "abi"
+)
+
+// Methods of the Grid+ Registry contract
+var RegistryABI = struct {
+  Registered *abi.Method
+  CheckRegistry *abi.Method
+  Claimed *abi.Method
+  SetWallet *abi.Method
+}{
+  Registered: abi.MustMethod("registered(bytes32 serial_hash) returns (bool)"),
+  CheckRegistry: abi.MustMethod("check_registry(bytes32 serial_hash, address wallet) returns (bool)"),
+  Claimed: abi.MustMethod("claimed(bytes32 serial_hash) returns (bool)"),
+  SetWallet: abi.MustMethod("setWallet(address wallet, bytes32 serial_hash)"),
+}
+
+// The registry maps agent serial numbers to their setup and wallet addresses
+type Registry struct {
+  BoundContract
+}
+
+func NewRegistry(address string) (*Registry) {
+  return &Registry{BoundContract{Address: address}}
+}
+
+/**
+ * Check if the serial number has been registered.
+ *
+ * @param from           The origin of the message
+ * @param serial_hash    Keccak256 hash of the agent's serial number
+ * @return               true if registered, error
+ */
+func (registry *Registry) Registered(from string, serial_hash string) (bool, error) {
+  return boolResult(registry.call(from, RegistryABI.Registered, serial_hash))
+}
+
+/**
+ * Check if a specific address is registered to a specific serial number.
+ *
+ * @param from           The origin of the message
+ * @param serial_hash    Keccak256 hash of the agent's serial number
+ * @param wallet         The address to check against the serial number
+ * @return               true if registered, error
+ */
+func (registry *Registry) CheckRegistry(from string, serial_hash string, wallet string) (bool, error) {
+  return boolResult(registry.call(from, RegistryABI.CheckRegistry, serial_hash, wallet))
+}
+
+/**
+ * Check if the agent has been claimed by a human owner. A majority of
+ * providers must agree.
+ *
+ * @param serial_hash    Keccak256 hash of the agent's serial number
+ * @return               true if claimed, error
+ */
+func (registry *Registry) Claimed(serial_hash string) (bool, error) {
+  return boolResult(registry.quorumCall(registry.Address, RegistryABI.Claimed, serial_hash))
+}
+
+/**
+ * Sign a transaction registering a wallet to a serial number. This replaces
+ * the setup address, so it must be signed by the currently registered key.
+ *
+ * @param opts           Sender (the registered address) and gas options
+ * @param wallet         Address of the new wallet
+ * @param serial_hash    Keccak256 hash of the agent's serial number
+ * @return               Raw, signed transaction, error
+ */
+func (registry *Registry) SetWallet(opts *TransactOpts, wallet string, serial_hash string) (string, error) {
+  return registry.transact(opts, RegistryABI.SetWallet, wallet, serial_hash)
+}
@@ -0,0 +1,224 @@
+// Decode contract events (logs) of the BOLT token, the Registry and the
+// payment channel contract
+package events
+
+import (
+  "abi"
+  "fmt"
+  "math/big"
+  "rpc"
+  "strconv"
+  "strings"
+)
+
+type Event struct {
+  *abi.Event
+}
+
+// A decoded log. Values are keyed by parameter name and typed as abi.Decode
+// returns them: addresses and fixed bytes are 0x-prefixed hex strings,
+// integers are *big.Int, bools are bool.
+type Decoded struct {
+  Event *Event
+  Values map[string]interface{}
+  Log rpc.Log
+}
+
+// BOLT (ERC20) token events
+var Transfer = MustParse("Transfer(address indexed from, address indexed to, uint256 value)")
+var Approval = MustParse("Approval(address indexed owner, address indexed spender, uint256 value)")
+
+// Names of the payment channel contract's events in its ABI
+const CHANNEL_OPENED = "ChannelOpened"
+const CHANNEL_CLOSED = "ChannelClosed"
+const CHANNEL_CHALLENGED = "ChannelChallenged"
+
+// Events of the Registry and payment channel contracts. Their ABIs aren't
+// published, so they are read from the deployed contracts' ABI files by
+// LoadRegistry and LoadChannels, and stay empty until then.
+var Registry = map[string]*Event{}
+var ChannelOpened *Event
+var ChannelClosed *Event
+var ChannelChallenged *Event
+
+// Every event the agent knows how to decode
+var Known = []*Event{Transfer, Approval}
+
+/**
+ * Parse a human-readable event declaration, e.g.
+ * "Transfer(address indexed from, address indexed to, uint256 value)"
+ *
+ * @param decl    Event declaration
+ * @return        Event, error
+ */
+func Parse(decl string) (*Event, error) {
+  event, err := abi.ParseEvent(decl)
+  if err != nil { return nil, err }
+  return &Event{event}, nil
+}
+
+// Same as Parse, but panics on bad declarations. For package-level events.
+func MustParse(decl string) (*Event) {
+  event, err := Parse(decl)
+  if err != nil { panic(err) }
+  return event
+}
+
+/**
+ * Read every event from a contract's JSON ABI (or truffle/hardhat artifact)
+ * and add them to Known. Overloaded events are keyed as in abi.ParseJSON.
+ *
+ * @param path    Path to the ABI file
+ * @return        Events keyed by name, error
+ */
+func LoadABI(path string) (map[string]*Event, error) {
+  contract, err := abi.LoadJSON(path)
+  if err != nil { return nil, err }
+  loaded := map[string]*Event{}
+  for key, event := range contract.Events {
+    loaded[key] = &Event{event}
+    Known = append(Known, loaded[key])
+  }
+  return loaded, nil
+}
+
+/**
+ * Load the Registry's events from its ABI file. Call once at startup.
+ *
+ * @param path    Path to the Registry's ABI file
+ */
+func LoadRegistry(path string) (error) {
+  loaded, err := LoadABI(path)
+  if err != nil { return err }
+  Registry = loaded
+  return nil
+}
+
+/**
+ * Load the payment channel contract's events from its ABI file. Call once at
+ * startup. The ABI must declare the opened, closed and challenged events.
+ *
+ * @param path    Path to the channel contract's ABI file
+ */
+func LoadChannels(path string) (error) {
+  loaded, err := LoadABI(path)
+  if err != nil { return err }
+  for _, name := range []string{CHANNEL_OPENED, CHANNEL_CLOSED, CHANNEL_CHALLENGED} {
+    if loaded[name] == nil {
+      return fmt.Errorf("No event %s in channel contract ABI %s", name, path)
+    }
+  }
+  ChannelOpened = loaded[CHANNEL_OPENED]
+  ChannelClosed = loaded[CHANNEL_CLOSED]
+  ChannelChallenged = loaded[CHANNEL_CHALLENGED]
+  return nil
+}
+
+/**
+ * Build a filter for this event from a contract. Pass one value per indexed
+ * parameter to match on it, "" to match anything.
+ *
+ * @param contract    Address of the emitting contract
+ * @param indexed     Values of the indexed parameters, in order
+ * @return            Filter for rpc.GetLogs or rpc.WaitForLog
+ */
+func (event *Event) Filter(contract string, indexed ...string) (rpc.FilterQuery) {
+  topics := [][]string{{event.Topic()}}
+  for _, value := range indexed {
+    if value == "" {
+      topics = append(topics, nil)
+    } else {
+      topics = append(topics, []string{"0x" + zfill(value)})
+    }
+  }
+  return rpc.FilterQuery{Address: []string{contract}, Topics: topics}
+}
+
+/**
+ * Decode a log emitted as this event.
+ *
+ * @param _log    Log from a receipt, rpc.GetLogs or a subscription
+ * @return        Decoded values, error
+ */
+func (event *Event) Decode(_log rpc.Log) (*Decoded, error) {
+  values, err := event.Unpack(_log.Topics, _log.Data)
+  if err != nil { return nil, err }
+  return &Decoded{Event: event, Values: values, Log: _log}, nil
+}
+
+/**
+ * Decode a log as whichever known event it is.
+ *
+ * @param _log    Log to decode
+ * @return        Decoded values, or nil if the event is not known
+ */
+func DecodeKnown(_log rpc.Log) (*Decoded) {
+  if len(_log.Topics) == 0 { return nil }
+  for _, event := range Known {
+    if strings.EqualFold(_log.Topics[0], event.Topic()) {
+      decoded, err := event.Decode(_log)
+      if err == nil { return decoded }
+    }
+  }
+  return nil
+}
+
+/**
+ * Find and decode every log of this event in a list, e.g. a receipt's logs.
+ *
+ * @param logs        Logs to search
+ * @param contract    Only consider logs from this address ("" for any)
+ * @return            Decoded events
+ */
+func (event *Event) FindIn(logs []rpc.Log, contract string) ([]*Decoded) {
+  var found []*Decoded
+  for _, _log := range logs {
+    if contract != "" && !strings.EqualFold(_log.Address, contract) { continue }
+    if decoded, err := event.Decode(_log); err == nil {
+      found = append(found, decoded)
+    }
+  }
+  return found
+}
+
+/**
+ * Query the chain for this event and decode what comes back.
+ *
+ * @param filter    Filter, usually from event.Filter
+ * @return          error, decoded events
+ */
+func (event *Event) Query(filter rpc.FilterQuery) (error, []*Decoded) {
+  err, logs := rpc.GetLogs(filter)
+  if err != nil { return err, nil }
+  var decoded []*Decoded
+  for _, _log := range logs {
+    d, err := event.Decode(_log)
+    if err != nil { return err, nil }
+    decoded = append(decoded, d)
+  }
+  return nil, decoded
+}
+
+// Get a decoded value as a string (addresses, fixed bytes)
+func (decoded *Decoded) String(name string) (string) {
+  s, _ := decoded.Values[name].(string)
+  return s
+}
+
+// Get a decoded value as an integer
+func (decoded *Decoded) Int(name string) (*big.Int) {
+  i, _ := decoded.Values[name].(*big.Int)
+  return i
+}
+
+// Block the log was emitted in
+func (decoded *Decoded) BlockNumber() (uint64) {
+  n, _ := strconv.ParseUint(decoded.Log.BlockNumber, 0, 64)
+  return n
+}
+
+// Left pad a hex string to 32 bytes
+func zfill(s string) (string) {
+  s = strings.TrimPrefix(s, "0x")
+  return strings.Repeat("0", 64-len(s)) + s
+}
@@ -0,0 +1,71 @@
+package events
+
+import (
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "rpc"
+  "testing"
+)
+
+const CHANNEL_ID = "0x1111111111111111111111111111111111111111111111111111111111111111"
+const FROM = "0xbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb"
+
+// A truffle artifact with the channel contract's events
+const channelsArtifact = `{"contractName": "ChannelManager", "abi": [
+  {"type": "event", "name": "ChannelOpened", "anonymous": false, "inputs": [
+    {"name": "id", "type": "bytes32", "indexed": true},
+    {"name": "from", "type": "address", "indexed": true},
+    {"name": "deposit", "type": "uint256", "indexed": false}]},
+  {"type": "event", "name": "ChannelClosed", "anonymous": false, "inputs": [
+    {"name": "id", "type": "bytes32", "indexed": true}]},
+  {"type": "event", "name": "ChannelChallenged", "anonymous": false, "inputs": [
+    {"name": "id", "type": "bytes32", "indexed": true},
+    {"name": "closeTime", "type": "uint256", "indexed": false}]},
+  {"type": "function", "name": "closeChannel", "inputs": [{"name": "id", "type": "bytes32"}], "outputs": []}
+]}`
+
+func writeABI(t *testing.T, contents string) (string) {
+  dir, err := ioutil.TempDir("", "events")
+  if err != nil {
+    t.Fatal(err)
+  }
+  t.Cleanup(func() { os.RemoveAll(dir) })
+  path := filepath.Join(dir, "abi.json")
+  if err := ioutil.WriteFile(path, []byte(contents), 0600); err != nil {
+    t.Fatal(err)
+  }
+  return path
+}
+
+func TestLoadChannels(t *testing.T) {
+  if err := LoadChannels(writeABI(t, channelsArtifact)); err != nil {
+    t.Fatal(err)
+  }
+  if ChannelOpened.Signature() != "ChannelOpened(bytes32,address,uint256)" {
+    t.Errorf("ChannelOpened is %s", ChannelOpened.Signature())
+  }
+  _log := rpc.Log{
+    Topics: []string{ChannelOpened.Topic(), CHANNEL_ID, "0x" + zfill(FROM)},
+    Data: "0x" + zfill("ff"),
+  }
+  decoded := DecodeKnown(_log)
+  if decoded == nil || decoded.Event != ChannelOpened {
+    t.Fatalf("Decoded %v, want ChannelOpened", decoded)
+  }
+  if decoded.String("id") != CHANNEL_ID || decoded.String("from") != FROM || decoded.Int("deposit").Int64() != 255 {
+    t.Errorf("Decoded %v", decoded.Values)
+  }
+  // Transfer and Approval are still known
+  _log.Topics[0] = Transfer.Topic()
+  if decoded := DecodeKnown(_log); decoded == nil || decoded.Event != Transfer {
+    t.Errorf("Decoded %v, want Transfer", decoded)
+  }
+}
+
+func TestLoadChannelsMissingEvent(t *testing.T) {
+  path := writeABI(t, `[{"type": "event", "name": "ChannelOpened", "inputs": []}]`)
+  if err := LoadChannels(path); err == nil {
+    t.Errorf("Loaded a channel ABI without ChannelClosed")
+  }
+}
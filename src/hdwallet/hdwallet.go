@@ -0,0 +1,223 @@
+// Hierarchical deterministic keys: BIP-39 mnemonics and BIP-32 derivation on
+// BIP-44 paths, so every key the agent uses can be recovered from one backup
+// phrase in any standard wallet
+package hdwallet
+
+import (
+  "crypto/hmac"
+  "crypto/sha512"
+  "encoding/binary"
+  "errors"
+  "fmt"
+  "math/big"
+  "strconv"
+  "strings"
+)
+import "github.com/ethereum/go-ethereum/crypto"
+import "github.com/tyler-smith/go-bip39"
+
+// BIP-44 account path of Ethereum keys. Key n is at m/44'/60'/0'/0/n.
+const BASE_PATH = "m/44'/60'/0'/0"
+
+// Index of the wallet key under BASE_PATH. It is the only key derived: the
+// setup key is issued by Grid+, and the channel contract only accepts
+// payments signed by the wallet that opened the channel.
+const WALLET_INDEX = 0
+
+// Strength of new mnemonics: 256 bits is 24 words
+const MNEMONIC_BITS = 256
+
+// Indices from this one up are hardened (written with ')
+const HARDENED = uint32(0x80000000)
+
+var ErrInvalidMnemonic = errors.New("Invalid mnemonic")
+
+// Order of secp256k1
+var curveN, _ = new(big.Int).SetString("fffffffffffffffffffffffffffffffebaaedce6af48a03bbfd25e8cd0364141", 16)
+
+// An extended private key: a key and the chain code its children derive from
+type Key struct {
+  key []byte
+  chainCode []byte
+}
+
+/**
+ * Generate a new mnemonic.
+ *
+ * @return    Space separated words, error
+ */
+func NewMnemonic() (string, error) {
+  entropy, err := bip39.NewEntropy(MNEMONIC_BITS)
+  if err != nil { return "", err }
+  return bip39.NewMnemonic(entropy)
+}
+
+/**
+ * Get the master key of a mnemonic.
+ *
+ * @param mnemonic    Space separated words
+ * @param password    Optional BIP-39 password ("25th word"), usually ""
+ * @return            Master key, error
+ */
+func FromMnemonic(mnemonic string, password string) (*Key, error) {
+  mnemonic = strings.Join(strings.Fields(strings.ToLower(mnemonic)), " ")
+  if !bip39.IsMnemonicValid(mnemonic) {
+    return nil, ErrInvalidMnemonic
+  }
+  seed := bip39.NewSeed(mnemonic, password)
+  defer zero(seed)
+  return NewMaster(seed)
+}
+
+/**
+ * Get the master key of a seed (BIP-32).
+ *
+ * @param seed    16 to 64 byte seed
+ * @return        Master key, error
+ */
+func NewMaster(seed []byte) (*Key, error) {
+  if len(seed) < 16 || len(seed) > 64 {
+    return nil, fmt.Errorf("Invalid seed length %d", len(seed))
+  }
+  mac := hmac.New(sha512.New, []byte("Bitcoin seed"))
+  mac.Write(seed)
+  I := mac.Sum(nil)
+  k := new(big.Int).SetBytes(I[:32])
+  if k.Sign() == 0 || k.Cmp(curveN) >= 0 {
+    return nil, errors.New("Seed gives an invalid master key")
+  }
+  return &Key{key: I[:32], chainCode: I[32:]}, nil
+}
+
+/**
+ * Derive a child key.
+ *
+ * @param i    Index, HARDENED or more for a hardened child
+ * @return     Child key, error if the index gives an invalid key (use the next one)
+ */
+func (k *Key) Child(i uint32) (*Key, error) {
+  var data []byte
+  if i >= HARDENED {
+    data = append([]byte{0}, k.key...)
+  } else {
+    data = k.publicKey()
+  }
+  data = append(data, 0, 0, 0, 0)
+  binary.BigEndian.PutUint32(data[len(data)-4:], i)
+  mac := hmac.New(sha512.New, k.chainCode)
+  mac.Write(data)
+  I := mac.Sum(nil)
+  zero(data)
+
+  il := new(big.Int).SetBytes(I[:32])
+  if il.Cmp(curveN) >= 0 {
+    return nil, fmt.Errorf("Index %d gives an invalid key", i)
+  }
+  child := il.Add(il, new(big.Int).SetBytes(k.key))
+  child.Mod(child, curveN)
+  if child.Sign() == 0 {
+    return nil, fmt.Errorf("Index %d gives an invalid key", i)
+  }
+  key := make([]byte, 32)
+  child.FillBytes(key)
+  return &Key{key: key, chainCode: I[32:]}, nil
+}
+
+/**
+ * Derive the key at a path below this one.
+ *
+ * @param path    e.g. "m/44'/60'/0'/0/0". ' or h marks hardened indices.
+ * @return        Key, error
+ */
+func (k *Key) Derive(path string) (*Key, error) {
+  indices, err := ParsePath(path)
+  if err != nil { return nil, err }
+  key := k
+  for _, i := range indices {
+    child, err := key.Child(i)
+    if key != k { key.Zero() }
+    if err != nil { return nil, err }
+    key = child
+  }
+  return key, nil
+}
+
+/**
+ * Get the 32 byte private key. Zero it once it has been used.
+ */
+func (k *Key) PrivateKey() ([]byte) {
+  return append([]byte{}, k.key...)
+}
+
+/**
+ * Overwrite the key and chain code.
+ */
+func (k *Key) Zero() {
+  zero(k.key)
+  zero(k.chainCode)
+}
+
+/**
+ * Parse a derivation path.
+ *
+ * @param path    e.g. "m/44'/60'/0'/0/0"
+ * @return        Indices, with HARDENED added to hardened ones, error
+ */
+func ParsePath(path string) ([]uint32, error) {
+  parts := strings.Split(strings.TrimSpace(path), "/")
+  if parts[0] != "m" {
+    return nil, fmt.Errorf("Invalid derivation path %q: must start with m", path)
+  }
+  var indices []uint32
+  for _, part := range parts[1:] {
+    var offset = uint32(0)
+    if strings.HasSuffix(part, "'") || strings.HasSuffix(part, "h") {
+      part = part[:len(part)-1]
+      offset = HARDENED
+    }
+    i, err := strconv.ParseUint(part, 10, 31)
+    if err != nil {
+      return nil, fmt.Errorf("Invalid derivation path %q", path)
+    }
+    indices = append(indices, uint32(i) + offset)
+  }
+  return indices, nil
+}
+
+/**
+ * Get the path of key n of the agent, e.g. KeyPath(BASE_PATH, WALLET_INDEX).
+ */
+func KeyPath(base string, n uint32) (string) {
+  return fmt.Sprintf("%s/%d", strings.TrimSuffix(base, "/"), n)
+}
+
+/**
+ * Derive a private key from a mnemonic.
+ *
+ * @param mnemonic    Space separated words
+ * @param password    Optional BIP-39 password, usually ""
+ * @param path        Derivation path of the key
+ * @return            32 byte private key, error
+ */
+func DeriveKey(mnemonic string, password string, path string) ([]byte, error) {
+  master, err := FromMnemonic(mnemonic, password)
+  if err != nil { return nil, err }
+  defer master.Zero()
+  key, err := master.Derive(path)
+  if err != nil { return nil, err }
+  defer key.Zero()
+  return key.PrivateKey(), nil
+}
+
+// Compressed public key (serP(point(k)) in BIP-32)
+func (k *Key) publicKey() ([]byte) {
+  x, y := crypto.S256().ScalarBaseMult(k.key)
+  pub := make([]byte, 33)
+  pub[0] = byte(2 + y.Bit(0))
+  x.FillBytes(pub[1:])
+  return pub
+}
+
+func zero(b []byte) {
+  for i := range b { b[i] = 0 }
+}
@@ -0,0 +1,83 @@
+// Shared HTTP client for the hub API and the RPC providers. Requests are
+// bounded by timeouts and reuse pooled connections.
+package httpclient
+
+import (
+  "net"
+  "net/http"
+  "sync"
+  "time"
+)
+
+type Config struct {
+  Timeout time.Duration                 // Whole request, including reading the body
+  DialTimeout time.Duration             // Establishing a TCP connection
+  TLSHandshakeTimeout time.Duration
+  ResponseHeaderTimeout time.Duration   // Waiting for the server to start answering
+  IdleConnTimeout time.Duration         // Closing pooled connections nobody uses
+  MaxIdleConnsPerHost int
+}
+
+var DefaultConfig = Config{
+  Timeout: 30*time.Second,
+  DialTimeout: 10*time.Second,
+  TLSHandshakeTimeout: 10*time.Second,
+  ResponseHeaderTimeout: 20*time.Second,
+  IdleConnTimeout: 90*time.Second,
+  MaxIdleConnsPerHost: 4,
+}
+
+var mu sync.RWMutex
+var shared = New(DefaultConfig)
+
+/**
+ * Build a client from a config. Zero fields take the default value.
+ *
+ * @param conf    Timeouts and pool size
+ * @return        Client
+ */
+func New(conf Config) (*http.Client) {
+  conf = withDefaults(conf)
+  transport := &http.Transport{
+    Proxy: http.ProxyFromEnvironment,
+    DialContext: (&net.Dialer{
+      Timeout: conf.DialTimeout,
+      KeepAlive: 30*time.Second,
+    }).DialContext,
+    TLSHandshakeTimeout: conf.TLSHandshakeTimeout,
+    ResponseHeaderTimeout: conf.ResponseHeaderTimeout,
+    IdleConnTimeout: conf.IdleConnTimeout,
+    MaxIdleConns: conf.MaxIdleConnsPerHost * 4,
+    MaxIdleConnsPerHost: conf.MaxIdleConnsPerHost,
+  }
+  return &http.Client{Timeout: conf.Timeout, Transport: transport}
+}
+
+/**
+ * Replace the shared client. Requests already in flight finish on the old one.
+ */
+func Configure(conf Config) {
+  client := New(conf)
+  mu.Lock()
+  shared = client
+  mu.Unlock()
+}
+
+/**
+ * Get the shared client
+ */
+func Client() (*http.Client) {
+  mu.RLock()
+  defer mu.RUnlock()
+  return shared
+}
+
+func withDefaults(conf Config) (Config) {
+  if conf.Timeout == 0 { conf.Timeout = DefaultConfig.Timeout }
+  if conf.DialTimeout == 0 { conf.DialTimeout = DefaultConfig.DialTimeout }
+  if conf.TLSHandshakeTimeout == 0 { conf.TLSHandshakeTimeout = DefaultConfig.TLSHandshakeTimeout }
+  if conf.ResponseHeaderTimeout == 0 { conf.ResponseHeaderTimeout = DefaultConfig.ResponseHeaderTimeout }
+  if conf.IdleConnTimeout == 0 { conf.IdleConnTimeout = DefaultConfig.IdleConnTimeout }
+  if conf.MaxIdleConnsPerHost == 0 { conf.MaxIdleConnsPerHost = DefaultConfig.MaxIdleConnsPerHost }
+  return conf
+}
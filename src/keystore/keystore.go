@@ -0,0 +1,298 @@
+// Encrypted private keys in the Web3 Secret Storage (V3 keystore) format used
+// by geth, ethers and Metamask
+package keystore
+
+import (
+  "bytes"
+  "crypto/aes"
+  "crypto/cipher"
+  "crypto/rand"
+  "crypto/sha256"
+  "crypto/subtle"
+  "encoding/hex"
+  "encoding/json"
+  "errors"
+  "fmt"
+  "golang.org/x/crypto/pbkdf2"
+  "golang.org/x/crypto/scrypt"
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "strings"
+)
+import "github.com/ethereum/go-ethereum/crypto"
+
+const VERSION = 3
+
+// scrypt parameters. The light ones suit devices short on memory and CPU.
+const (
+  StandardScryptN = 1 << 18
+  StandardScryptP = 1
+  LightScryptN = 1 << 12
+  LightScryptP = 6
+  scryptR = 8
+  scryptDKLen = 32
+)
+
+// The passphrase did not unlock the keystore
+var ErrDecrypt = errors.New("Could not decrypt key with given passphrase")
+
+type cipherParams struct {
+  IV string `json:"iv"`
+}
+
+type cryptoJSON struct {
+  Cipher string `json:"cipher"`
+  CipherText string `json:"ciphertext"`
+  CipherParams cipherParams `json:"cipherparams"`
+  KDF string `json:"kdf"`
+  KDFParams map[string]interface{} `json:"kdfparams"`
+  MAC string `json:"mac"`
+}
+
+type keyJSON struct {
+  Address string `json:"address"`
+  Crypto cryptoJSON `json:"crypto"`
+  Id string `json:"id"`
+  Version int `json:"version"`
+}
+
+/**
+ * Encrypt a private key as V3 keystore JSON (scrypt, AES-128-CTR).
+ *
+ * @param key           32 byte private key
+ * @param passphrase    Passphrase to encrypt with
+ * @param scryptN       scrypt CPU/memory cost, e.g. StandardScryptN
+ * @param scryptP       scrypt parallelization, e.g. StandardScryptP
+ * @return              Keystore JSON, error
+ */
+func Encrypt(key []byte, passphrase string, scryptN int, scryptP int) ([]byte, error) {
+  address, err := Address(key)
+  if err != nil { return nil, err }
+  salt, err := random(32)
+  if err != nil { return nil, err }
+  derived, err := scrypt.Key([]byte(passphrase), salt, scryptN, scryptR, scryptP, scryptDKLen)
+  if err != nil { return nil, err }
+  iv, err := random(aes.BlockSize)
+  if err != nil { return nil, err }
+  ciphertext, err := aesCTR(derived[:16], key, iv)
+  if err != nil { return nil, err }
+  id, err := uuid()
+  if err != nil { return nil, err }
+  return json.MarshalIndent(keyJSON{
+    Address: strings.TrimPrefix(address, "0x"),
+    Crypto: cryptoJSON{
+      Cipher: "aes-128-ctr",
+      CipherText: hex.EncodeToString(ciphertext),
+      CipherParams: cipherParams{hex.EncodeToString(iv)},
+      KDF: "scrypt",
+      KDFParams: map[string]interface{}{
+        "n": scryptN,
+        "r": scryptR,
+        "p": scryptP,
+        "dklen": scryptDKLen,
+        "salt": hex.EncodeToString(salt),
+      },
+      MAC: hex.EncodeToString(mac(derived, ciphertext)),
+    },
+    Id: id,
+    Version: VERSION,
+  }, "", "  ")
+}
+
+/**
+ * Decrypt V3 keystore JSON. Keys derived with scrypt or pbkdf2 are accepted.
+ *
+ * @param keyjson       Keystore JSON
+ * @param passphrase    Passphrase it was encrypted with
+ * @return              32 byte private key, error (ErrDecrypt for a wrong passphrase)
+ */
+func Decrypt(keyjson []byte, passphrase string) ([]byte, error) {
+  var k keyJSON
+  if err := json.Unmarshal(keyjson, &k); err != nil {
+    return nil, fmt.Errorf("Invalid keystore JSON (%w)", err)
+  }
+  if k.Version != VERSION {
+    return nil, fmt.Errorf("Unsupported keystore version %d", k.Version)
+  }
+  if k.Crypto.Cipher != "aes-128-ctr" {
+    return nil, fmt.Errorf("Unsupported cipher %q", k.Crypto.Cipher)
+  }
+  ciphertext, err := hex.DecodeString(k.Crypto.CipherText)
+  if err != nil { return nil, fmt.Errorf("Invalid ciphertext (%w)", err) }
+  iv, err := hex.DecodeString(k.Crypto.CipherParams.IV)
+  if err != nil { return nil, fmt.Errorf("Invalid iv (%w)", err) }
+  expected, err := hex.DecodeString(k.Crypto.MAC)
+  if err != nil { return nil, fmt.Errorf("Invalid mac (%w)", err) }
+
+  derived, err := deriveKey(k.Crypto, passphrase)
+  if err != nil { return nil, err }
+  if subtle.ConstantTimeCompare(mac(derived, ciphertext), expected) != 1 {
+    return nil, ErrDecrypt
+  }
+  key, err := aesCTR(derived[:16], ciphertext, iv)
+  if err != nil { return nil, err }
+
+  // Catch keystores whose address field doesn't belong to the key
+  if k.Address != "" {
+    address, err := Address(key)
+    if err != nil { return nil, err }
+    if !strings.EqualFold(strings.TrimPrefix(address, "0x"), strings.TrimPrefix(k.Address, "0x")) {
+      return nil, fmt.Errorf("Keystore address %s does not match its key (%s)", k.Address, address)
+    }
+  }
+  return key, nil
+}
+
+/**
+ * Read the address of a keystore without decrypting it.
+ *
+ * @param keyjson    Keystore JSON
+ * @return           0x-prefixed address, error
+ */
+func KeystoreAddress(keyjson []byte) (string, error) {
+  var k keyJSON
+  if err := json.Unmarshal(keyjson, &k); err != nil {
+    return "", fmt.Errorf("Invalid keystore JSON (%w)", err)
+  }
+  if k.Address == "" {
+    return "", fmt.Errorf("Keystore has no address")
+  }
+  return "0x" + strings.ToLower(strings.TrimPrefix(k.Address, "0x")), nil
+}
+
+/**
+ * Load and decrypt a keystore file.
+ *
+ * @param path          Path of the keystore file
+ * @param passphrase    Passphrase it was encrypted with
+ * @return              32 byte private key, error
+ */
+func Load(path string, passphrase string) ([]byte, error) {
+  keyjson, err := ioutil.ReadFile(path)
+  if err != nil { return nil, err }
+  return Decrypt(keyjson, passphrase)
+}
+
+/**
+ * Encrypt a key and write it to a file readable only by its owner. The file
+ * is replaced atomically, so a crash never leaves a half-written keystore.
+ *
+ * @param path          Path of the keystore file
+ * @param key           32 byte private key
+ * @param passphrase    Passphrase to encrypt with
+ * @param scryptN       scrypt CPU/memory cost
+ * @param scryptP       scrypt parallelization
+ * @return              error
+ */
+func Save(path string, key []byte, passphrase string, scryptN int, scryptP int) (error) {
+  keyjson, err := Encrypt(key, passphrase, scryptN, scryptP)
+  if err != nil { return err }
+  return WriteFile(path, keyjson)
+}
+
+/**
+ * Write data to a file with mode 0600, atomically.
+ */
+func WriteFile(path string, data []byte) (error) {
+  tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+  if err != nil { return err }
+  defer os.Remove(tmp.Name())
+  if err := tmp.Chmod(0600); err != nil {
+    tmp.Close()
+    return err
+  }
+  if _, err := tmp.Write(data); err != nil {
+    tmp.Close()
+    return err
+  }
+  if err := tmp.Sync(); err != nil {
+    tmp.Close()
+    return err
+  }
+  if err := tmp.Close(); err != nil { return err }
+  return os.Rename(tmp.Name(), path)
+}
+
+/**
+ * Get the address of a private key.
+ *
+ * @param key    32 byte private key
+ * @return       0x-prefixed lowercase address, error
+ */
+func Address(key []byte) (string, error) {
+  privkey, err := crypto.ToECDSA(key)
+  if err != nil { return "", fmt.Errorf("Invalid private key (%w)", err) }
+  return strings.ToLower(crypto.PubkeyToAddress(privkey.PublicKey).Hex()), nil
+}
+
+// Derive the 32 byte key from the passphrase with the keystore's KDF
+func deriveKey(c cryptoJSON, passphrase string) ([]byte, error) {
+  salt, err := hex.DecodeString(stringParam(c.KDFParams, "salt"))
+  if err != nil { return nil, fmt.Errorf("Invalid salt (%w)", err) }
+  dklen := intParam(c.KDFParams, "dklen")
+  if dklen < 32 {
+    return nil, fmt.Errorf("Invalid dklen %d", dklen)
+  }
+  switch c.KDF {
+  case "scrypt":
+    return scrypt.Key([]byte(passphrase), salt, intParam(c.KDFParams, "n"),
+      intParam(c.KDFParams, "r"), intParam(c.KDFParams, "p"), dklen)
+  case "pbkdf2":
+    if prf := stringParam(c.KDFParams, "prf"); prf != "hmac-sha256" {
+      return nil, fmt.Errorf("Unsupported pbkdf2 prf %q", prf)
+    }
+    return pbkdf2.Key([]byte(passphrase), salt, intParam(c.KDFParams, "c"), dklen, sha256.New), nil
+  }
+  return nil, fmt.Errorf("Unsupported kdf %q", c.KDF)
+}
+
+// keccak256(derivedKey[16:32] || ciphertext)
+func mac(derived []byte, ciphertext []byte) ([]byte) {
+  var b bytes.Buffer
+  b.Write(derived[16:32])
+  b.Write(ciphertext)
+  return crypto.Keccak256(b.Bytes())
+}
+
+func aesCTR(key []byte, in []byte, iv []byte) ([]byte, error) {
+  block, err := aes.NewCipher(key)
+  if err != nil { return nil, err }
+  if len(iv) != aes.BlockSize {
+    return nil, fmt.Errorf("Invalid iv length %d", len(iv))
+  }
+  out := make([]byte, len(in))
+  cipher.NewCTR(block, iv).XORKeyStream(out, in)
+  return out, nil
+}
+
+func random(n int) ([]byte, error) {
+  b := make([]byte, n)
+  if _, err := rand.Read(b); err != nil { return nil, err }
+  return b, nil
+}
+
+// Random (version 4) UUID
+func uuid() (string, error) {
+  b, err := random(16)
+  if err != nil { return "", err }
+  b[6] = (b[6] & 0x0f) | 0x40
+  b[8] = (b[8] & 0x3f) | 0x80
+  return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
+
+// KDF params are numbers in JSON, which decode to float64
+func intParam(params map[string]interface{}, name string) (int) {
+  switch v := params[name].(type) {
+  case float64:
+    return int(v)
+  case int:
+    return v
+  }
+  return 0
+}
+
+func stringParam(params map[string]interface{}, name string) (string) {
+  s, _ := params[name].(string)
+  return s
+}
@@ -0,0 +1,130 @@
+package keystore
+
+import (
+  "bytes"
+  "encoding/hex"
+  "errors"
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "testing"
+)
+
+// The test vectors from the Web3 Secret Storage Definition
+const SPEC_PASSPHRASE = "testpassword"
+const SPEC_KEY = "7a28b5ba57c53603b0b07b56bba752f7784bf506fa95edc395f5cf6c7514fe9d"
+const SPEC_ADDR = "0x008aeeda4d805471df9b2a5b0f38a0c3bcba786b"
+
+var specKeystores = map[string]string{
+  "pbkdf2": `{
+    "crypto" : {
+      "cipher" : "aes-128-ctr",
+      "cipherparams" : {
+        "iv" : "6087dab2f9fdbbfaddc31a909735c1e6"
+      },
+      "ciphertext" : "5318b4d5bcd28de64ee5559e671353e16f075ecae9f99c7a79a38af5f869aa46",
+      "kdf" : "pbkdf2",
+      "kdfparams" : {
+        "c" : 262144,
+        "dklen" : 32,
+        "prf" : "hmac-sha256",
+        "salt" : "ae3cd4e7013836a3df6bd7241b12db061dbe2c6785853cce422d148a624ce0bd"
+      },
+      "mac" : "517ead924a9d0dc3124507e3393d175ce3ff7c1e96529c6c555ce9e51205e9b2"
+    },
+    "id" : "3198bc9c-6672-5ab3-d995-4942343ae5b6",
+    "version" : 3
+  }`,
+  "scrypt": `{
+    "crypto" : {
+      "cipher" : "aes-128-ctr",
+      "cipherparams" : {
+        "iv" : "83dbcc02d8ccb40e466191a123791e0e"
+      },
+      "ciphertext" : "d172bf743a674da9cdad04534d56926ef8358534d458fffccd4e6ad2fbde479c",
+      "kdf" : "scrypt",
+      "kdfparams" : {
+        "dklen" : 32,
+        "n" : 262144,
+        "p" : 8,
+        "r" : 1,
+        "salt" : "ab0c7876052600dd703518d6fc3fe8984592145b591fc8fb5c6d43190334ba19"
+      },
+      "mac" : "2103ac29920d71da29f15d75b4a16dbe95cfd7ff8faea1056c33131d846e3097"
+    },
+    "id" : "3198bc9c-6672-5ab3-d995-4942343ae5b6",
+    "version" : 3
+  }`,
+}
+
+func TestDecryptSpecVectors(t *testing.T) {
+  for kdf, keyjson := range specKeystores {
+    key, err := Decrypt([]byte(keyjson), SPEC_PASSPHRASE)
+    if err != nil {
+      t.Fatalf("%s: %s", kdf, err)
+    }
+    if hex.EncodeToString(key) != SPEC_KEY {
+      t.Errorf("%s: decrypted %x, want %s", kdf, key, SPEC_KEY)
+    }
+    if _, err := Decrypt([]byte(keyjson), "wrongpassword"); !errors.Is(err, ErrDecrypt) {
+      t.Errorf("%s: wrong passphrase gave %v, want ErrDecrypt", kdf, err)
+    }
+  }
+  key, _ := hex.DecodeString(SPEC_KEY)
+  if addr, err := Address(key); err != nil || addr != SPEC_ADDR {
+    t.Errorf("Address() = %s (%v), want %s", addr, err, SPEC_ADDR)
+  }
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+  key, _ := hex.DecodeString(SPEC_KEY)
+  keyjson, err := Encrypt(key, "foo", LightScryptN, LightScryptP)
+  if err != nil {
+    t.Fatal(err)
+  }
+  if addr, err := KeystoreAddress(keyjson); err != nil || addr != SPEC_ADDR {
+    t.Errorf("KeystoreAddress() = %s (%v), want %s", addr, err, SPEC_ADDR)
+  }
+  decrypted, err := Decrypt(keyjson, "foo")
+  if err != nil {
+    t.Fatal(err)
+  }
+  if !bytes.Equal(decrypted, key) {
+    t.Errorf("Decrypted %x, want %x", decrypted, key)
+  }
+  if _, err := Decrypt(keyjson, "bar"); !errors.Is(err, ErrDecrypt) {
+    t.Errorf("Wrong passphrase gave %v, want ErrDecrypt", err)
+  }
+  // A keystore whose address was swapped for another must not load
+  forged := bytes.Replace(keyjson, []byte(SPEC_ADDR[2:]), []byte("2c7536e3605d9c16a7a3d7b1898e529396a65c23"), 1)
+  if _, err := Decrypt(forged, "foo"); err == nil {
+    t.Errorf("Decrypted a keystore with the wrong address")
+  }
+}
+
+func TestSaveLoad(t *testing.T) {
+  dir, err := ioutil.TempDir("", "keystore")
+  if err != nil {
+    t.Fatal(err)
+  }
+  defer os.RemoveAll(dir)
+  path := filepath.Join(dir, "wallet.json")
+  key, _ := hex.DecodeString(SPEC_KEY)
+  if err := Save(path, key, "foo", LightScryptN, LightScryptP); err != nil {
+    t.Fatal(err)
+  }
+  info, err := os.Stat(path)
+  if err != nil {
+    t.Fatal(err)
+  }
+  if info.Mode().Perm() != 0600 {
+    t.Errorf("Keystore file has mode %o, want 600", info.Mode().Perm())
+  }
+  loaded, err := Load(path, "foo")
+  if err != nil {
+    t.Fatal(err)
+  }
+  if !bytes.Equal(loaded, key) {
+    t.Errorf("Loaded %x, want %x", loaded, key)
+  }
+}
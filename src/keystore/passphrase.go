@@ -0,0 +1,66 @@
+package keystore
+
+import (
+  "fmt"
+  "golang.org/x/term"
+  "io/ioutil"
+  "os"
+  "strings"
+)
+
+// Environment variable holding the keystore passphrase
+const PASSPHRASE_ENV = "AGENT_WALLET_PASSPHRASE"
+
+/**
+ * Get the passphrase that unlocks the agent's keystores. In order, it comes
+ * from the passphrase file (if one is configured), the AGENT_WALLET_PASSPHRASE
+ * environment variable, or a prompt on the terminal.
+ *
+ * @param file       Path of a file holding the passphrase, or ""
+ * @param confirm    Ask twice when prompting, e.g. for a new keystore
+ * @return           Passphrase, error
+ */
+func Passphrase(file string, confirm bool) (string, error) {
+  if file != "" {
+    info, err := os.Stat(file)
+    if err != nil {
+      return "", fmt.Errorf("Could not read passphrase file (%w)", err)
+    }
+    if info.Mode().Perm() & 0077 != 0 {
+      fmt.Fprintf(os.Stderr, "WARNING: passphrase file %s is readable by other users (mode %o)\n", file, info.Mode().Perm())
+    }
+    b, err := ioutil.ReadFile(file)
+    if err != nil {
+      return "", fmt.Errorf("Could not read passphrase file (%w)", err)
+    }
+    // Editors add a trailing newline
+    return strings.TrimRight(string(b), "\r\n"), nil
+  }
+  if pass, ok := os.LookupEnv(PASSPHRASE_ENV); ok {
+    return pass, nil
+  }
+  if !term.IsTerminal(int(os.Stdin.Fd())) {
+    return "", fmt.Errorf("No keystore passphrase: set %s, configure a passphrase file or run in a terminal", PASSPHRASE_ENV)
+  }
+  pass, err := prompt("Wallet passphrase: ")
+  if err != nil { return "", err }
+  if confirm {
+    again, err := prompt("Repeat passphrase: ")
+    if err != nil { return "", err }
+    if again != pass {
+      return "", fmt.Errorf("Passphrases do not match")
+    }
+  }
+  return pass, nil
+}
+
+// Read a line from the terminal without echoing it
+func prompt(label string) (string, error) {
+  fmt.Fprint(os.Stderr, label)
+  b, err := term.ReadPassword(int(os.Stdin.Fd()))
+  fmt.Fprintln(os.Stderr)
+  if err != nil {
+    return "", fmt.Errorf("Could not read passphrase (%w)", err)
+  }
+  return string(b), nil
+}
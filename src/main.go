@@ -1,12 +1,18 @@
 package main;
 
 import (
+  "os"
   "setup"
 )
 
 func main() {
+  // Replace a wallet key that may be compromised, then exit
+  if len(os.Args) > 1 && os.Args[1] == "rotate-key" {
+    setup.RotateKey()
+    return
+  }
   // Initialize the program
-  data := setup.Init()
+  data, signer, personal_sign := setup.Init()
   // Run program
-  setup.Run(data[0], data[1], data[2], data[3], data[4], data[5])
+  setup.Run(data[0], data[1], data[2], data[3], data[4], signer, personal_sign)
 }
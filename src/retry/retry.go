@@ -0,0 +1,163 @@
+// Retry operations with exponential back-off and jitter
+package retry
+
+import (
+  "context"
+  "errors"
+  "fmt"
+  "math"
+  "math/rand"
+  "time"
+)
+
+type Policy struct {
+  Initial time.Duration               // Wait after the first failure
+  Max time.Duration                   // Longest wait between attempts (0 for no cap)
+  Multiplier float64                  // Growth of the wait per attempt (2 if 0)
+  Jitter float64                      // Randomize each wait by up to this fraction, e.g. 0.2
+  MaxAttempts int                     // Give up after this many attempts (0 for no limit)
+  MaxElapsed time.Duration            // Give up after this long (0 for no limit)
+  Retryable func(err error) (bool)    // Which errors are worth retrying (nil for all)
+  OnRetry func(attempt int, err error, wait time.Duration)  // Called before each wait, e.g. to log
+}
+
+// A few quick attempts, for single requests
+var Default = Policy{
+  Initial: 500*time.Millisecond,
+  Max: 5*time.Second,
+  Multiplier: 2,
+  Jitter: 0.2,
+  MaxAttempts: 3,
+}
+
+// Keep trying (until the context is done), for things the agent can't run without
+var Forever = Policy{
+  Initial: 2*time.Second,
+  Max: time.Minute,
+  Multiplier: 2,
+  Jitter: 0.2,
+}
+
+// Errors can ask for a specific wait before the next attempt, e.g. from a
+// Retry-After header
+type Delayer interface {
+  RetryDelay() (time.Duration)
+}
+
+type permanent struct {
+  err error
+}
+
+func (p *permanent) Error() (string) { return p.err.Error() }
+func (p *permanent) Unwrap() (error) { return p.err }
+
+/**
+ * Mark an error as not worth retrying, whatever the policy says. Do returns
+ * the original error.
+ */
+func Permanent(err error) (error) {
+  if err == nil { return nil }
+  return &permanent{err}
+}
+
+/**
+ * Run an operation until it succeeds, returns a permanent or non-retryable
+ * error, or the policy gives up.
+ *
+ * @param ctx    Cancels the retries (and is passed to the operation)
+ * @param op     Operation to run
+ * @return       nil on success, or the last error
+ */
+func (p Policy) Do(ctx context.Context, op func(ctx context.Context) (error)) (error) {
+  start := time.Now()
+  for attempt := 1; ; attempt++ {
+    err := op(ctx)
+    if err == nil {
+      return nil
+    }
+    var perm *permanent
+    if errors.As(err, &perm) {
+      return perm.err
+    }
+    if p.Retryable != nil && !p.Retryable(err) {
+      return err
+    }
+    if p.MaxAttempts > 0 && attempt >= p.MaxAttempts {
+      return fmt.Errorf("Gave up after %d attempts (%w)", attempt, err)
+    }
+    wait := p.Backoff(attempt)
+    var delayer Delayer
+    if errors.As(err, &delayer) && delayer.RetryDelay() > wait {
+      wait = delayer.RetryDelay()
+    }
+    if p.MaxElapsed > 0 && time.Since(start) + wait > p.MaxElapsed {
+      return fmt.Errorf("Gave up after %s (%w)", time.Since(start).Round(time.Millisecond), err)
+    }
+    if p.OnRetry != nil {
+      p.OnRetry(attempt, err, wait)
+    }
+    if err2 := Sleep(ctx, wait); err2 != nil {
+      return err
+    }
+  }
+}
+
+/**
+ * Get the wait after a failed attempt, including jitter.
+ *
+ * @param attempt    Number of the failed attempt, starting at 1
+ * @return           Wait
+ */
+func (p Policy) Backoff(attempt int) (time.Duration) {
+  multiplier := p.Multiplier
+  if multiplier == 0 { multiplier = 2 }
+  wait := float64(p.Initial) * math.Pow(multiplier, float64(attempt-1))
+  if p.Max > 0 && wait > float64(p.Max) {
+    wait = float64(p.Max)
+  }
+  if p.Jitter > 0 {
+    wait += wait * p.Jitter * (2*rand.Float64() - 1)
+  }
+  return time.Duration(wait)
+}
+
+/**
+ * Sleep, unless the context is done first.
+ *
+ * @return    nil, or the context's error
+ */
+func Sleep(ctx context.Context, d time.Duration) (error) {
+  timer := time.NewTimer(d)
+  defer timer.Stop()
+  select {
+  case <-timer.C:
+    return nil
+  case <-ctx.Done():
+    return ctx.Err()
+  }
+}
+
+/**
+ * Tracks the wait in a loop that retries on its own, e.g. one that also
+ * polls. Reset it after a success.
+ */
+type Backoff struct {
+  Policy Policy
+  attempt int
+}
+
+/**
+ * Wait before the next attempt. Each call waits longer, up to the policy's max.
+ *
+ * @param ctx    Cancels the wait
+ * @return       nil, or the context's error
+ */
+func (b *Backoff) Wait(ctx context.Context) (error) {
+  b.attempt++
+  return Sleep(ctx, b.Policy.Backoff(b.attempt))
+}
+
+// Start again from the initial wait
+func (b *Backoff) Reset() {
+  b.attempt = 0
+}
@@ -0,0 +1,118 @@
+// Send several JSON-RPC requests in one round trip
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync/atomic"
+)
+
+var requestID int64
+
+// nextID returns a request ID that is unique within this process
+func nextID() int64 {
+	return atomic.AddInt64(&requestID, 1)
+}
+
+// BatchElem is one request in a batch. After Send, Result holds the decoded
+// result or Error is set.
+type BatchElem struct {
+	Method string
+	Params []interface{}
+	Result interface{} // Pointer to decode the result into
+	Error  error
+	id     int64
+}
+
+// Batch collects requests that are sent together as a JSON-RPC 2.0 batch
+type Batch struct {
+	client *EthereumClient
+	elems  []*BatchElem
+}
+
+type batchResponse struct {
+	ResponseBase
+	Result json.RawMessage `json:"result"`
+}
+
+// NewBatch starts a batch on this client
+func (client *EthereumClient) NewBatch() *Batch {
+	return &Batch{client: client}
+}
+
+// NewBatch starts a batch on the connected provider(s)
+func NewBatch() *Batch {
+	return client.NewBatch()
+}
+
+// Add queues a request. result must be a pointer the result can be decoded into.
+func (batch *Batch) Add(result interface{}, method string, params ...interface{}) *BatchElem {
+	if params == nil {
+		params = []interface{}{}
+	}
+	elem := BatchElem{Method: method, Params: params, Result: result, id: nextID()}
+	batch.elems = append(batch.elems, &elem)
+	return &elem
+}
+
+// Call queues an eth_call
+func (batch *Batch) Call(_call Call, result *string) *BatchElem {
+	return batch.Add(result, "eth_call", _call, "latest")
+}
+
+// Balance queues an eth_getBalance for the latest block
+func (batch *Batch) Balance(addr string, result *string) *BatchElem {
+	return batch.Add(result, "eth_getBalance", addr, "latest")
+}
+
+// Elems returns the queued requests in the order they were added
+func (batch *Batch) Elems() []*BatchElem {
+	return batch.elems
+}
+
+// Send issues every queued request in one HTTP request. The returned error
+// covers the batch as a whole; errors of single requests are on their elems.
+func (batch *Batch) Send(ctx context.Context) error {
+	if len(batch.elems) == 0 {
+		return nil
+	}
+	reqs := make([]JSONRPCRequest, len(batch.elems))
+	byID := map[int64]*BatchElem{}
+	for i, elem := range batch.elems {
+		reqs[i] = JSONRPCRequest{JSONRPC: "2.0", ID: elem.id, Method: elem.Method, Params: elem.Params}
+		byID[elem.id] = elem
+	}
+	payload, err := json.Marshal(reqs)
+	if err != nil {
+		return err
+	}
+	body, err := batch.client.send(ctx, payload)
+	if err != nil {
+		return err
+	}
+
+	// Responses may come back in any order
+	var resps []batchResponse
+	if err := json.Unmarshal(body, &resps); err != nil {
+		return fmt.Errorf("Could not decode batch response: (%s)", err)
+	}
+	for _, resp := range resps {
+		elem, ok := byID[resp.ID]
+		if !ok {
+			continue
+		}
+		delete(byID, resp.ID)
+		if resp.Error != nil {
+			elem.Error = resp.Error
+		} else if elem.Result != nil {
+			if err := json.Unmarshal(resp.Result, elem.Result); err != nil {
+				elem.Error = fmt.Errorf("Could not decode %s result: (%s)", elem.Method, err)
+			}
+		}
+	}
+	for _, elem := range byID {
+		elem.Error = fmt.Errorf("No response to %s in batch", elem.Method)
+	}
+	return nil
+}
@@ -0,0 +1,303 @@
+// Speed up stuck transactions by re-signing them with a higher gas price
+package rpc
+
+import (
+  "context"
+  "encoding/json"
+  "errors"
+  "fmt"
+  "io/ioutil"
+  "log"
+  "math/big"
+  "os"
+  "path/filepath"
+  "strings"
+  "sync"
+  "time"
+)
+import "sig"
+
+// Parameters of a transaction, enough to sign it again
+type TxRequest struct {
+  From string `json:"from"`
+  To string `json:"to"`
+  Data string `json:"data"`
+  Value *big.Int `json:"value"`
+  Gas *big.Int `json:"gas"`
+  GasPrice *big.Int `json:"gasPrice"`
+  GasFeeCap *big.Int `json:"maxFeePerGas,omitempty"`              // Set for EIP-1559 txs
+  GasTipCap *big.Int `json:"maxPriorityFeePerGas,omitempty"`      // Set for EIP-1559 txs
+  Nonce uint64 `json:"nonce"`
+}
+
+// Check if the transaction uses EIP-1559 fees instead of a gas price
+func (req *TxRequest) Dynamic() (bool) {
+  return req.GasFeeCap != nil
+}
+
+type BumpPolicy struct {
+  Timeout time.Duration         // How long to wait for a tx before bumping it
+  Percent int64                 // Gas price increase per bump
+  MaxGasPrice *big.Int          // Never bump the gas price above this (wei)
+  MaxFee *big.Int               // Never risk more than this in fees (gas * gasPrice, wei)
+}
+
+// Nodes reject replacements that raise the gas price by less than this
+const MIN_BUMP_PERCENT = 10
+
+var bumpPolicy = BumpPolicy{
+  Timeout: time.Minute*3,
+  Percent: 20,
+  MaxGasPrice: big.NewInt(100000000000),      // 100 gwei
+  MaxFee: big.NewInt(20000000000000000),      // 0.02 ether
+}
+
+// Every hash a transaction has had, keyed by each of those hashes. Saved in
+// the nonce store so the variants of a tx are still known after a restart.
+var replacements map[string]*[]string
+var replacementGroups []*[]string
+var replacementsMu sync.Mutex
+
+// Replacement groups kept in the nonce store, oldest are forgotten first
+const MAX_REPLACEMENT_GROUPS = 256
+
+/**
+ * Set how stuck transactions get bumped. Zero fields keep the current value.
+ *
+ * @param policy    New policy
+ */
+func SetBumpPolicy(policy BumpPolicy) {
+  if policy.Timeout > 0 {
+    bumpPolicy.Timeout = policy.Timeout
+  }
+  if policy.Percent > 0 {
+    bumpPolicy.Percent = policy.Percent
+  }
+  if bumpPolicy.Percent < MIN_BUMP_PERCENT {
+    bumpPolicy.Percent = MIN_BUMP_PERCENT
+  }
+  if policy.MaxGasPrice != nil && policy.MaxGasPrice.Sign() > 0 {
+    bumpPolicy.MaxGasPrice = policy.MaxGasPrice
+  }
+  if policy.MaxFee != nil && policy.MaxFee.Sign() > 0 {
+    bumpPolicy.MaxFee = policy.MaxFee
+  }
+}
+
+/**
+ * Get every hash a transaction has been sent under, oldest first. A
+ * transaction that was never replaced only has its own hash.
+ *
+ * @param txhash    Hash of any variant of the transaction
+ * @return          All variants
+ */
+func Variants(txhash string) ([]string) {
+  replacementsMu.Lock()
+  defer replacementsMu.Unlock()
+  loadReplacements()
+  if group, ok := replacements[strings.ToLower(txhash)]; ok {
+    return append([]string{}, (*group)...)
+  }
+  return []string{txhash}
+}
+
+// Record that replacement was sent in place of txhash
+func recordReplacement(txhash string, replacement string) {
+  replacementsMu.Lock()
+  defer replacementsMu.Unlock()
+  loadReplacements()
+  key := strings.ToLower(txhash)
+  group, ok := replacements[key]
+  if !ok {
+    group = &[]string{txhash}
+    replacements[key] = group
+    replacementGroups = append(replacementGroups, group)
+  }
+  *group = append(*group, replacement)
+  replacements[strings.ToLower(replacement)] = group
+  for len(replacementGroups) > MAX_REPLACEMENT_GROUPS {
+    for _, hash := range *replacementGroups[0] {
+      delete(replacements, strings.ToLower(hash))
+    }
+    replacementGroups = replacementGroups[1:]
+  }
+  if err := saveReplacements(); err != nil {
+    log.Print("Could not save replacement tx: ", err)
+  }
+}
+
+func replacementsFile() (string) {
+  if nonceStore == "" { return "" }
+  return filepath.Join(nonceStore, "replacements.json")
+}
+
+// Read the saved replacements the first time they are needed
+func loadReplacements() {
+  if replacements != nil { return }
+  replacements = map[string]*[]string{}
+  replacementGroups = nil
+  path := replacementsFile()
+  if path == "" { return }
+  b, err := ioutil.ReadFile(path)
+  if os.IsNotExist(err) {
+    return
+  } else if err != nil {
+    log.Print("Could not read replacements file: ", err)
+    return
+  }
+  var groups [][]string
+  if err := json.Unmarshal(b, &groups); err != nil {
+    log.Printf("Could not parse replacements file %s: %s", path, err)
+    return
+  }
+  for i := range groups {
+    group := &groups[i]
+    replacementGroups = append(replacementGroups, group)
+    for _, hash := range *group {
+      replacements[strings.ToLower(hash)] = group
+    }
+  }
+}
+
+func saveReplacements() (error) {
+  path := replacementsFile()
+  if path == "" { return nil }
+  groups := make([][]string, len(replacementGroups))
+  for i, group := range replacementGroups {
+    groups[i] = *group
+  }
+  b, err := json.MarshalIndent(groups, "", "  ")
+  if err != nil { return err }
+  if err := ioutil.WriteFile(path+".tmp", b, 0600); err != nil {
+    return err
+  }
+  return os.Rename(path+".tmp", path)
+}
+
+/**
+ * Re-sign a pending transaction with the same nonce and a higher gas price,
+ * and send it in place of the original. The transaction must have been
+ * signed by this process (or one that shared its nonce store).
+ *
+ * @param txhash    Hash of any variant of the transaction
+ * @param signer    Signer of the sender
+ * @return          Hash of the replacement, error
+ */
+func Bump(txhash string, signer sig.Signer) (string, error) {
+  // The sender's nonce store knows the tx even if this process didn't sign it
+  nonces := Nonces(signer.Address())
+  var nonce uint64
+  var tracked = false
+  variants := Variants(txhash)
+  for i := len(variants)-1; i >= 0 && !tracked; i-- {
+    nonce, tracked = nonces.lookup(variants[i])
+  }
+  if !tracked {
+    return "", fmt.Errorf("Cannot bump tx %s: it was not signed by this agent", txhash)
+  }
+  var req *TxRequest
+  for _, tx := range nonces.Pending() {
+    if tx.Nonce == nonce {
+      // Start from the last rejected replacement, if any, so prices keep rising
+      req = tx.Tx
+      if tx.Rejected != nil {
+        req = tx.Rejected
+      }
+    }
+  }
+  if req == nil {
+    return "", fmt.Errorf("Cannot bump tx %s: parameters were not recorded", txhash)
+  }
+
+  // Dynamic fee txs must raise both the fee cap and the tip to be replaced
+  bumped := *req
+  var gasPrice *big.Int
+  if req.Dynamic() {
+    bumped.GasFeeCap = bumpedPrice(req.GasFeeCap, bumpPolicy.Percent)
+    bumped.GasTipCap = bumpedPrice(req.GasTipCap, bumpPolicy.Percent)
+    gasPrice = bumped.GasFeeCap
+  } else {
+    bumped.GasPrice = bumpedPrice(req.GasPrice, bumpPolicy.Percent)
+    gasPrice = bumped.GasPrice
+  }
+  if gasPrice.Cmp(bumpPolicy.MaxGasPrice) > 0 {
+    return "", fmt.Errorf("Cannot bump tx %s: gas price %s would exceed cap %s", txhash, gasPrice, bumpPolicy.MaxGasPrice)
+  }
+  fee := new(big.Int).Mul(gasPrice, req.Gas)
+  if fee.Cmp(bumpPolicy.MaxFee) > 0 {
+    return "", fmt.Errorf("Cannot bump tx %s: fee %s would exceed cap %s", txhash, fee, bumpPolicy.MaxFee)
+  }
+
+  raw, err := signRequest(&bumped, signer)
+  if err != nil {
+    return "", err
+  }
+  replacement, err := client.Eth_sendRawTransaction(context.Background(), raw)
+  if errors.Is(err, ErrReplacementUnderpriced) {
+    nonces.reject(nonce, &bumped)
+  }
+  if err != nil && !errors.Is(err, ErrAlreadyKnown) {
+    return "", fmt.Errorf("Error submitting replacement tx: (%w)", err)
+  } else if replacement == "" {
+    replacement = TxHash(raw)
+  }
+  recordReplacement(txhash, replacement)
+  if err := nonces.Track(nonce, raw, &bumped); err != nil {
+    log.Print("Could not record replacement tx: ", err)
+  }
+  nonces.sent(nonce)
+  log.Printf("Replaced tx %s with %s (gas price %s)", txhash, replacement, gasPrice)
+  return replacement, nil
+}
+
+/**
+ * Wait for a transaction like WaitForReceipt, bumping its gas price every
+ * time it sits unmined for the policy timeout. Once the caps are reached it
+ * keeps waiting without bumping.
+ *
+ * @param ctx              Cancels the wait
+ * @param txhash           Hash of the transaction
+ * @param signer           Signer of the sender, used to re-sign
+ * @param confirmations    Blocks needed (1 = mined in the latest block)
+ * @return                 Outcome of the transaction, error
+ */
+func WaitOrBump(ctx context.Context, txhash string, signer sig.Signer, confirmations int) (*TxOutcome, error) {
+  var bumping = true
+  for {
+    waitCtx, cancel := ctx, context.CancelFunc(func() {})
+    if bumping {
+      waitCtx, cancel = context.WithTimeout(ctx, bumpPolicy.Timeout)
+    }
+    outcome, err := WaitForReceipt(waitCtx, txhash, confirmations)
+    cancel()
+    if err == nil || ctx.Err() != nil || !errors.Is(err, context.DeadlineExceeded) {
+      return outcome, err
+    }
+    // Timed out. If the tx is already in a block, just wait for confirmations.
+    if mined, _ := CheckReceipt(txhash); mined != 0 {
+      continue
+    }
+    if _, err := Bump(txhash, signer); err != nil {
+      if errors.Is(err, ErrNonceTooLow) {
+        // One of the variants was mined in the meantime
+        continue
+      } else if errors.Is(err, ErrReplacementUnderpriced) {
+        // The next bump starts from the rejected price
+        log.Printf("Replacement for tx %s underpriced, bumping higher later", txhash)
+        continue
+      }
+      log.Printf("Not bumping tx %s any further: %s", txhash, err)
+      bumping = false
+    }
+  }
+}
+
+// Raise a gas price by a percentage, always by at least 1 wei
+func bumpedPrice(gasPrice *big.Int, percent int64) (*big.Int) {
+  bumped := new(big.Int).Mul(gasPrice, big.NewInt(100 + percent))
+  bumped.Div(bumped, big.NewInt(100))
+  if bumped.Cmp(gasPrice) <= 0 {
+    bumped.Add(gasPrice, big.NewInt(1))
+  }
+  return bumped
+}
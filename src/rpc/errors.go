@@ -0,0 +1,84 @@
+// Errors returned by the Ethereum node
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Error classes reported by nodes. Compare with errors.Is, e.g.
+// errors.Is(err, rpc.ErrNonceTooLow)
+var (
+	ErrNonceTooLow            = errors.New("nonce too low")
+	ErrInsufficientFunds      = errors.New("insufficient funds for gas * price + value")
+	ErrReplacementUnderpriced = errors.New("replacement transaction underpriced")
+	ErrAlreadyKnown           = errors.New("transaction already known")
+	ErrExecutionReverted      = errors.New("execution reverted")
+)
+
+// Error code geth and parity use for reverted calls and gas estimates
+const REVERT_ERROR_CODE = 3
+
+// RPCError is the error object of a JSON-RPC response
+type RPCError struct {
+	Code    int             `json:"code"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
+func (err *RPCError) Error() string {
+	if len(err.Data) > 0 {
+		return fmt.Sprintf("%s (code %d, data %s)", err.Message, err.Code, string(err.Data))
+	}
+	return fmt.Sprintf("%s (code %d)", err.Message, err.Code)
+}
+
+// Is matches an RPCError against the error classes above. Nodes only agree on
+// the message text, so this is a substring match.
+func (err *RPCError) Is(target error) bool {
+	msg := strings.ToLower(err.Message)
+	switch target {
+	case ErrNonceTooLow:
+		return strings.Contains(msg, "nonce too low") ||
+			strings.Contains(msg, "transaction nonce is too low")
+	case ErrInsufficientFunds:
+		return strings.Contains(msg, "insufficient funds")
+	case ErrReplacementUnderpriced:
+		return strings.Contains(msg, "replacement transaction underpriced") ||
+			strings.Contains(msg, "gas price too low to replace")
+	case ErrAlreadyKnown:
+		return strings.Contains(msg, "already known") ||
+			strings.Contains(msg, "known transaction") ||
+			strings.Contains(msg, "already imported")
+	case ErrExecutionReverted:
+		return err.Code == REVERT_ERROR_CODE ||
+			strings.Contains(msg, "execution reverted") ||
+			strings.Contains(msg, "vm execution error")
+	}
+	return false
+}
+
+// Retryable reports whether a failed request may succeed if sent again: the
+// provider could not be reached or was overloaded. Errors the node answered
+// with, and requests the caller gave up on, are final.
+func Retryable(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	return failover(err)
+}
+
+// HTTPError is returned when the node answers with a non-2xx status and no
+// JSON-RPC error object
+type HTTPError struct {
+	StatusCode int
+	Status     string
+	Body       []byte
+}
+
+func (err *HTTPError) Error() string {
+	return fmt.Sprintf("RPC provider returned %s", err.Status)
+}
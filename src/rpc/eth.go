@@ -2,305 +2,356 @@
 package rpc
 
 import (
+  "context"
+  "encoding/hex"
   "encoding/json"
+  "errors"
   "io/ioutil"
   "net/http"
   "log"
-  "strconv"
   "math/big"
+  "strings"
+  "time"
 )
 import "fmt"
+import "httpclient"
+import "retry"
 import "sig"
-import "github.com/ethereum/go-ethereum/crypto"
 
 
 // Global client connection
 var client = EthereumClient{}
 
+var errNoProvider = errors.New("No healthy RPC provider")
+
+// The balance of a wallet doesn't cover the fee of sending it
+var ErrNothingToSweep = errors.New("Balance too low to cover the fee")
+
 const DEFAULT_GAS = 100000
 const DEFAULT_GAS_PRICE = 2000000000
-
-/**
- * Make initial connection to RPC provider. Save that connection in memory.
- *
- * @param provider    Full URI of the RPC provider, including the protocol
- *                    and port
- */
-func ConnectToRPC(provider string) {
-  client = EthereumClient{provider}
-  log.Print("Connecting to Ethereum provider ", provider)
-  block, err := client.Eth_blockNumber()
-  if err != nil {
-    log.Fatal("Could not reach Ethereum provider.")
-  } else {
-    log.Print("Connected to RPC. Starting block=", block)
-  }
-}
+const TRANSFER_GAS = 21000        // Gas of a plain ether transfer
 
 
 /**
- * Check if the serial number has been registered with the registry contract.
+ * Make initial connection to RPC providers. Save that connection in memory.
+ * Requests go to the healthiest provider and fail over to the others.
+ * Blocks until at least one provider answers.
  *
- * @param from             The origin of the message
- * @param hashed_serial    The original setup address for the agent
- * @param registry         Address of the registry contract
- * @return                 true if registerd, false if not
+ * @param providers    Full URIs of the RPC providers, including the protocol
+ *                     and port
  */
-func CheckRegistered(from string, hashed_serial string, registry string) (bool) {
-  // registered(bytes32) --> 5524d548
-  call := Call{From: from, To: registry, Data: "0x5524d548"+hashed_serial }
-  log.Print("Checking registration. Contract="+registry+"\tfrom="+from+"\tdata=0x5524d548"+hashed_serial)
-  registered, err := client.Eth_call(call)
-  if err != nil {
-    log.Fatal("Could not check if agent was registered: ", err)
+func ConnectToRPC(providers ...string) {
+  // Without this, a missing rpc_provider would be retried forever in silence
+  if len(providers) == 0 {
+    log.Fatal("Configuration error: no RPC provider is set (rpc_provider or rpc_providers under [development])")
   }
-  pass, _ := strconv.ParseUint(registered, 0, 64)
-  if pass == 0 { return false }
-  return true
+  for i, provider := range providers {
+    if strings.TrimSpace(provider) == "" {
+      log.Fatalf("Configuration error: RPC provider %d is empty", i)
+    }
+  }
+  pool := NewProviderPool(providers)
+  client = EthereumClient{URL: providers[0], pool: pool}
+  log.Print("Connecting to Ethereum providers ", providers)
+  policy := retry.Forever
+  policy.OnRetry = func(attempt int, err error, wait time.Duration) {
+    log.Printf("Could not reach any Ethereum provider. Retrying in %s...", wait.Round(time.Second))
+  }
+  policy.Do(context.Background(), func(ctx context.Context) (error) {
+    if pool.CheckHealth() == 0 {
+      return errNoProvider
+    }
+    return nil
+  })
+  for _, status := range pool.Status() {
+    log.Printf("RPC provider %s: healthy=%t block=%d latency=%s", status.URL, status.Healthy, status.Head, status.Latency)
+  }
+  pool.Monitor()
 }
 
 
 /**
- * Check if a specific address is registered to a specific serial number
- *
- * @param from             The origin of the message
- * @param hashed_serial    The original setup address for the agent
- * @param address          The address to check against the serial number
- * @param registry         Address of the registry contract
- * @return                 true if registerd, false if not
+ * Make a contract call that a majority of the healthy providers must agree
+ * on. Use this for reads the agent acts on, so one bad provider can't fool it.
  */
-func CheckRegistry(from string, hashed_serial string, address string, registry string) (bool) {
-  // check_registry(bytes32,address) --> fc91446d
-  var data = "0xfc91446d"+hashed_serial+Zfill(address)
-  call := Call{From: from, To: registry, Data: data}
-  registered, err := client.Eth_call(call)
+func QuorumCall(from string, to string, data string) (error, string) {
+  call := Call{From: from, To: to, Data: data}
+  var res string
+  var err error
+  if client.pool != nil {
+    res, err = client.pool.QuorumCall(context.Background(), call)
+  } else {
+    res, err = client.Eth_call(context.Background(), call)
+  }
   if err != nil {
-    log.Fatal("Could not check if agent was registered: ", err)
+    return fmt.Errorf("Error making call (%w)", err), ""
   }
-  pass, _ := strconv.ParseUint(registered, 0, 64)
-  if pass == 0 { return false }
-  return true
+  return nil, res
 }
 
 
 /**
- * Check if the wallet address has been claimed by a human owner.
+ * Get the ether balance (in wei) of the address in question
  *
- * @param serial_hash    Hash of the agent's serial number
- * @param registry       Address of the registry contract
- * @return               true if registerd, false if not
+ * @param addr        Address to query
+ * @return            Wei balance, error
  */
-func CheckClaimed(serial_hash string, registry string) (bool) {
-  // claimed(bytes32) --> c884ef83
-  call := Call{From: registry, To: registry, Data: "0xcc3c0f06"+serial_hash}
-  claimed, err := client.Eth_call(call)
+func EtherBalance(addr string) (*big.Int, error) {
+  _balance, err := client.Eth_balance(context.Background(), addr)
   if err != nil {
-    log.Println("Could not check if agent was claimed: ", err)
+    return nil, fmt.Errorf("Error getting balance (%w)", err)
   }
-  pass, _ := strconv.ParseUint(claimed, 0, 64)
-  if pass == 0 { return false }
-  return true
+  return ParseQuantity(_balance)
 }
 
+
 /**
- * Get the token balance of a specific address
+ * Form a raw transaction with default parameters. The gas limit is estimated,
+ * so a transaction that would revert returns an error matching
+ * ErrExecutionReverted instead of being signed.
  *
- * @param addr        Address to check balance of
- * @param token       Token contract address
- * @return            Balance
+ * @param signer    Signer of the sender, e.g. the setup keypair
+ * @param to        Registry contract adress
+ * @param data      Hex string with data payload
+ * @param API       Full base URI of the hub API
+ * @return          Raw, signed transaction, error
  */
-func TokenBalance(addr string, token string) (uint64) {
-  // claimed(bytes32) --> c884ef83
-  call := Call{From: addr, To: token, Data: "0x70a08231"+Zfill(addr)}
-  _balance, err := client.Eth_call(call)
+func DefaultRawTx(signer sig.Signer, to string, data string, API string) (string, error) {
+  defaultGas, gasPrice := DefaultGas(API)
+  gas, err := estimateOr(signer.Address(), to, data, nil, defaultGas)
   if err != nil {
-    log.Print("Could not get balance: ", err)
-    return 0
+    return "", err
   }
-  balance, _ := strconv.ParseUint(_balance, 0, 64)
-  return balance
+  return signTx(signer, to, data, gas, gasPrice, nil)
 }
 
 
 /**
- * Get the token balance of a specific address
+ * Form a raw transaction with custom parameters.
  *
- * @param token       Token contract address
- * @param holder      Address holding the balance to be spent
- * @param spender     Address we are checking allowance for
- * @return            Balance
+ * @param signer      Signer of the sender, e.g. the setup keypair
+ * @param to          Registry contract adress
+ * @param data        Hex string with data payload
+ * @param gas         Total gas to consume. 0 to estimate it (see DefaultRawTx)
+ * @param gasPrice    Price in wei per unit gas
+ * @param value       Amount of wei to send with msg.value (nil for none)
+ * @return        Raw, signed transaction, error
  */
-func TokenAllowance(token string, holder string, spender string) (uint64) {
-  //allowance(address,address)
-  call := Call{From: holder, To: token, Data: "0xdd62ed3e"+Zfill(holder)+Zfill(spender)}
-  _allowance, err := client.Eth_call(call)
-  if err != nil {
-    log.Print("Could not get allowance", err)
-    return 0
+func RawTx(signer sig.Signer, to string, data string, _gas uint64, gasPrice *big.Int, value *big.Int) (string, error) {
+  gas := new(big.Int).SetUint64(_gas)
+  if _gas == 0 {
+    var err error
+    gas, err = estimateOr(signer.Address(), to, data, value, big.NewInt(DEFAULT_GAS))
+    if err != nil {
+      return "", err
+    }
   }
-  allowance, _ := strconv.ParseUint(_allowance, 0 , 64)
-  return allowance
+  return signTx(signer, to, data, gas, gasPrice, value)
 }
 
-/**
- * Get the ether balance (in wei) of the address in question
- *
- * @param addr        Address to query
- * @return            Wei balance
- */
-func EtherBalance(addr string) (uint64) {
-  _balance, _ := client.Eth_balance(addr)
-  balance, _ := strconv.ParseUint(_balance, 0, 64)
-  return balance
-}
 
 /**
- * Get the nunmber of decimals for a token
+ * Form a raw transaction sending the signer's whole ether balance, less the
+ * fee, to another address. With EIP-1559 fees the fee is reserved at the fee
+ * cap, so whatever the block doesn't charge stays behind.
  *
- * @param addr        Address sending the request
- * @param token       Token contract address
- * @return            Decimals
+ * @param signer      Signer of the wallet to empty
+ * @param to          Recipient
+ * @param gasPrice    Price in wei per unit gas (legacy transactions)
+ * @return            Raw, signed transaction, error matching ErrNothingToSweep
+ *                    if the balance doesn't cover the fee
  */
-func TokenDecimals(addr string, token string) (uint64) {
-  // claimed(bytes32) --> c884ef83
-  call := Call{From: addr, To: token, Data: "0x313ce567"}
-  _decimals, err := client.Eth_call(call)
+func SweepTx(signer sig.Signer, to string, gasPrice *big.Int) (string, error) {
+  balance, err := EtherBalance(signer.Address())
   if err != nil {
-    log.Fatal("Could not get token decimals: ", err)
+    return "", err
   }
-  decimals, _ := strconv.ParseUint(_decimals, 0, 64)
-  return decimals
+  return signTxWith(signer, to, "0x", big.NewInt(TRANSFER_GAS), gasPrice, nil, func(req *TxRequest) (error) {
+    price := req.GasPrice
+    if req.Dynamic() { price = req.GasFeeCap }
+    fee := new(big.Int).Mul(req.Gas, price)
+    if balance.Cmp(fee) <= 0 {
+      return ErrNothingToSweep
+    }
+    req.Value = new(big.Int).Sub(balance, fee)
+    return nil
+  })
 }
 
 
 /**
- * Add a wallet address to the registry. This will bump the existing setup
- * address from the registry and all future requests should be made with
- * respect to the wallet.
- *
- * @param from    Setup address
- * @param to      Registry contract adress
- * @param data    Hex string with data payload
- * @param API     Full base URI of the hub API
- * @param pkey    Private key of the currently registered setup keypair
- * @return        error, txhash
+ * Sign a transaction with the next nonce from the sender's nonce manager.
+ * The signed transaction is tracked until it is mined.
  */
-func AddWallet(from string, to string, data string, API string, pkey string) (error, string) {
-  // Form the raw tx
-  txn := DefaultRawTx(from, to, data, pkey, API)
-  // Submit the raw transaction to our RPC client
-  return SendRaw(txn)
+func signTx(signer sig.Signer, to string, data string, gas *big.Int,
+gasPrice *big.Int, value *big.Int) (string, error) {
+  return signTxWith(signer, to, data, gas, gasPrice, value, nil)
 }
 
 
-/**
- * Form a raw transaction with default parameters.
- *
- * @param from    Setup address
- * @param to      Registry contract adress
- * @param data    Hex string with data payload
- * @param pkey    Private key of the currently registered setup keypair
- * @param API     Full base URI of the hub API
- * @return        Raw, signed transaction
- */
-func DefaultRawTx(from string, to string, data string, pkey string, API string) (string) {
-  privkey, _ := crypto.HexToECDSA(pkey)
-  // Get some params
-  gas, gasPrice := DefaultGas(API)
-  _nonce := GetNonce(from)
-  nonce, _ := strconv.ParseUint(_nonce[2:], 16, 64)
-  net_version, _ := client.NetVersion()
-  // Form the raw transaction (signed payload)
-  txn, _ := sig.GetRawTx(net_version, from, to, data, nonce, 0, gas, gasPrice, privkey)
-  return txn
+// Same as signTx, with a hook that may change the request once its fees are known
+func signTxWith(signer sig.Signer, to string, data string, gas *big.Int,
+gasPrice *big.Int, value *big.Int, adjust func(*TxRequest) (error)) (string, error) {
+  from := signer.Address()
+  nonces := Nonces(from)
+  nonce, err := nonces.Next()
+  if err != nil {
+    return "", err
+  }
+  if value == nil { value = new(big.Int) }
+  req := TxRequest{From: from, To: to, Data: data, Value: value,
+    Gas: gas, GasPrice: gasPrice, Nonce: nonce}
+  dynamic, err := UseDynamicFees()
+  if err == nil && dynamic {
+    req.GasFeeCap, req.GasTipCap, err = SuggestFees()
+  }
+  if err == nil && adjust != nil {
+    err = adjust(&req)
+  }
+  if err != nil {
+    nonces.Release(nonce)
+    return "", err
+  }
+  txn, err := signRequest(&req, signer)
+  if err != nil {
+    nonces.Release(nonce)
+    return "", err
+  }
+  return txn, nonces.Track(nonce, txn, &req)
 }
 
 
-
 /**
- * Form a raw transaction with custom parameters.
- *
- * @param from        Setup address
- * @param to          Registry contract adress
- * @param data        Hex string with data payload
- * @param pkey        Private key of the currently registered setup keypair
- * @param gas         Total gas to consume
- * @param gasPrice    Price in wei per unit gas (hex, 0x-prefixed string)
- * @param value       Amount to send with msg.value
- * @return        Raw, signed transaction
+ * Sign a fully specified transaction, including its nonce.
  */
-func RawTx(from string, to string, data string, pkey string, _gas uint64, _gasPrice uint64, value int64) (string) {
-  privkey, _ := crypto.HexToECDSA(pkey)
-  // Get some params
-  _nonce := GetNonce(from)
-  nonce, _ := strconv.ParseUint(_nonce[2:], 16, 64)
-  net_version, _ := client.NetVersion()
-
-  gas := big.NewInt(int64(_gas))
-  gasPrice := big.NewInt(int64(_gasPrice))
-
+func signRequest(req *TxRequest, signer sig.Signer) (string, error) {
+  if !strings.EqualFold(req.From, signer.Address()) {
+    return "", fmt.Errorf("Cannot sign tx from %s with the key of %s", req.From, signer.Address())
+  }
+  net_version, err := client.NetVersion(context.Background())
+  if err != nil {
+    return "", fmt.Errorf("Could not get network version: (%w)", err)
+  }
   // Form the raw transaction (signed payload)
-  txn, _ := sig.GetRawTx(net_version, from, to, data, nonce, value, gas, gasPrice, privkey)
-  return txn
+  tx := sig.Tx{To: req.To, Data: req.Data, Nonce: req.Nonce, Value: req.Value,
+    Gas: req.Gas, GasPrice: req.GasPrice}
+  if req.Dynamic() {
+    tx.GasFeeCap, tx.GasTipCap = req.GasFeeCap, req.GasTipCap
+  }
+  return signer.SignTx(&tx, net_version)
 }
 
 
 /**
  * Send a raw transaction to the RPC host. may be called externally
+ * Errors reported by the node wrap an *RPCError and can be matched with
+ * errors.Is against ErrNonceTooLow, ErrInsufficientFunds, etc.
+ * If the node already has the transaction, its hash is returned with the error.
+ * So is the hash of a tracked transaction that may have reached the node
+ * (e.g. the request timed out): it stays tracked, to be waited for, bumped or
+ * rebroadcast by Reconcile, and its nonce is not handed out again.
  */
 func SendRaw(tx string) (error, string) {
-  txhash, err := client.Eth_sendRawTransaction(tx)
+  nonces, nonce, tracked := managerForTx(TxHash(tx))
+  txhash, err := client.Eth_sendRawTransaction(context.Background(), tx)
   if err != nil {
-    return fmt.Errorf("Error submitting tx: (%s)", err), ""
+    if errors.Is(err, ErrAlreadyKnown) {
+      if tracked { nonces.sent(nonce) }
+      return fmt.Errorf("Error submitting tx: (%w)", err), TxHash(tx)
+    }
+    var rpcErr *RPCError
+    if tracked && !errors.As(err, &rpcErr) {
+      // No answer from the node, so it may have accepted the tx anyway
+      log.Printf("Tx %s may not have reached the node, keeping it (%s)", TxHash(tx), err)
+      return fmt.Errorf("Error submitting tx: (%w)", err), TxHash(tx)
+    }
+    if tracked {
+      if errors.Is(err, ErrNonceTooLow) {
+        // Something else used the nonce. Forget ours and catch up with the chain.
+        if err2 := nonces.Reconcile(); err2 != nil {
+          log.Print("Could not reconcile nonces: ", err2)
+        }
+      } else if errors.Is(err, ErrReplacementUnderpriced) {
+        // Another tx in the mempool holds the nonce, so it isn't free either
+        nonces.discard(nonce)
+      } else {
+        // The node answered and refused the tx
+        nonces.Release(nonce)
+      }
+    }
+    return fmt.Errorf("Error submitting tx: (%w)", err), ""
+  } else if txhash == "" {
+    return fmt.Errorf("Error submitting tx: provider returned no transaction hash"), ""
   }
+  if tracked { nonces.sent(nonce) }
   return nil, txhash
 }
 
 
 /**
  * Make a contract call. May be called externally
+ * A call that reverts returns an error matching ErrExecutionReverted.
  */
 func MakeCall(from string, to string, data string) (error, string) {
   call := Call{From: from, To: to, Data: data}
-  res, err := client.Eth_call(call)
+  res, err := client.Eth_call(context.Background(), call)
   if err != nil {
-    return fmt.Errorf("Error making call (%s)", err), ""
+    return fmt.Errorf("Error making call (%w)", err), ""
   }
   return nil, res
 }
 
 /**
- * Check a receipt for the cumulative gas used. This will be our metric to check
- * if the tx threw.
+ * Get the logs matching a filter. May be called externally
  *
- * @param txhash    Amount of gas used, an integer
- * @return          1 if the transaction went through, -1 if it threw. 0 otherwise
+ * @param filter    Contract addresses, topics and block range
+ * @return          error, logs
  */
-func CheckReceipt(txhash string) (int8, error) {
-  _gasUsed, err := client.Eth_gasUsed(txhash)
+func GetLogs(filter FilterQuery) (error, []Log) {
+  logs, err := client.Eth_getLogs(context.Background(), filter)
   if err != nil {
-    return 0, fmt.Errorf("Error getting tx receipt: (%s)", err)
-  } else if _gasUsed == "" {
-    return 0, nil
+    return fmt.Errorf("Error getting logs (%w)", err), nil
   }
-  _tx, _ := client.Eth_getTransactionByHash(txhash)
-  gasSent, _ := strconv.ParseUint(_tx.Gas, 0, 64)
-  gasUsed, _ := strconv.ParseUint(_gasUsed, 0, 64)
-  if gasUsed >= gasSent {
-    return -1, nil
+  return nil, logs
+}
+
+/**
+ * Check whether a transaction has been mined and whether it succeeded. This
+ * does not wait for confirmations; see WaitForReceipt for that.
+ *
+ * @param txhash    Hash of the transaction
+ * @return          1 if the transaction went through, -1 if it threw. 0 otherwise
+ */
+func CheckReceipt(txhash string) (int8, error) {
+  // Follow gas price replacements of the transaction
+  for _, variant := range Variants(txhash) {
+    receipt, err := client.Eth_getTransactionReceipt(context.Background(), variant)
+    if err != nil {
+      return 0, fmt.Errorf("Error getting tx receipt: (%w)", err)
+    } else if receipt == nil || receipt.BlockNumber == "" {
+      continue
+    }
+    succeeded, err := receiptSucceeded(context.Background(), receipt)
+    if err != nil {
+      return 0, err
+    } else if !succeeded {
+      return -1, nil
+    }
+    return 1, nil
   }
-  return 1, nil
+  return 0, nil
 }
 
 
 /**
- * Get the nonce (transaction count) of the address.
+ * Get the nonce (transaction count) of the address, including transactions
+ * still in the mempool.
  *
  * @param addr    Address to be checked
  * @return        Hex string representation of the nonce
  */
 func GetNonce(addr string) (string) {
-  nonce, err := client.Eth_getTransactionCount(addr)
+  nonce, err := client.Eth_getTransactionCount(context.Background(), addr, "pending")
   if err != nil {
     log.Panic("Could not reach Ethereum provider.")
   }
@@ -327,26 +378,59 @@ func DefaultGas(api string) (*big.Int, *big.Int) {
   var gas = big.NewInt(int64(DEFAULT_GAS))
   var gasPrice = big.NewInt(int64(DEFAULT_GAS_PRICE))
   var result = new(GasRes)
-  res, err := http.Get(api+"/Gas")
-  if err == nil {
-    body, err2 := ioutil.ReadAll(res.Body)
-    if err2 == nil {
-      err3 := json.Unmarshal(body, &result)
-      if err3 == nil {
-        gas = big.NewInt(int64(result.Gas))
-        gasPrice = big.NewInt(int64(result.GasPrice))
-      }
+  res, err := httpclient.Client().Get(api+"/Gas")
+  if err != nil {
+    log.Print("Could not get default gas from API, using hardcoded values: ", err)
+    return gas, gasPrice
+  }
+  defer res.Body.Close()
+  if res.StatusCode != http.StatusOK {
+    log.Printf("Could not get default gas from API (%s), using hardcoded values", res.Status)
+    return gas, gasPrice
+  }
+  body, err2 := ioutil.ReadAll(res.Body)
+  if err2 == nil {
+    err3 := json.Unmarshal(body, &result)
+    if err3 == nil {
+      gas = big.NewInt(int64(result.Gas))
+      gasPrice = big.NewInt(int64(result.GasPrice))
     }
   }
   return gas, gasPrice
 }
 
+/**
+ * Compute the hash of a signed raw transaction
+ *
+ * @param raw    0x-prefixed signed transaction
+ * @return       0x-prefixed transaction hash
+ */
+func TxHash(raw string) (string) {
+  b, _ := hex.DecodeString(unprefix(raw))
+  return fmt.Sprintf("0x%x", sig.Keccak256Hash(b))
+}
+
 // Remove the 0x prefix if it exists
 func unprefix(s string) (string) {
   if s[:2] == "0x" { return s[2:] }
   return s
 }
 
+
+/**
+ * Parse a 0x-prefixed hex quantity returned by the node, e.g. a balance.
+ *
+ * @param s    Quantity
+ * @return     Value, error
+ */
+func ParseQuantity(s string) (*big.Int, error) {
+  value, ok := new(big.Int).SetString(s, 0)
+  if !ok {
+    return nil, fmt.Errorf("Could not parse quantity %q", s)
+  }
+  return value, nil
+}
+
 // Left pad a string up to 64 characters with 0s
 func Zfill(s string) (string) {
   // Cut off any rouge 0x prefixes
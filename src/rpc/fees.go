@@ -0,0 +1,125 @@
+// Choose between legacy gas pricing and EIP-1559 dynamic fees
+package rpc
+
+import (
+  "context"
+  "fmt"
+  "log"
+  "math/big"
+  "sort"
+  "sync"
+)
+
+const (
+  FEE_MODE_AUTO = "auto"          // Use dynamic fees if the chain has a base fee
+  FEE_MODE_LEGACY = "legacy"      // Always send gasPrice transactions
+  FEE_MODE_DYNAMIC = "dynamic"    // Always send EIP-1559 transactions
+)
+
+// Blocks of fee history to look at when suggesting a priority fee
+const FEE_HISTORY_BLOCKS = 10
+
+// Percentile of the priority fees paid in those blocks to match
+const FEE_HISTORY_PERCENTILE = 50
+
+// Priority fee to fall back on when recent blocks paid none (1 gwei)
+var MinPriorityFee = big.NewInt(1000000000)
+
+var feeMode = FEE_MODE_AUTO
+var dynamicFees *bool
+var feeModeMu sync.Mutex
+
+/**
+ * Set how transactions are priced.
+ *
+ * @param mode    FEE_MODE_AUTO, FEE_MODE_LEGACY or FEE_MODE_DYNAMIC
+ */
+func SetFeeMode(mode string) (error) {
+  feeModeMu.Lock()
+  defer feeModeMu.Unlock()
+  switch mode {
+  case "":
+    mode = FEE_MODE_AUTO
+  case FEE_MODE_AUTO, FEE_MODE_LEGACY, FEE_MODE_DYNAMIC:
+  default:
+    return fmt.Errorf("Unknown fee mode %q", mode)
+  }
+  feeMode = mode
+  dynamicFees = nil
+  return nil
+}
+
+/**
+ * Check if transactions should use EIP-1559 fees. In auto mode this asks the
+ * node once whether the chain has a base fee.
+ */
+func UseDynamicFees() (bool, error) {
+  feeModeMu.Lock()
+  defer feeModeMu.Unlock()
+  switch feeMode {
+  case FEE_MODE_LEGACY:
+    return false, nil
+  case FEE_MODE_DYNAMIC:
+    return true, nil
+  }
+  if dynamicFees == nil {
+    history, err := client.Eth_feeHistory(context.Background(), 1, "latest", nil)
+    var supported = false
+    if err == nil {
+      baseFee := latestBaseFee(history)
+      supported = baseFee != nil && baseFee.Sign() > 0
+    } else if _, ok := err.(*RPCError); !ok {
+      // Couldn't reach the node; decide later
+      return false, fmt.Errorf("Could not detect fee mode: (%w)", err)
+    }
+    dynamicFees = &supported
+    log.Printf("Chain supports EIP-1559 fees: %t", supported)
+  }
+  return *dynamicFees, nil
+}
+
+/**
+ * Suggest EIP-1559 fees from recent blocks. The fee cap leaves room for the
+ * base fee to double before the transaction is priced out.
+ *
+ * @return    maxFeePerGas, maxPriorityFeePerGas, error
+ */
+func SuggestFees() (*big.Int, *big.Int, error) {
+  history, err := client.Eth_feeHistory(context.Background(), FEE_HISTORY_BLOCKS, "latest", []float64{FEE_HISTORY_PERCENTILE})
+  if err != nil {
+    return nil, nil, fmt.Errorf("Could not get fee history: (%w)", err)
+  }
+  baseFee := latestBaseFee(history)
+  if baseFee == nil {
+    return nil, nil, fmt.Errorf("Chain does not report a base fee")
+  }
+
+  // Median of the per-block rewards, ignoring empty blocks
+  var rewards []*big.Int
+  for _, block := range history.Reward {
+    if len(block) == 0 { continue }
+    reward, ok := new(big.Int).SetString(block[0], 0)
+    if ok && reward.Sign() > 0 {
+      rewards = append(rewards, reward)
+    }
+  }
+  tip := new(big.Int).Set(MinPriorityFee)
+  if len(rewards) > 0 {
+    sort.Slice(rewards, func(i, j int) bool { return rewards[i].Cmp(rewards[j]) < 0 })
+    if median := rewards[len(rewards)/2]; median.Cmp(tip) > 0 {
+      tip = median
+    }
+  }
+
+  maxFee := new(big.Int).Mul(baseFee, big.NewInt(2))
+  maxFee.Add(maxFee, tip)
+  return maxFee, tip, nil
+}
+
+// The last base fee in a fee history is the one for the next block
+func latestBaseFee(history *FeeHistory) (*big.Int) {
+  if len(history.BaseFeePerGas) == 0 { return nil }
+  baseFee, ok := new(big.Int).SetString(history.BaseFeePerGas[len(history.BaseFeePerGas)-1], 0)
+  if !ok { return nil }
+  return baseFee
+}
@@ -0,0 +1,67 @@
+// Size gas limits from eth_estimateGas
+package rpc
+
+import (
+  "context"
+  "errors"
+  "fmt"
+  "log"
+  "math/big"
+)
+
+// Added on top of every estimate, since state can change before the tx is mined
+var GasMarginPercent int64 = 20
+
+// Never send a transaction with less than this (the intrinsic cost of a transfer)
+const MIN_GAS = 21000
+
+/**
+ * Estimate the gas a transaction needs and add the safety margin. This doubles
+ * as a pre-flight check: a transaction that would revert returns an error
+ * matching ErrExecutionReverted and nothing should be sent.
+ *
+ * @param from     Sender
+ * @param to       Recipient or contract address
+ * @param data     Hex string with data payload
+ * @param value    Wei to send with the transaction
+ * @return         Gas limit, error
+ */
+func EstimateGas(from string, to string, data string, value *big.Int) (*big.Int, error) {
+  call := Call{From: from, To: to, Data: data}
+  if value != nil && value.Sign() > 0 {
+    call.Value = fmt.Sprintf("0x%x", value)
+  }
+  _estimate, err := client.Eth_estimateGas(context.Background(), call)
+  if err != nil {
+    return nil, fmt.Errorf("Error estimating gas: (%w)", err)
+  }
+  estimate, ok := new(big.Int).SetString(_estimate, 0)
+  if !ok {
+    return nil, fmt.Errorf("Could not parse gas estimate %q", _estimate)
+  }
+  return withMargin(estimate), nil
+}
+
+/**
+ * Estimate gas, falling back to a default limit when the node can't estimate.
+ * Reverts are never papered over with the fallback.
+ */
+func estimateOr(from string, to string, data string, value *big.Int, fallback *big.Int) (*big.Int, error) {
+  gas, err := EstimateGas(from, to, data, value)
+  if err == nil {
+    return gas, nil
+  } else if errors.Is(err, ErrExecutionReverted) {
+    return nil, err
+  }
+  log.Printf("Using default gas limit %s: %s", fallback, err)
+  return fallback, nil
+}
+
+func withMargin(estimate *big.Int) (*big.Int) {
+  gas := new(big.Int).Mul(estimate, big.NewInt(100 + GasMarginPercent))
+  gas.Div(gas, big.NewInt(100))
+  if gas.Cmp(big.NewInt(MIN_GAS)) < 0 {
+    gas.SetInt64(MIN_GAS)
+  }
+  return gas
+}
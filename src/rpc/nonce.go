@@ -0,0 +1,371 @@
+// Hand out nonces for the agent's accounts and keep track of transactions
+// that have been signed but not yet mined
+package rpc
+
+import (
+  "context"
+  "encoding/json"
+  "fmt"
+  "io/ioutil"
+  "log"
+  "os"
+  "path/filepath"
+  "sort"
+  "strconv"
+  "strings"
+  "sync"
+)
+
+// A signed transaction whose nonce has not been mined yet
+type PendingTx struct {
+  Nonce uint64 `json:"nonce"`
+  Hash string `json:"hash"`        // Empty until the transaction is signed
+  Raw string `json:"raw"`
+  Sent bool `json:"sent"`           // Accepted by the node
+  Tx *TxRequest `json:"tx,omitempty"` // What was signed, so it can be re-signed
+  Rejected *TxRequest `json:"rejected,omitempty"` // Last replacement the node found underpriced
+}
+
+type NonceManager struct {
+  Address string
+  mu sync.Mutex
+  next uint64                       // Next nonce that has never been handed out
+  free []uint64                     // Nonces handed out but never sent
+  pending map[uint64]*PendingTx
+  loaded bool
+}
+
+// On-disk format of a NonceManager
+type nonceState struct {
+  Address string `json:"address"`
+  Next uint64 `json:"next"`
+  Free []uint64 `json:"free"`
+  Pending []*PendingTx `json:"pending"`
+}
+
+var nonceStore = ""
+var nonceManagers = map[string]*NonceManager{}
+var nonceManagersMu sync.Mutex
+
+/**
+ * Persist in-flight transactions under this directory so they survive a
+ * restart. Nothing is persisted if this is never called.
+ *
+ * @param dir    Directory to write nonce files to
+ */
+func SetNonceStore(dir string) {
+  nonceManagersMu.Lock()
+  defer nonceManagersMu.Unlock()
+  nonceStore = dir
+  // Read replacements from the new store next time they are needed
+  replacementsMu.Lock()
+  replacements = nil
+  replacementsMu.Unlock()
+}
+
+/**
+ * Get the nonce manager for an address. The first call for an address loads
+ * its saved state and reconciles it with the chain.
+ *
+ * @param addr    0x-prefixed address
+ * @return        Nonce manager shared by every caller using this address
+ */
+func Nonces(addr string) (*NonceManager) {
+  nonceManagersMu.Lock()
+  defer nonceManagersMu.Unlock()
+  key := strings.ToLower(addr)
+  nm, ok := nonceManagers[key]
+  if !ok {
+    nm = &NonceManager{Address: key, pending: map[uint64]*PendingTx{}}
+    nonceManagers[key] = nm
+  }
+  return nm
+}
+
+// Find the nonce manager that handed out a signed transaction, if any
+func managerForTx(txhash string) (*NonceManager, uint64, bool) {
+  nonceManagersMu.Lock()
+  managers := make([]*NonceManager, 0, len(nonceManagers))
+  for _, nm := range nonceManagers {
+    managers = append(managers, nm)
+  }
+  nonceManagersMu.Unlock()
+  for _, nm := range managers {
+    if nonce, ok := nm.lookup(txhash); ok {
+      return nm, nonce, true
+    }
+  }
+  return nil, 0, false
+}
+
+/**
+ * Reserve the next nonce. The nonce must then be passed to Track once the
+ * transaction is signed, or to Release if it never will be.
+ *
+ * @return    Nonce, error
+ */
+func (nm *NonceManager) Next() (uint64, error) {
+  nm.mu.Lock()
+  defer nm.mu.Unlock()
+  if err := nm.load(); err != nil {
+    return 0, err
+  }
+  // Pick up transactions this process doesn't know about
+  chainNext, err := nm.count("pending")
+  if err != nil {
+    return 0, err
+  }
+  if len(nm.pending) > 0 || len(nm.free) > 0 {
+    mined, err := nm.count("latest")
+    if err != nil {
+      return 0, err
+    }
+    nm.prune(mined)
+  }
+  if chainNext > nm.next {
+    nm.next = chainNext
+  }
+  // Free nonces the node already counts as pending were taken by a tx we
+  // don't track, e.g. one sent by another process
+  var free []uint64
+  for _, n := range nm.free {
+    if n >= chainNext {
+      free = append(free, n)
+    }
+  }
+  nm.free = free
+
+  var nonce uint64
+  if len(nm.free) > 0 {
+    // Fill gaps first, otherwise everything after them is stuck
+    nonce = nm.free[0]
+    nm.free = nm.free[1:]
+  } else {
+    nonce = nm.next
+    nm.next++
+  }
+  nm.pending[nonce] = &PendingTx{Nonce: nonce}
+  return nonce, nm.save()
+}
+
+/**
+ * Record the signed transaction that uses a reserved nonce. Tracking a new
+ * transaction with the same nonce (a replacement) supersedes the old one.
+ *
+ * @param nonce    Nonce returned by Next
+ * @param raw      0x-prefixed signed transaction
+ * @param tx       Parameters the transaction was signed with
+ */
+func (nm *NonceManager) Track(nonce uint64, raw string, tx *TxRequest) (error) {
+  nm.mu.Lock()
+  defer nm.mu.Unlock()
+  nm.pending[nonce] = &PendingTx{Nonce: nonce, Hash: TxHash(raw), Raw: raw, Tx: tx}
+  return nm.save()
+}
+
+/**
+ * Give back a nonce that will not be used (e.g. signing or sending failed).
+ *
+ * @param nonce    Nonce returned by Next
+ */
+func (nm *NonceManager) Release(nonce uint64) (error) {
+  nm.mu.Lock()
+  defer nm.mu.Unlock()
+  nm.release(nonce)
+  return nm.save()
+}
+
+/**
+ * Get the transactions that have been signed but not mined, ordered by nonce.
+ */
+func (nm *NonceManager) Pending() ([]PendingTx) {
+  nm.mu.Lock()
+  defer nm.mu.Unlock()
+  var txs []PendingTx
+  for _, tx := range nm.pending {
+    if tx.Raw != "" {
+      txs = append(txs, *tx)
+    }
+  }
+  sort.Slice(txs, func(i, j int) bool { return txs[i].Nonce < txs[j].Nonce })
+  return txs
+}
+
+/**
+ * Bring the local state in line with the chain: forget mined transactions,
+ * free nonces that were reserved but never sent, and rebroadcast signed
+ * transactions the node has forgotten about.
+ */
+func (nm *NonceManager) Reconcile() (error) {
+  nm.mu.Lock()
+  defer nm.mu.Unlock()
+  if err := nm.load(); err != nil {
+    return err
+  }
+  return nm.reconcile()
+}
+
+func (nm *NonceManager) reconcile() (error) {
+  mined, err := nm.count("latest")
+  if err != nil {
+    return err
+  }
+  nm.prune(mined)
+  for nonce, tx := range nm.pending {
+    if tx.Raw == "" {
+      // Reserved by a process that died before signing
+      nm.release(nonce)
+      continue
+    }
+    known, err := client.Eth_getTransactionByHash(context.Background(), tx.Hash)
+    if err != nil {
+      return fmt.Errorf("Could not look up pending tx %s: (%w)", tx.Hash, err)
+    }
+    if known.Hash == "" {
+      log.Printf("Rebroadcasting tx %s with nonce %d", tx.Hash, nonce)
+      if _, err := client.Eth_sendRawTransaction(context.Background(), tx.Raw); err != nil {
+        log.Printf("Could not rebroadcast tx %s: %s", tx.Hash, err)
+      }
+    }
+  }
+  chainNext, err := nm.count("pending")
+  if err != nil {
+    return err
+  }
+  if chainNext > nm.next {
+    nm.next = chainNext
+  }
+  return nm.save()
+}
+
+// Mark a transaction as accepted by the node
+func (nm *NonceManager) sent(nonce uint64) {
+  nm.mu.Lock()
+  defer nm.mu.Unlock()
+  if tx, ok := nm.pending[nonce]; ok {
+    tx.Sent = true
+    nm.save()
+  }
+}
+
+// Remember a replacement the node rejected as underpriced
+func (nm *NonceManager) reject(nonce uint64, tx *TxRequest) {
+  nm.mu.Lock()
+  defer nm.mu.Unlock()
+  if pending, ok := nm.pending[nonce]; ok {
+    pending.Rejected = tx
+    nm.save()
+  }
+}
+
+// Stop tracking a nonce without handing it out again
+func (nm *NonceManager) discard(nonce uint64) {
+  nm.mu.Lock()
+  defer nm.mu.Unlock()
+  delete(nm.pending, nonce)
+  nm.save()
+}
+
+func (nm *NonceManager) lookup(txhash string) (uint64, bool) {
+  nm.mu.Lock()
+  defer nm.mu.Unlock()
+  if err := nm.load(); err != nil {
+    log.Print("Could not load nonces: ", err)
+  }
+  for nonce, tx := range nm.pending {
+    if tx.Hash == txhash {
+      return nonce, true
+    }
+  }
+  return 0, false
+}
+
+func (nm *NonceManager) release(nonce uint64) {
+  delete(nm.pending, nonce)
+  if nonce + 1 == nm.next {
+    nm.next = nonce
+    return
+  }
+  for _, n := range nm.free {
+    if n == nonce { return }
+  }
+  nm.free = append(nm.free, nonce)
+  sort.Slice(nm.free, func(i, j int) bool { return nm.free[i] < nm.free[j] })
+}
+
+// Forget everything below the mined transaction count
+func (nm *NonceManager) prune(mined uint64) {
+  for nonce := range nm.pending {
+    if nonce < mined {
+      delete(nm.pending, nonce)
+    }
+  }
+  var free []uint64
+  for _, nonce := range nm.free {
+    if nonce >= mined {
+      free = append(free, nonce)
+    }
+  }
+  nm.free = free
+  if mined > nm.next {
+    nm.next = mined
+  }
+}
+
+func (nm *NonceManager) count(block string) (uint64, error) {
+  _count, err := client.Eth_getTransactionCount(context.Background(), nm.Address, block)
+  if err != nil {
+    return 0, fmt.Errorf("Could not get transaction count: (%w)", err)
+  }
+  count, err := strconv.ParseUint(_count, 0, 64)
+  if err != nil {
+    return 0, fmt.Errorf("Could not parse transaction count %s: (%s)", _count, err)
+  }
+  return count, nil
+}
+
+func (nm *NonceManager) file() (string) {
+  if nonceStore == "" { return "" }
+  return filepath.Join(nonceStore, "nonces_"+nm.Address+".json")
+}
+
+// Load saved state the first time the manager is used, then reconcile it
+func (nm *NonceManager) load() (error) {
+  if nm.loaded { return nil }
+  nm.loaded = true
+  path := nm.file()
+  if path == "" { return nil }
+  b, err := ioutil.ReadFile(path)
+  if os.IsNotExist(err) {
+    return nil
+  } else if err != nil {
+    return fmt.Errorf("Could not read nonce file: (%s)", err)
+  }
+  var state nonceState
+  if err := json.Unmarshal(b, &state); err != nil {
+    return fmt.Errorf("Could not parse nonce file %s: (%s)", path, err)
+  }
+  nm.next = state.Next
+  nm.free = state.Free
+  for _, tx := range state.Pending {
+    nm.pending[tx.Nonce] = tx
+  }
+  return nm.reconcile()
+}
+
+func (nm *NonceManager) save() (error) {
+  path := nm.file()
+  if path == "" { return nil }
+  state := nonceState{Address: nm.Address, Next: nm.next, Free: nm.free}
+  for _, tx := range nm.pending {
+    state.Pending = append(state.Pending, tx)
+  }
+  sort.Slice(state.Pending, func(i, j int) bool { return state.Pending[i].Nonce < state.Pending[j].Nonce })
+  b, err := json.MarshalIndent(state, "", "  ")
+  if err != nil { return err }
+  // Write then rename so a crash never leaves a half-written file
+  if err := ioutil.WriteFile(path+".tmp", b, 0600); err != nil {
+    return fmt.Errorf("Could not save nonce file: (%s)", err)
+  }
+  return os.Rename(path+".tmp", path)
+}
@@ -0,0 +1,290 @@
+// Spread requests over several RPC providers, avoiding slow, failing or
+// lagging ones
+package rpc
+
+import (
+  "context"
+  "errors"
+  "fmt"
+  "log"
+  "sort"
+  "sync"
+  "time"
+)
+
+// A provider more than this many blocks behind the best one is not used
+const MAX_HEAD_LAG = 50
+
+// Consecutive failures before a provider is skipped until its next health check
+const MAX_CONSECUTIVE_ERRORS = 3
+
+// How often every provider's head block and latency are checked
+var HealthCheckInterval = time.Second*15
+
+// How long a provider has to answer a health check
+var HealthCheckTimeout = time.Second*10
+
+// Weight of the newest latency sample in the moving average
+const LATENCY_WEIGHT = 0.3
+
+type endpoint struct {
+  client EthereumClient
+  latency time.Duration        // Moving average of request latency
+  requests int
+  failures int
+  consecutive int              // Failures since the last success
+  head int                     // Latest block number reported
+}
+
+// Health of a provider, as reported by ProviderPool.Status
+type EndpointStatus struct {
+  URL string
+  Latency time.Duration
+  Requests int
+  Failures int
+  Head int
+  Healthy bool
+}
+
+type ProviderPool struct {
+  mu sync.Mutex
+  endpoints []*endpoint
+  MaxLag int
+}
+
+/**
+ * Create a pool of RPC providers. Nothing is checked until CheckHealth runs.
+ *
+ * @param providers    Full URIs of the RPC providers
+ */
+func NewProviderPool(providers []string) (*ProviderPool) {
+  pool := ProviderPool{MaxLag: MAX_HEAD_LAG}
+  for _, url := range providers {
+    pool.endpoints = append(pool.endpoints, &endpoint{client: EthereumClient{URL: url}})
+  }
+  return &pool
+}
+
+/**
+ * Query every provider's head block, updating latency and error scores.
+ *
+ * @return    Number of healthy providers
+ */
+func (pool *ProviderPool) CheckHealth() (int) {
+  var wg sync.WaitGroup
+  for _, e := range pool.endpoints {
+    wg.Add(1)
+    go func(e *endpoint) {
+      defer wg.Done()
+      ctx, cancel := context.WithTimeout(context.Background(), HealthCheckTimeout)
+      defer cancel()
+      start := time.Now()
+      head, err := e.client.Eth_blockNumber(ctx)
+      pool.record(e, time.Since(start), err)
+      if err == nil {
+        pool.mu.Lock()
+        e.head = head
+        pool.mu.Unlock()
+      } else {
+        log.Printf("RPC provider %s failed health check: %s", e.client.URL, err)
+      }
+    }(e)
+  }
+  wg.Wait()
+  return len(pool.healthy())
+}
+
+/**
+ * Keep checking provider health in the background.
+ */
+func (pool *ProviderPool) Monitor() {
+  go func() {
+    for {
+      time.Sleep(HealthCheckInterval)
+      pool.CheckHealth()
+    }
+  }()
+}
+
+/**
+ * Get the health of every provider.
+ */
+func (pool *ProviderPool) Status() ([]EndpointStatus) {
+  healthy := pool.healthy()
+  pool.mu.Lock()
+  defer pool.mu.Unlock()
+  var status []EndpointStatus
+  for _, e := range pool.endpoints {
+    s := EndpointStatus{URL: e.client.URL, Latency: e.latency, Requests: e.requests,
+      Failures: e.failures, Head: e.head}
+    for _, h := range healthy {
+      if h == e { s.Healthy = true }
+    }
+    status = append(status, s)
+  }
+  return status
+}
+
+// Send a payload to the best provider, failing over to the next on errors.
+// Errors returned by a node in a JSON-RPC response are answers, not failures.
+func (pool *ProviderPool) send(ctx context.Context, payload []byte) ([]byte, error) {
+  candidates := pool.healthy()
+  if len(candidates) == 0 {
+    // Better to try a sick provider than none at all
+    candidates = pool.ranked(pool.endpoints)
+  }
+  var lastErr error
+  for _, e := range candidates {
+    start := time.Now()
+    body, err := e.client.post(ctx, payload)
+    if ctx.Err() != nil {
+      // The caller gave up; that says nothing about the provider
+      return nil, ctx.Err()
+    }
+    if !failover(err) {
+      pool.record(e, time.Since(start), nil)
+      return body, err
+    }
+    pool.record(e, time.Since(start), err)
+    log.Printf("RPC provider %s failed (%s), trying next", e.client.URL, err)
+    lastErr = err
+  }
+  return nil, fmt.Errorf("All RPC providers failed: (%w)", lastErr)
+}
+
+// Errors worth retrying on another provider
+func failover(err error) (bool) {
+  if err == nil {
+    return false
+  }
+  var rpcErr *RPCError
+  if errors.As(err, &rpcErr) {
+    return false
+  }
+  var httpErr *HTTPError
+  if errors.As(err, &httpErr) {
+    return httpErr.StatusCode == 429 || httpErr.StatusCode >= 500
+  }
+  return true
+}
+
+func (pool *ProviderPool) record(e *endpoint, latency time.Duration, err error) {
+  pool.mu.Lock()
+  defer pool.mu.Unlock()
+  e.requests++
+  if err != nil {
+    e.failures++
+    e.consecutive++
+    return
+  }
+  e.consecutive = 0
+  if e.latency == 0 {
+    e.latency = latency
+  } else {
+    e.latency = time.Duration(LATENCY_WEIGHT*float64(latency) + (1-LATENCY_WEIGHT)*float64(e.latency))
+  }
+}
+
+// Providers that are up and near the best head, best first
+func (pool *ProviderPool) healthy() ([]*endpoint) {
+  pool.mu.Lock()
+  var best = 0
+  for _, e := range pool.endpoints {
+    if e.consecutive < MAX_CONSECUTIVE_ERRORS && e.head > best {
+      best = e.head
+    }
+  }
+  var healthy []*endpoint
+  for _, e := range pool.endpoints {
+    if e.consecutive < MAX_CONSECUTIVE_ERRORS && best - e.head <= pool.MaxLag {
+      healthy = append(healthy, e)
+    }
+  }
+  pool.mu.Unlock()
+  return pool.ranked(healthy)
+}
+
+// Order providers by score, lowest (best) first
+func (pool *ProviderPool) ranked(endpoints []*endpoint) ([]*endpoint) {
+  pool.mu.Lock()
+  defer pool.mu.Unlock()
+  ranked := append([]*endpoint{}, endpoints...)
+  sort.SliceStable(ranked, func(i, j int) bool {
+    return ranked[i].score() < ranked[j].score()
+  })
+  return ranked
+}
+
+// Latency, inflated by the share of requests that failed
+func (e *endpoint) score() (float64) {
+  var errorRate = 0.0
+  if e.requests > 0 {
+    errorRate = float64(e.failures) / float64(e.requests)
+  }
+  return float64(e.latency) * (1 + 10*errorRate) * float64(1 + e.consecutive)
+}
+
+/**
+ * Make a call on every healthy provider and return the result a majority of
+ * them agree on. All providers are asked about the same block (the lowest
+ * head among them) so an honest provider that is a block behind still agrees.
+ *
+ * @param ctx     Context bounding the calls
+ * @param call    Call to make
+ * @return        Agreed result, error
+ */
+func (pool *ProviderPool) QuorumCall(ctx context.Context, call Call) (string, error) {
+  participants := pool.healthy()
+  if len(participants) == 0 {
+    return "", fmt.Errorf("No healthy RPC providers")
+  }
+  pool.mu.Lock()
+  var block = participants[0].head
+  for _, e := range participants {
+    if e.head < block { block = e.head }
+  }
+  pool.mu.Unlock()
+  var tag = "latest"
+  if block > 0 {
+    tag = fmt.Sprintf("0x%x", block)
+  }
+
+  type answer struct {
+    result string
+    err error
+  }
+  answers := make(chan answer, len(participants))
+  for _, e := range participants {
+    go func(e *endpoint) {
+      start := time.Now()
+      result, err := e.client.Eth_callAt(ctx, call, tag)
+      if ctx.Err() != nil {
+        // Not the provider's fault
+      } else if failover(err) {
+        pool.record(e, time.Since(start), err)
+      } else {
+        pool.record(e, time.Since(start), nil)
+      }
+      answers <- answer{result, err}
+    }(e)
+  }
+
+  needed := len(participants)/2 + 1
+  votes := map[string]int{}
+  var lastErr error
+  for range participants {
+    a := <-answers
+    if a.err != nil {
+      lastErr = a.err
+      continue
+    }
+    votes[a.result]++
+    if votes[a.result] >= needed {
+      return a.result, nil
+    }
+  }
+  if lastErr != nil {
+    return "", fmt.Errorf("RPC providers did not reach quorum: (%w)", lastErr)
+  }
+  return "", fmt.Errorf("RPC providers did not reach quorum: %v", votes)
+}
@@ -0,0 +1,191 @@
+// Wait for transactions to be mined and confirmed
+package rpc
+
+import (
+  "context"
+  "fmt"
+  "strconv"
+  "time"
+)
+
+// Number of blocks (including the one the tx is in) before a tx is final
+const DEFAULT_CONFIRMATIONS = 2
+
+// How often WaitForReceipt checks the chain
+var ReceiptPollInterval = time.Second*10
+
+// Polls without the node knowing about a tx before it is considered dropped
+const DROPPED_AFTER_POLLS = 6
+
+type TxStatus int
+
+const (
+  TxMined TxStatus = iota + 1   // Mined and succeeded
+  TxReverted                    // Mined but threw
+  TxDropped                     // No longer known to the node
+  TxReplaced                    // Another tx with the same nonce was mined
+)
+
+func (status TxStatus) String() (string) {
+  switch status {
+  case TxMined:
+    return "mined"
+  case TxReverted:
+    return "reverted"
+  case TxDropped:
+    return "dropped"
+  case TxReplaced:
+    return "replaced"
+  }
+  return "unknown"
+}
+
+type TxOutcome struct {
+  Status TxStatus
+  TxHash string
+  Receipt *Receipt          // nil if dropped or replaced
+  Confirmations int
+}
+
+/**
+ * Wait until a transaction is mined and has the requested number of
+ * confirmations, or until it is known to be dropped or replaced.
+ * The receipt is re-read on every poll, so a tx that gets reorged out is
+ * waited on again. Gas price replacements made with Bump are followed, and
+ * the outcome's TxHash is whichever variant was mined.
+ *
+ * @param ctx              Cancels the wait
+ * @param txhash           Hash of the transaction
+ * @param confirmations    Blocks needed (1 = mined in the latest block)
+ * @return                 Outcome of the transaction, error
+ */
+func WaitForReceipt(ctx context.Context, txhash string, confirmations int) (*TxOutcome, error) {
+  var from = ""
+  var nonce uint64
+  var unknown = 0
+  for {
+    outcome, err := checkOutcome(ctx, txhash, confirmations)
+    if err != nil {
+      return nil, err
+    } else if outcome != nil {
+      return outcome, nil
+    }
+
+    // Not mined yet. Remember who sent it so we can tell if its nonce is used.
+    var known = false
+    for _, variant := range Variants(txhash) {
+      tx, err := client.Eth_getTransactionByHash(ctx, variant)
+      if err != nil {
+        return nil, fmt.Errorf("Error getting tx: (%w)", err)
+      }
+      if tx.Hash != "" {
+        known = true
+        from = tx.From
+        nonce, _ = strconv.ParseUint(tx.Nonce, 0, 64)
+        break
+      }
+    }
+    if known {
+      unknown = 0
+    } else {
+      unknown++
+    }
+    if from != "" {
+      replaced, err := nonceUsed(ctx, from, nonce)
+      if err != nil {
+        return nil, err
+      } else if replaced {
+        // One of our variants may have just been mined; check once more
+        outcome, err := checkOutcome(ctx, txhash, 1)
+        if err != nil {
+          return nil, err
+        } else if outcome == nil {
+          return &TxOutcome{Status: TxReplaced, TxHash: txhash}, nil
+        }
+      }
+    }
+    if unknown >= DROPPED_AFTER_POLLS {
+      return &TxOutcome{Status: TxDropped, TxHash: txhash}, nil
+    }
+
+    // Check again on the next block
+    if err := WaitForBlock(ctx, ReceiptPollInterval); err != nil {
+      return nil, err
+    }
+  }
+}
+
+// Return the outcome of a mined tx (or one of its replacements) with enough
+// confirmations, otherwise nil
+func checkOutcome(ctx context.Context, txhash string, confirmations int) (*TxOutcome, error) {
+  var receipt *Receipt
+  for _, variant := range Variants(txhash) {
+    _receipt, err := client.Eth_getTransactionReceipt(ctx, variant)
+    if err != nil {
+      return nil, fmt.Errorf("Error getting tx receipt: (%w)", err)
+    } else if _receipt != nil && _receipt.BlockNumber != "" {
+      receipt = _receipt
+      break
+    }
+  }
+  if receipt == nil {
+    return nil, nil
+  }
+  mined, err := strconv.ParseInt(receipt.BlockNumber, 0, 64)
+  if err != nil {
+    return nil, fmt.Errorf("Could not parse receipt block number: (%s)", err)
+  }
+  head, err := client.Eth_blockNumber(ctx)
+  if err != nil {
+    return nil, fmt.Errorf("Error getting block number: (%w)", err)
+  }
+  confirmed := int(int64(head) - mined + 1)
+  if confirmed < confirmations {
+    return nil, nil
+  }
+  succeeded, err := receiptSucceeded(ctx, receipt)
+  if err != nil {
+    return nil, err
+  }
+  var outcome = TxOutcome{TxHash: receipt.TransactionHash, Receipt: receipt, Confirmations: confirmed}
+  if succeeded {
+    outcome.Status = TxMined
+  } else {
+    outcome.Status = TxReverted
+  }
+  return &outcome, nil
+}
+
+// Check if the account has mined a transaction with this nonce (or higher)
+func nonceUsed(ctx context.Context, addr string, nonce uint64) (bool, error) {
+  _count, err := client.Eth_getTransactionCount(ctx, addr, "latest")
+  if err != nil {
+    return false, fmt.Errorf("Error getting nonce: (%w)", err)
+  }
+  count, err := strconv.ParseUint(_count, 0, 64)
+  if err != nil {
+    return false, fmt.Errorf("Could not parse nonce: (%s)", err)
+  }
+  return count > nonce, nil
+}
+
+/**
+ * Check the status field of a receipt. Receipts from before Byzantium have no
+ * status, so for those fall back to comparing gas used with the gas limit.
+ */
+func receiptSucceeded(ctx context.Context, receipt *Receipt) (bool, error) {
+  if receipt.Status != "" {
+    status, err := strconv.ParseUint(receipt.Status, 0, 64)
+    if err != nil {
+      return false, fmt.Errorf("Could not parse receipt status: (%s)", err)
+    }
+    return status == 1, nil
+  }
+  tx, err := client.Eth_getTransactionByHash(ctx, receipt.TransactionHash)
+  if err != nil {
+    return false, fmt.Errorf("Error getting tx: (%w)", err)
+  }
+  gasSent, _ := strconv.ParseUint(tx.Gas, 0, 64)
+  gasUsed, _ := strconv.ParseUint(receipt.GasUsed, 0, 64)
+  return gasUsed < gasSent, nil
+}
@@ -0,0 +1,115 @@
+// Wait on chain events pushed over a WebSocket, falling back to polling when
+// there is no WebSocket provider
+package rpc
+
+import (
+  "context"
+  "log"
+  "strings"
+  "sync"
+  "time"
+)
+
+// Global WebSocket connection, if any
+var ws *WSClient
+
+// Closed and replaced whenever a new block arrives
+var nextHead = make(chan struct{})
+var nextHeadMu sync.Mutex
+
+/**
+ * Connect to a WebSocket provider and follow new blocks over it. Waits in this
+ * package (receipts, claims, channels) then wake up on every block instead of
+ * sleeping a fixed interval.
+ *
+ * @param provider    ws:// or wss:// URI of the provider
+ * @return            error
+ */
+func ConnectWS(provider string) (error) {
+  _ws, err := DialWS(provider)
+  if err != nil {
+    return err
+  }
+  heads, _, err := _ws.SubscribeNewHeads()
+  if err != nil {
+    _ws.Close()
+    return err
+  }
+  ws = _ws
+  log.Print("Subscribed to new blocks over ", provider)
+  go func() {
+    for range heads {
+      nextHeadMu.Lock()
+      close(nextHead)
+      nextHead = make(chan struct{})
+      nextHeadMu.Unlock()
+    }
+  }()
+  return nil
+}
+
+/**
+ * Wait for the next block. Without a WebSocket this just waits out the
+ * timeout, which is also the longest it will ever wait.
+ *
+ * @param ctx        Cancels the wait
+ * @param timeout    Longest time to wait
+ * @return           ctx.Err() if cancelled, otherwise nil
+ */
+func WaitForBlock(ctx context.Context, timeout time.Duration) (error) {
+  nextHeadMu.Lock()
+  head := nextHead
+  nextHeadMu.Unlock()
+  timer := time.NewTimer(timeout)
+  defer timer.Stop()
+  select {
+  case <-ctx.Done():
+    return ctx.Err()
+  case <-head:
+  case <-timer.C:
+  }
+  return nil
+}
+
+/**
+ * Wait for a log emitted by a contract. Without a WebSocket this waits out the
+ * timeout, so callers should check the state they are waiting for afterwards
+ * either way.
+ *
+ * @param ctx         Cancels the wait
+ * @param contract    Address of the contract
+ * @param topics      Topics to match (nil entries match anything)
+ * @param timeout     Longest time to wait
+ * @return            Log if one arrived, otherwise nil
+ */
+func WaitForLog(ctx context.Context, contract string, topics [][]string, timeout time.Duration) (*Log) {
+  timer := time.NewTimer(timeout)
+  defer timer.Stop()
+  if ws == nil {
+    select {
+    case <-ctx.Done():
+    case <-timer.C:
+    }
+    return nil
+  }
+  filter := FilterQuery{Address: []string{strings.ToLower(contract)}, Topics: topics}
+  logs, sub, err := ws.SubscribeLogs(filter)
+  if err != nil {
+    log.Print("Could not subscribe to logs: ", err)
+    select {
+    case <-ctx.Done():
+    case <-timer.C:
+    }
+    return nil
+  }
+  defer sub.Unsubscribe()
+  select {
+  case <-ctx.Done():
+  case <-timer.C:
+  case _log, ok := <-logs:
+    if ok && !_log.Removed {
+      return &_log
+    }
+  }
+  return nil
+}
@@ -2,13 +2,17 @@
 package rpc
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"math/big"
 	"net/http"
+	"httpclient"
+	"retry"
 	"strconv"
-	"strings"
+	"time"
 )
 
 const (
@@ -32,8 +36,9 @@ func (req *JSONRPCRequest) ToJSON() ([]byte, error) {
 }
 
 type ResponseBase struct {
-	JSONRPC string `json:"jsonrpc"`
-	ID      int64  `json:"id"`
+	JSONRPC string    `json:"jsonrpc"`
+	ID      int64     `json:"id"`
+	Error   *RPCError `json:"error,omitempty"`
 }
 
 type BlockNumberResponse struct {
@@ -67,7 +72,8 @@ type TransactionResponse struct {
 }
 
 type BlockResult struct {
-	Author           string              `json:"author"` // Parity only
+	Author           string              `json:"author"`        // Parity only
+	BaseFeePerGas    string              `json:"baseFeePerGas"` // London and later only
 	Difficulty       string              `json:"difficulty"`
 	ExtraData        string              `json:"extraData"`
 	GasLimit         string              `json:"gasLimit"`
@@ -112,6 +118,12 @@ func (blockResult *BlockResult) ToBlock() (*Block, error) {
 	nonce := new(big.Int)
 	nonce.SetString(blockResult.Nonce, 0)
 
+	var baseFee *big.Int
+	if blockResult.BaseFeePerGas != "" {
+		baseFee = new(big.Int)
+		baseFee.SetString(blockResult.BaseFeePerGas, 0)
+	}
+
 	number, err := strconv.ParseInt(blockResult.Number, 0, 32)
 	if err != nil {
 		return nil, fmt.Errorf("ToBlock Number: %v", err)
@@ -132,6 +144,7 @@ func (blockResult *BlockResult) ToBlock() (*Block, error) {
 
 	block := Block{
 		Author:          blockResult.Author,
+		BaseFeePerGas:   baseFee,
 		Difficulty:      difficulty,
 		ExtraData:       blockResult.ExtraData,
 		GasLimit:        int(gasLimit),
@@ -255,6 +268,7 @@ func (txResult *TransactionResult) ToTransaction() (*Transaction, error) {
 
 type Block struct {
 	Author           string        `json:"author"`
+	BaseFeePerGas    *big.Int      `json:"base_fee_per_gas"` // nil before London
 	Difficulty       int64         `json:"difficulty"`
 	ExtraData        string        `json:"extra_data"`
 	GasLimit         int           `json:"gas_limit"`
@@ -318,43 +332,91 @@ func (tx *Transaction) ToJSON() ([]byte, error) {
 	return s, nil
 }
 
+// Retries requests that failed on every provider, e.g. during a brief outage.
+// Errors the node answered with are never retried.
+var RetryPolicy = retry.Policy{
+	Initial:     time.Second,
+	Max:         5 * time.Second,
+	Multiplier:  2,
+	Jitter:      0.2,
+	MaxAttempts: 3,
+	Retryable:   Retryable,
+}
+
 type EthereumClient struct {
-	URL string
+	URL  string
+	pool *ProviderPool // When set, requests go to the pool's healthiest provider instead of URL
 }
 
 // issueRequest issues the JSON-RPC request
-func (client *EthereumClient) issueRequest(reqBody *JSONRPCRequest) ([]byte, error) {
+func (client *EthereumClient) issueRequest(ctx context.Context, reqBody *JSONRPCRequest) ([]byte, error) {
 
 	payload, err := reqBody.ToJSON()
 	if err != nil {
 		return nil, err
 	}
+	return client.send(ctx, payload)
+}
+
+// send delivers a JSON-RPC payload (a single request or a batch), retrying
+// according to RetryPolicy. Resending is safe: reads have no side effects and
+// a resent transaction is reported as already known.
+func (client *EthereumClient) send(ctx context.Context, payload []byte) ([]byte, error) {
+	var body []byte
+	err := RetryPolicy.Do(ctx, func(ctx context.Context) error {
+		var err error
+		if client.pool != nil {
+			body, err = client.pool.send(ctx, payload)
+		} else {
+			body, err = client.post(ctx, payload)
+		}
+		return err
+	})
+	return body, err
+}
+
+// post sends a JSON-RPC payload to the client's URL
+func (client *EthereumClient) post(ctx context.Context, payload []byte) ([]byte, error) {
 
-	reader := strings.NewReader(string(payload))
-	resp, err := http.Post(client.URL, JSON_MEDIA_TYPE, reader)
+	req, err := http.NewRequestWithContext(ctx, "POST", client.URL, bytes.NewReader(payload))
 	if err != nil {
 		return nil, err
 	}
+	req.Header.Set("Content-Type", JSON_MEDIA_TYPE)
+	resp, err := httpclient.Client().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
 
 	body, err := ioutil.ReadAll(resp.Body)
-	defer resp.Body.Close()
 	if err != nil {
 		return nil, err
 	}
+
+	// Some providers answer JSON-RPC errors with a non-200 status, so look for
+	// an error object before falling back to the HTTP status
+	var base ResponseBase
+	if json.Unmarshal(body, &base) == nil && base.Error != nil {
+		return nil, base.Error
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, &HTTPError{StatusCode: resp.StatusCode, Status: resp.Status, Body: body}
+	}
 	return body, nil
 }
 
 // Eth_newBlockFilter calls the eth_newBlockFilter JSON-RPC method
-func (client *EthereumClient) Eth_newBlockFilter() (string, error) {
+func (client *EthereumClient) Eth_newBlockFilter(ctx context.Context) (string, error) {
 
 	reqBody := JSONRPCRequest{
 		JSONRPC: "2.0",
-		ID:      1,
+		ID:      nextID(),
 		Method:  "eth_newBlockFilter",
 		Params:  nil,
 	}
 
-	body, err := client.issueRequest(&reqBody)
+	body, err := client.issueRequest(ctx, &reqBody)
 	if err != nil {
 		return "", err
 	}
@@ -369,16 +431,16 @@ func (client *EthereumClient) Eth_newBlockFilter() (string, error) {
 }
 
 // Eth_newPendingTransactionFilter calls the eth_newPendingTransactionFilter JSON-RPC method
-func (client *EthereumClient) Eth_newPendingTransactionFilter() (string, error) {
+func (client *EthereumClient) Eth_newPendingTransactionFilter(ctx context.Context) (string, error) {
 
 	reqBody := JSONRPCRequest{
 		JSONRPC: "2.0",
-		ID:      1,
+		ID:      nextID(),
 		Method:  "eth_newPendingTransactionFilter",
 		Params:  nil,
 	}
 
-	body, err := client.issueRequest(&reqBody)
+	body, err := client.issueRequest(ctx, &reqBody)
 	if err != nil {
 		return "", err
 	}
@@ -393,16 +455,16 @@ func (client *EthereumClient) Eth_newPendingTransactionFilter() (string, error)
 }
 
 // Eth_getFilterChanges calls the eth_getFilterChanges JSON-RPC method
-func (client *EthereumClient) Eth_getFilterChanges(filterID string) ([]string, error) {
+func (client *EthereumClient) Eth_getFilterChanges(ctx context.Context, filterID string) ([]string, error) {
 
 	reqBody := JSONRPCRequest{
 		JSONRPC: "2.0",
-		ID:      1,
+		ID:      nextID(),
 		Method:  "eth_getFilterChanges",
 		Params:  []interface{}{filterID},
 	}
 
-	body, err := client.issueRequest(&reqBody)
+	body, err := client.issueRequest(ctx, &reqBody)
 	if err != nil {
 		return nil, err
 	}
@@ -416,17 +478,115 @@ func (client *EthereumClient) Eth_getFilterChanges(filterID string) ([]string, e
 	return clientResp.Result, nil
 }
 
+type LogsResponse struct {
+	ResponseBase
+	Result []Log `json:"result"`
+}
+
+type UninstallFilterResponse struct {
+	ResponseBase
+	Result bool `json:"result"`
+}
+
+// Eth_newFilter calls the eth_newFilter JSON-RPC method. Poll the filter with
+// Eth_getFilterLogChanges.
+func (client *EthereumClient) Eth_newFilter(ctx context.Context, filter FilterQuery) (string, error) {
+
+	reqBody := JSONRPCRequest{
+		JSONRPC: "2.0",
+		ID:      nextID(),
+		Method:  "eth_newFilter",
+		Params:  []interface{}{filter},
+	}
+
+	body, err := client.issueRequest(ctx, &reqBody)
+	if err != nil {
+		return "", err
+	}
+
+	var clientResp NewFilterResponse
+	err = json.Unmarshal(body, &clientResp)
+	if err != nil {
+		return "", err
+	}
+
+	return clientResp.Result, nil
+}
+
+// Eth_getFilterLogChanges calls eth_getFilterChanges on a log filter, which
+// returns logs rather than hashes
+func (client *EthereumClient) Eth_getFilterLogChanges(ctx context.Context, filterID string) ([]Log, error) {
+	return client.getLogs(ctx, "eth_getFilterChanges", filterID)
+}
+
+// Eth_getFilterLogs calls the eth_getFilterLogs JSON-RPC method
+func (client *EthereumClient) Eth_getFilterLogs(ctx context.Context, filterID string) ([]Log, error) {
+	return client.getLogs(ctx, "eth_getFilterLogs", filterID)
+}
+
+// Eth_getLogs calls the eth_getLogs JSON-RPC method
+func (client *EthereumClient) Eth_getLogs(ctx context.Context, filter FilterQuery) ([]Log, error) {
+	return client.getLogs(ctx, "eth_getLogs", filter)
+}
+
+func (client *EthereumClient) getLogs(ctx context.Context, method string, param interface{}) ([]Log, error) {
+
+	reqBody := JSONRPCRequest{
+		JSONRPC: "2.0",
+		ID:      nextID(),
+		Method:  method,
+		Params:  []interface{}{param},
+	}
+
+	body, err := client.issueRequest(ctx, &reqBody)
+	if err != nil {
+		return nil, err
+	}
+
+	var clientResp LogsResponse
+	err = json.Unmarshal(body, &clientResp)
+	if err != nil {
+		return nil, err
+	}
+
+	return clientResp.Result, nil
+}
+
+// Eth_uninstallFilter calls the eth_uninstallFilter JSON-RPC method
+func (client *EthereumClient) Eth_uninstallFilter(ctx context.Context, filterID string) (bool, error) {
+
+	reqBody := JSONRPCRequest{
+		JSONRPC: "2.0",
+		ID:      nextID(),
+		Method:  "eth_uninstallFilter",
+		Params:  []interface{}{filterID},
+	}
+
+	body, err := client.issueRequest(ctx, &reqBody)
+	if err != nil {
+		return false, err
+	}
+
+	var clientResp UninstallFilterResponse
+	err = json.Unmarshal(body, &clientResp)
+	if err != nil {
+		return false, err
+	}
+
+	return clientResp.Result, nil
+}
+
 // Eth_getBlockByHash calls the eth_getBlockByHash JSON-RPC method
-func (client *EthereumClient) Eth_getBlockByHash(blockHash string, full bool) (*Block, error) {
+func (client *EthereumClient) Eth_getBlockByHash(ctx context.Context, blockHash string, full bool) (*Block, error) {
 
 	reqBody := JSONRPCRequest{
 		JSONRPC: "2.0",
-		ID:      1,
+		ID:      nextID(),
 		Method:  "eth_getBlockByHash",
 		Params:  []interface{}{blockHash, full},
 	}
 
-	body, err := client.issueRequest(&reqBody)
+	body, err := client.issueRequest(ctx, &reqBody)
 	if err != nil {
 		return nil, err
 	}
@@ -446,17 +606,17 @@ func (client *EthereumClient) Eth_getBlockByHash(blockHash string, full bool) (*
 }
 
 // Eth_getTransactionByHash calls the eth_getTransactionByHash JSON-RPC method
-func (client *EthereumClient) Eth_getTransactionByHash(txHash string) (TransactionResult, error) {
+func (client *EthereumClient) Eth_getTransactionByHash(ctx context.Context, txHash string) (TransactionResult, error) {
 	var emptyResp = TransactionResult{}
 
 	reqBody := JSONRPCRequest{
 		JSONRPC: "2.0",
-		ID:      1,
+		ID:      nextID(),
 		Method:  "eth_getTransactionByHash",
 		Params:  []interface{}{txHash},
 	}
 
-	body, err := client.issueRequest(&reqBody)
+	body, err := client.issueRequest(ctx, &reqBody)
 	if err != nil {
 		return emptyResp, err
 	}
@@ -471,18 +631,18 @@ func (client *EthereumClient) Eth_getTransactionByHash(txHash string) (Transacti
 }
 
 // Eth_getBlockByNumber calls the eth_getBlockByNumber JSON-RPC method
-func (client *EthereumClient) Eth_getBlockByNumber(blockNumber int, full bool) (*Block, error) {
+func (client *EthereumClient) Eth_getBlockByNumber(ctx context.Context, blockNumber int, full bool) (*Block, error) {
 
 	blockNumberHex := "0x" + strconv.FormatInt(int64(blockNumber), 16)
 
 	reqBody := JSONRPCRequest{
 		JSONRPC: "2.0",
-		ID:      1,
+		ID:      nextID(),
 		Method:  "eth_getBlockByNumber",
 		Params:  []interface{}{blockNumberHex, full},
 	}
 
-	body, err := client.issueRequest(&reqBody)
+	body, err := client.issueRequest(ctx, &reqBody)
 	if err != nil {
 		return nil, err
 	}
@@ -502,16 +662,16 @@ func (client *EthereumClient) Eth_getBlockByNumber(blockNumber int, full bool) (
 }
 
 // Eth_blockNumber calls the eth_blockNumber JSON-RPC method
-func (client *EthereumClient) Eth_blockNumber() (int, error) {
+func (client *EthereumClient) Eth_blockNumber(ctx context.Context) (int, error) {
 
 	reqBody := JSONRPCRequest{
 		JSONRPC: "2.0",
-		ID:      1,
+		ID:      nextID(),
 		Method:  "eth_blockNumber",
 		Params:  []interface{}{},
 	}
 
-	body, err := client.issueRequest(&reqBody)
+	body, err := client.issueRequest(ctx, &reqBody)
 	if err != nil {
 		return 0, err
 	}
@@ -531,16 +691,16 @@ func (client *EthereumClient) Eth_blockNumber() (int, error) {
 }
 
 // Get the network version (a.k.a. chainId)
-func (client *EthereumClient) NetVersion() (int64, error) {
+func (client *EthereumClient) NetVersion(ctx context.Context) (int64, error) {
 
 	reqBody := JSONRPCRequest{
 		JSONRPC: "2.0",
-		ID:      1,
+		ID:      nextID(),
 		Method:  "net_version",
 		Params:  []interface{}{},
 	}
 
-	body, err := client.issueRequest(&reqBody)
+	body, err := client.issueRequest(ctx, &reqBody)
 	if err != nil {
 		return 0, err
 	}
@@ -560,20 +720,62 @@ func (client *EthereumClient) NetVersion() (int64, error) {
 
 // Use this to make calls to a contract
 type Call struct {
-	From string `json:"from"`
-	To string `json:"to"`
-	Data string `json:"data"`
+	From  string `json:"from"`
+	To    string `json:"to"`
+	Data  string `json:"data"`
+	Value string `json:"value,omitempty"` // 0x-prefixed wei
 }
 
 // Make a call to the blockchain
-func (client *EthereumClient) Eth_call(_call Call) (string, error) {
+func (client *EthereumClient) Eth_call(ctx context.Context, _call Call) (string, error) {
 	reqBody := JSONRPCRequest{
 		JSONRPC: "2.0",
-		ID:      1,
+		ID:      nextID(),
 		Method:  "eth_call",
 		Params:  []interface{}{_call},
 	}
-	res, err := client.issueRequest(&reqBody)
+	res, err := client.issueRequest(ctx, &reqBody)
+	if err != nil {
+		return "", err
+	}
+	var clientResp CallResponse
+	err = json.Unmarshal(res, &clientResp)
+	if err != nil {
+		return "", err
+	}
+	return clientResp.Result, nil
+}
+
+// Eth_callAt makes a call against the state at a specific block number or tag
+func (client *EthereumClient) Eth_callAt(ctx context.Context, _call Call, block string) (string, error) {
+	reqBody := JSONRPCRequest{
+		JSONRPC: "2.0",
+		ID:      nextID(),
+		Method:  "eth_call",
+		Params:  []interface{}{_call, block},
+	}
+	res, err := client.issueRequest(ctx, &reqBody)
+	if err != nil {
+		return "", err
+	}
+	var clientResp CallResponse
+	err = json.Unmarshal(res, &clientResp)
+	if err != nil {
+		return "", err
+	}
+	return clientResp.Result, nil
+}
+
+// Eth_estimateGas calls the eth_estimateGas JSON-RPC method. A call that
+// would revert returns an error instead of an estimate.
+func (client *EthereumClient) Eth_estimateGas(ctx context.Context, _call Call) (string, error) {
+	reqBody := JSONRPCRequest{
+		JSONRPC: "2.0",
+		ID:      nextID(),
+		Method:  "eth_estimateGas",
+		Params:  []interface{}{_call},
+	}
+	res, err := client.issueRequest(ctx, &reqBody)
 	if err != nil {
 		return "", err
 	}
@@ -585,16 +787,17 @@ func (client *EthereumClient) Eth_call(_call Call) (string, error) {
 	return clientResp.Result, nil
 }
 
-// Get the transaction count (nonce) for an account, which must be 0x prefixed
-func (client *EthereumClient) Eth_getTransactionCount(addr string) (string, error) {
+// Get the transaction count (nonce) for an account, which must be 0x prefixed.
+// block is a block number or tag; "pending" includes transactions in the mempool.
+func (client *EthereumClient) Eth_getTransactionCount(ctx context.Context, addr string, block string) (string, error) {
 	reqBody := JSONRPCRequest{
 		JSONRPC: "2.0",
-		ID:      1,
+		ID:      nextID(),
 		Method:  "eth_getTransactionCount",
-		Params:  []interface{}{addr, "latest"},
+		Params:  []interface{}{addr, block},
 	}
 
-	res, err := client.issueRequest(&reqBody)
+	res, err := client.issueRequest(ctx, &reqBody)
 	if err != nil {
 		return "", err
 	}
@@ -612,14 +815,14 @@ type TxResponse struct {
 	Result string `json:"result"`
 }
 
-func (client *EthereumClient) Eth_sendRawTransaction(data string) (string, error) {
+func (client *EthereumClient) Eth_sendRawTransaction(ctx context.Context, data string) (string, error) {
 	reqBody := JSONRPCRequest{
 		JSONRPC: "2.0",
-		ID:      1,
+		ID:      nextID(),
 		Method:  "eth_sendRawTransaction",
 		Params:  []interface{}{data},
 	}
-	res2, err := client.issueRequest(&reqBody)
+	res2, err := client.issueRequest(ctx, &reqBody)
 	if err != nil {
 		return "", err
 	}
@@ -633,51 +836,115 @@ func (client *EthereumClient) Eth_sendRawTransaction(data string) (string, error
 }
 
 type Receipt struct {
-	TransactionHash string `json:"transactionHash"`
-	TransactionIndex string `json:"transactionIndex"`
-	BlockNumber string `json:"blockNumber"`
-	BlockHash string `json:"blockHash"`
-	CumulativeGasUsed string `json:"cumulativeGasUsed"`
-	ContractAddress string `json:"contractAddress"`
+	TransactionHash   string  `json:"transactionHash"`
+	TransactionIndex  string  `json:"transactionIndex"`
+	BlockNumber       string  `json:"blockNumber"`
+	BlockHash         string  `json:"blockHash"`
+	From              string  `json:"from"`
+	To                *string `json:"to"` // null when creating contract
+	CumulativeGasUsed string  `json:"cumulativeGasUsed"`
+	GasUsed           string  `json:"gasUsed"`
+	EffectiveGasPrice string  `json:"effectiveGasPrice"` // absent before London
+	ContractAddress   *string `json:"contractAddress"`
+	Logs              []Log   `json:"logs"`
+	LogsBloom         string  `json:"logsBloom"`
+	Root              string  `json:"root"`   // pre-Byzantium only
+	Status            string  `json:"status"` // post-Byzantium only; 0x1 success, 0x0 failure
+}
+
+type Log struct {
+	Address          string   `json:"address"`
+	Topics           []string `json:"topics"`
+	Data             string   `json:"data"`
+	BlockNumber      string   `json:"blockNumber"`
+	BlockHash        string   `json:"blockHash"`
+	TransactionHash  string   `json:"transactionHash"`
+	TransactionIndex string   `json:"transactionIndex"`
+	LogIndex         string   `json:"logIndex"`
+	Removed          bool     `json:"removed"`
+}
+
+type FeeHistory struct {
+	OldestBlock   string     `json:"oldestBlock"`
+	BaseFeePerGas []string   `json:"baseFeePerGas"` // Includes the block after the newest
+	GasUsedRatio  []float64  `json:"gasUsedRatio"`
+	Reward        [][]string `json:"reward"`
+}
+
+type FeeHistoryResponse struct {
+	ResponseBase
+	Result FeeHistory `json:"result"`
+}
+
+// Eth_feeHistory calls the eth_feeHistory JSON-RPC method. rewardPercentiles
+// selects which priority fees of each block are returned in Reward.
+func (client *EthereumClient) Eth_feeHistory(ctx context.Context, blockCount int, newestBlock string, rewardPercentiles []float64) (*FeeHistory, error) {
+	reqBody := JSONRPCRequest{
+		JSONRPC: "2.0",
+		ID:      nextID(),
+		Method:  "eth_feeHistory",
+		Params:  []interface{}{"0x" + strconv.FormatInt(int64(blockCount), 16), newestBlock, rewardPercentiles},
+	}
+	res, err := client.issueRequest(ctx, &reqBody)
+	if err != nil {
+		return nil, err
+	}
+
+	var clientResp FeeHistoryResponse
+	err = json.Unmarshal(res, &clientResp)
+	if err != nil {
+		return nil, err
+	}
+
+	return &clientResp.Result, nil
+}
+
+// FilterQuery selects logs by contract address and topics. A nil entry in
+// Topics matches any topic in that position.
+type FilterQuery struct {
+	FromBlock string     `json:"fromBlock,omitempty"`
+	ToBlock   string     `json:"toBlock,omitempty"`
+	BlockHash string     `json:"blockHash,omitempty"`
+	Address   []string   `json:"address,omitempty"`
+	Topics    [][]string `json:"topics,omitempty"`
 }
 
 type ReceiptResponse struct {
 	ResponseBase
-	Result Receipt
+	Result *Receipt `json:"result"`
 }
 
-// Get the cumulative gas used from a receipt given a txhash
-// This is a good way to check if your transaction threw.
-// If cumulativeGasUsed = max gas allowed, your contract probably threw
-func (client *EthereumClient) Eth_gasUsed(txhash string) (string, error) {
+// Eth_getTransactionReceipt calls the eth_getTransactionReceipt JSON-RPC method.
+// The receipt is nil while the transaction is pending.
+func (client *EthereumClient) Eth_getTransactionReceipt(ctx context.Context, txhash string) (*Receipt, error) {
 	reqBody := JSONRPCRequest{
 		JSONRPC: "2.0",
-		ID:      1,
+		ID:      nextID(),
 		Method:  "eth_getTransactionReceipt",
 		Params:  []interface{}{txhash},
 	}
-	res2, err := client.issueRequest(&reqBody)
+	res2, err := client.issueRequest(ctx, &reqBody)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
 	var clientResp ReceiptResponse
 	err = json.Unmarshal(res2, &clientResp)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	return clientResp.Result.CumulativeGasUsed, nil
+	return clientResp.Result, nil
 }
 
-func (client *EthereumClient) Eth_balance(addr string) (string, error) {
+func (client *EthereumClient) Eth_balance(ctx context.Context, addr string) (string, error) {
 	reqBody := JSONRPCRequest{
 		JSONRPC: "2.0",
-		ID:      1,
+		ID:      nextID(),
 		Method:  "eth_getBalance",
 		Params:  []interface{}{addr, "latest"},
 	}
-	res2, err := client.issueRequest(&reqBody)
+	res2, err := client.issueRequest(ctx, &reqBody)
 	if err != nil {
 		return "", err
 	}
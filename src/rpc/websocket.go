@@ -0,0 +1,414 @@
+// WebSocket transport for eth_subscribe notifications
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"retry"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Delay between reconnect attempts; doubles (with jitter) up to WS_MAX_RECONNECT_DELAY
+const WS_RECONNECT_DELAY = time.Second
+const WS_MAX_RECONNECT_DELAY = time.Minute
+
+var wsReconnectPolicy = retry.Policy{
+	Initial:    WS_RECONNECT_DELAY,
+	Max:        WS_MAX_RECONNECT_DELAY,
+	Multiplier: 2,
+	Jitter:     0.2,
+}
+
+// A connection that hasn't heard anything (not even a pong) for this long is
+// considered dead and is reconnected
+const WS_READ_TIMEOUT = time.Second * 90
+const WS_PING_INTERVAL = time.Second * 30
+
+// Header is the block header delivered by a newHeads subscription
+type Header struct {
+	Number        string `json:"number"`
+	Hash          string `json:"hash"`
+	ParentHash    string `json:"parentHash"`
+	Timestamp     string `json:"timestamp"`
+	BaseFeePerGas string `json:"baseFeePerGas"` // London and later only
+}
+
+// Subscription delivers eth_subscribe notifications. It survives reconnects:
+// the WSClient subscribes again under a new id.
+type Subscription struct {
+	client *WSClient
+	params []interface{}
+	id     string // Server-side id for the current connection
+	ch     chan json.RawMessage
+	done   chan struct{}
+}
+
+// Notifications returns the raw result of every notification
+func (sub *Subscription) Notifications() <-chan json.RawMessage {
+	return sub.ch
+}
+
+// Done is closed once the subscription has stopped
+func (sub *Subscription) Done() <-chan struct{} {
+	return sub.done
+}
+
+// Unsubscribe stops the subscription
+func (sub *Subscription) Unsubscribe() {
+	sub.client.unsubscribe(sub)
+}
+
+type wsMessage struct {
+	ID     *int64          `json:"id"`
+	Method string          `json:"method"`
+	Result json.RawMessage `json:"result"`
+	Error  *RPCError       `json:"error"`
+	Params struct {
+		Subscription string          `json:"subscription"`
+		Result       json.RawMessage `json:"result"`
+	} `json:"params"`
+}
+
+// WSClient is a JSON-RPC client over a WebSocket that reconnects and
+// resubscribes on its own
+type WSClient struct {
+	URL     string
+	mu      sync.Mutex
+	writeMu sync.Mutex
+	conn    *websocket.Conn
+	pending map[int64]*wsCall
+	subs    map[*Subscription]bool
+	closed  bool
+}
+
+// A request waiting for its response
+type wsCall struct {
+	resp chan wsMessage
+	sub  *Subscription // For eth_subscribe: registered by the read loop as soon as the reply arrives
+}
+
+// DialWS connects to a WebSocket JSON-RPC endpoint (ws:// or wss://)
+func DialWS(url string) (*WSClient, error) {
+	ws := WSClient{URL: url, pending: map[int64]*wsCall{}, subs: map[*Subscription]bool{}}
+	conn, err := ws.dial()
+	if err != nil {
+		return nil, err
+	}
+	go ws.run(conn)
+	return &ws, nil
+}
+
+// Close shuts the connection down for good
+func (ws *WSClient) Close() {
+	ws.mu.Lock()
+	ws.closed = true
+	conn := ws.conn
+	subs := ws.subs
+	ws.subs = map[*Subscription]bool{}
+	ws.mu.Unlock()
+	for sub := range subs {
+		close(sub.done)
+	}
+	if conn != nil {
+		conn.Close()
+	}
+}
+
+// Call makes a JSON-RPC request over the WebSocket and decodes its result
+func (ws *WSClient) Call(result interface{}, method string, params ...interface{}) error {
+	return ws.call(nil, result, method, params...)
+}
+
+func (ws *WSClient) call(sub *Subscription, result interface{}, method string, params ...interface{}) error {
+	if params == nil {
+		params = []interface{}{}
+	}
+	id := nextID()
+	resp := make(chan wsMessage, 1)
+	ws.mu.Lock()
+	conn := ws.conn
+	ws.pending[id] = &wsCall{resp: resp, sub: sub}
+	ws.mu.Unlock()
+	defer func() {
+		ws.mu.Lock()
+		delete(ws.pending, id)
+		ws.mu.Unlock()
+	}()
+	if conn == nil {
+		return fmt.Errorf("WebSocket %s is not connected", ws.URL)
+	}
+
+	err := ws.write(conn, JSONRPCRequest{JSONRPC: "2.0", ID: id, Method: method, Params: params})
+	if err != nil {
+		return err
+	}
+	select {
+	case msg := <-resp:
+		if msg.Error != nil {
+			return msg.Error
+		}
+		if result != nil {
+			return json.Unmarshal(msg.Result, result)
+		}
+		return nil
+	case <-time.After(WS_READ_TIMEOUT):
+		return fmt.Errorf("Timed out waiting for %s over WebSocket", method)
+	}
+}
+
+// Subscribe calls eth_subscribe, e.g. Subscribe("newHeads") or
+// Subscribe("logs", filter)
+func (ws *WSClient) Subscribe(params ...interface{}) (*Subscription, error) {
+	sub := Subscription{client: ws, params: params, ch: make(chan json.RawMessage, 64), done: make(chan struct{})}
+	if err := ws.call(&sub, nil, "eth_subscribe", params...); err != nil {
+		// The reply may have arrived after the timeout
+		ws.mu.Lock()
+		delete(ws.subs, &sub)
+		ws.mu.Unlock()
+		return nil, err
+	}
+	return &sub, nil
+}
+
+// SubscribeNewHeads delivers the header of every new block
+func (ws *WSClient) SubscribeNewHeads() (<-chan Header, *Subscription, error) {
+	sub, err := ws.Subscribe("newHeads")
+	if err != nil {
+		return nil, nil, err
+	}
+	heads := make(chan Header, 16)
+	go func() {
+		defer close(heads)
+		for {
+			select {
+			case raw := <-sub.ch:
+				var head Header
+				if err := json.Unmarshal(raw, &head); err != nil {
+					log.Print("Could not decode new head: ", err)
+					continue
+				}
+				select {
+				case heads <- head:
+				case <-sub.done:
+					return
+				}
+			case <-sub.done:
+				return
+			}
+		}
+	}()
+	return heads, sub, nil
+}
+
+// SubscribeLogs delivers every log matching the filter as it is mined
+func (ws *WSClient) SubscribeLogs(filter FilterQuery) (<-chan Log, *Subscription, error) {
+	sub, err := ws.Subscribe("logs", filter)
+	if err != nil {
+		return nil, nil, err
+	}
+	logs := make(chan Log, 64)
+	go func() {
+		defer close(logs)
+		for {
+			select {
+			case raw := <-sub.ch:
+				var _log Log
+				if err := json.Unmarshal(raw, &_log); err != nil {
+					log.Print("Could not decode log: ", err)
+					continue
+				}
+				select {
+				case logs <- _log:
+				case <-sub.done:
+					return
+				}
+			case <-sub.done:
+				return
+			}
+		}
+	}()
+	return logs, sub, nil
+}
+
+func (ws *WSClient) unsubscribe(sub *Subscription) {
+	ws.mu.Lock()
+	active := ws.subs[sub]
+	delete(ws.subs, sub)
+	ws.mu.Unlock()
+	if !active {
+		return
+	}
+	close(sub.done)
+	if err := ws.Call(nil, "eth_unsubscribe", sub.id); err != nil {
+		log.Printf("Could not unsubscribe %s: %s", sub.id, err)
+	}
+}
+
+func (ws *WSClient) dial() (*websocket.Conn, error) {
+	conn, _, err := websocket.DefaultDialer.Dial(ws.URL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Could not connect to %s: (%s)", ws.URL, err)
+	}
+	conn.SetReadDeadline(time.Now().Add(WS_READ_TIMEOUT))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(WS_READ_TIMEOUT))
+	})
+	ws.mu.Lock()
+	ws.conn = conn
+	ws.mu.Unlock()
+	return conn, nil
+}
+
+func (ws *WSClient) write(conn *websocket.Conn, v interface{}) error {
+	ws.writeMu.Lock()
+	defer ws.writeMu.Unlock()
+	return conn.WriteJSON(v)
+}
+
+// Read messages until the connection fails, then reconnect and resubscribe
+func (ws *WSClient) run(conn *websocket.Conn) {
+	for {
+		stop := make(chan struct{})
+		go ws.ping(conn, stop)
+		err := ws.read(conn)
+		close(stop)
+		conn.Close()
+
+		ws.mu.Lock()
+		ws.conn = nil
+		closed := ws.closed
+		ws.mu.Unlock()
+		if closed {
+			return
+		}
+		log.Printf("WebSocket %s disconnected (%s). Reconnecting...", ws.URL, err)
+		conn = ws.reconnect()
+		if conn == nil {
+			return
+		}
+	}
+}
+
+func (ws *WSClient) read(conn *websocket.Conn) error {
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+		conn.SetReadDeadline(time.Now().Add(WS_READ_TIMEOUT))
+		var msg wsMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			log.Print("Could not decode WebSocket message: ", err)
+			continue
+		}
+		if msg.ID != nil {
+			ws.mu.Lock()
+			call, ok := ws.pending[*msg.ID]
+			if ok && call.sub != nil && msg.Error == nil && !stopped(call.sub) {
+				// Register before reading on, so no notification is missed
+				var id string
+				if err := json.Unmarshal(msg.Result, &id); err == nil {
+					call.sub.id = id
+					ws.subs[call.sub] = true
+				}
+			}
+			ws.mu.Unlock()
+			if ok {
+				call.resp <- msg
+			}
+		} else if msg.Method == "eth_subscription" {
+			ws.deliver(msg.Params.Subscription, msg.Params.Result)
+		}
+	}
+}
+
+// Check if a subscription was unsubscribed, e.g. while resubscribing
+func stopped(sub *Subscription) bool {
+	select {
+	case <-sub.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (ws *WSClient) deliver(id string, result json.RawMessage) {
+	ws.mu.Lock()
+	var target *Subscription
+	for sub := range ws.subs {
+		if sub.id == id {
+			target = sub
+		}
+	}
+	ws.mu.Unlock()
+	if target == nil {
+		return
+	}
+	// Never block the read loop: every call and subscription waits on it
+	select {
+	case target.ch <- result:
+	case <-target.done:
+	default:
+		log.Printf("Subscriber to %v is not keeping up; dropped a notification", target.params)
+	}
+}
+
+func (ws *WSClient) ping(conn *websocket.Conn, stop chan struct{}) {
+	ticker := time.NewTicker(WS_PING_INTERVAL)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			ws.writeMu.Lock()
+			err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(WS_PING_INTERVAL))
+			ws.writeMu.Unlock()
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+// Reconnect with back-off, then subscribe everything again under new ids
+func (ws *WSClient) reconnect() *websocket.Conn {
+	backoff := retry.Backoff{Policy: wsReconnectPolicy}
+	for {
+		ws.mu.Lock()
+		closed := ws.closed
+		ws.mu.Unlock()
+		if closed {
+			return nil
+		}
+		conn, err := ws.dial()
+		if err == nil {
+			// Subscriptions are answered by the read loop, so start it first
+			go ws.resubscribe()
+			return conn
+		}
+		log.Print(err)
+		backoff.Wait(context.Background())
+	}
+}
+
+func (ws *WSClient) resubscribe() {
+	ws.mu.Lock()
+	var subs []*Subscription
+	for sub := range ws.subs {
+		subs = append(subs, sub)
+	}
+	ws.mu.Unlock()
+	for _, sub := range subs {
+		// The read loop sets the new id
+		if err := ws.call(sub, nil, "eth_subscribe", sub.params...); err != nil {
+			log.Printf("Could not resubscribe to %v: %s", sub.params, err)
+		}
+	}
+	log.Printf("WebSocket %s reconnected; resubscribed %d subscriptions", ws.URL, len(subs))
+}
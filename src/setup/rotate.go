@@ -0,0 +1,252 @@
+package setup
+
+import (
+  "api"
+  "config"
+  "context"
+  "contracts"
+  "errors"
+  "fmt"
+  "keystore"
+  "log"
+  "math/big"
+  "rpc"
+  "sig"
+  "units"
+)
+
+/**
+ * Replace the wallet key, e.g. when it may be compromised. A new key is
+ * generated from a new mnemonic and registered in the Registry by the old
+ * wallet. Bills are paid out of the open channel, the old wallet's BOLT and
+ * ether are sent to the new one, and the old keystore is archived.
+ *
+ * While the channel is open, the rest of its deposit is owed to the old
+ * wallet. The rotation then stops before moving the ether (needed to move the
+ * refund) and archiving the key, and must be run again once the hub has
+ * closed the channel. Every step checks whether it already happened, so an
+ * interrupted rotation also picks up where it stopped.
+ */
+func RotateKey() {
+  f := open_log()
+  defer f.Close()
+
+  conf := config.Load()
+  if conf.SignerMode == config.SIGNER_REMOTE {
+    log.Panic("The wallet key is held by a remote signer. Rotate it there.")
+  }
+  old := conf.WalletSigner
+  log.Println("Rotating wallet key", old.Address())
+  fmt.Printf("%s Rotating wallet key \x1b[33m%s\x1b[0m\n", DateStr(), old.Address())
+  connect(conf)
+
+  hub_api := api.NewClient(conf.API)
+  hub_api.PersonalSign = conf.PersonalSign
+  tokens := api.NewLoginSource(hub_api, old)
+  hub_api.Tokens = tokens
+  var registry_addr, bolt_addr, hub_addr, channels_addr string
+  retry_forever("Fetching routing addresses", func(ctx context.Context) (error) {
+    var err error
+    if registry_addr, err = hub_api.Registry(ctx); err != nil {
+      return err
+    }
+    if bolt_addr, err = hub_api.BOLT(ctx); err != nil {
+      return err
+    }
+    if hub_addr, err = hub_api.HubAddr(ctx); err != nil {
+      return err
+    }
+    if channels_addr, err = hub_api.ChannelsAddr(ctx); err != nil {
+      return err
+    }
+    if registry_addr == "" || bolt_addr == "" || hub_addr == "" || channels_addr == "" {
+      return errors.New("Hub returned an empty address")
+    }
+    return nil
+  })
+  registry := contracts.NewRegistry(registry_addr)
+  token := contracts.NewERC20(bolt_addr)
+  manager := contracts.NewChannelManager(channels_addr)
+
+  // 1. Generate the new key. Its mnemonic is shown before any funds move.
+  passphrase, err := keystore.Passphrase(conf.WalletPassphraseFile, true)
+  if err != nil {
+    log.Panic("Could not get passphrase for the new keystore: ", err)
+  }
+  mnemonic, wallet, err := config.NewPendingWallet(conf.WalletKeyPath, passphrase, conf.LightKDF, conf.DerivationPath)
+  if err != nil {
+    log.Panic("Could not create new wallet key: ", err)
+  }
+  if mnemonic != "" {
+    config.ShowMnemonic(mnemonic, conf.DerivationPath)
+  } else {
+    fmt.Printf("%s Resuming rotation to \x1b[32m%s\x1b[0m\n", DateStr(), wallet)
+  }
+  log.Println("New wallet", wallet)
+
+  // 2. Pay what the old wallet owes, so the hub can close its channel
+  refund := settle_channel(hub_api, tokens, old, conf.HashedSerialNo, manager, token, hub_addr)
+
+  // 3. Register the new wallet, signed by the old one
+  registered, err := registry.CheckRegistry(old.Address(), conf.HashedSerialNo, wallet)
+  if err != nil {
+    log.Panic("Could not check registry: ", err)
+  }
+  if !registered {
+    fmt.Printf("%s Registering new wallet...\n", DateStr())
+    opts := &contracts.TransactOpts{Signer: old, API: conf.API}
+    send_and_wait("Registering new wallet", old, func() (string, error) {
+      return registry.SetWallet(opts, wallet, conf.HashedSerialNo)
+    })
+  }
+  fmt.Printf("%s New wallet registered.\n", DateStr())
+
+  // 4. Move the tokens, then the ether that paid for the transactions
+  bolt, err := token.BalanceOf(old.Address())
+  if err != nil {
+    log.Panic("Could not get token balance: ", err)
+  }
+  if bolt.Sign() > 0 {
+    fmt.Printf("%s Sending %s atomic BOLT units to the new wallet...\n", DateStr(), bolt)
+    opts := &contracts.TransactOpts{Signer: old, API: conf.API}
+    send_and_wait("Sending BOLT", old, func() (string, error) {
+      return token.Transfer(opts, wallet, bolt)
+    })
+  }
+  if refund != nil {
+    // The old wallet needs its ether to move the refund once it arrives
+    log.Println("Rotation paused until the old wallet's channel is closed")
+    fmt.Printf("\x1b[33m%s Channel still holds $%s for the old wallet. Run rotate-key again once the hub has closed it to move the refund and finish the rotation.\x1b[0m\n", DateStr(), refund.Format(6))
+    return
+  }
+  _, gasPrice := rpc.DefaultGas(conf.API)
+  rawtx, err := rpc.SweepTx(old, wallet, gasPrice)
+  if errors.Is(err, rpc.ErrNothingToSweep) {
+    log.Println("No ether left to send to the new wallet")
+  } else if err != nil {
+    log.Panic("Could not sign ether transfer: ", err)
+  } else {
+    fmt.Printf("%s Sending ether to the new wallet...\n", DateStr())
+    send_and_wait("Sending ether", old, func() (string, error) {
+      return rawtx, nil
+    })
+  }
+
+  // 5. Archive the old keystore
+  archived, err := config.ActivatePendingWallet(conf.WalletKeyPath, old.Address())
+  if err != nil {
+    log.Panic("Could not archive old keystore: ", err)
+  }
+  log.Println("Archived old keystore to", archived)
+  fmt.Printf("\x1b[32m%s Wallet key rotated to %s.\x1b[0m Old keystore archived to %s\n", DateStr(), wallet, archived)
+}
+
+/**
+ * Pay the unpaid bills out of the old wallet's channel. The hub closes the
+ * channel with the last payment and refunds the rest of the deposit to the
+ * old address.
+ *
+ * @param hub_api        Hub API client, logged in with the old wallet
+ * @param tokens         Token source of the client
+ * @param signer         Signer of the old wallet
+ * @param serial_hash    Hash of agent's serial number
+ * @param manager        Payment channel contract
+ * @param token          BOLT token contract
+ * @param hub_addr       Address of the admin to pay
+ * @return               Deposit still owed to the old wallet, nil if none
+ */
+func settle_channel(hub_api *api.Client, tokens *api.LoginSource, signer sig.Signer, serial_hash string,
+manager *contracts.ChannelManager, token *contracts.ERC20, hub_addr string) (*units.TokenAmount) {
+  channel_id, err := manager.ChannelId(signer.Address(), hub_addr)
+  if err != nil {
+    log.Panic("Could not look up the old wallet's channel: ", err)
+  } else if channel_id == "" {
+    log.Println("Old wallet has no open channel")
+    return nil
+  }
+  _deposit, err := manager.Deposit(signer.Address(), channel_id)
+  if err != nil {
+    log.Panic("Could not get deposit of channel ", channel_id, ": ", err)
+  }
+  decimals, err := token.Decimals(signer.Address())
+  if err != nil {
+    log.Panic("Could not get token decimals: ", err)
+  }
+  var bills *[]api.Bill
+  var committed *big.Int
+  retry_forever("Getting bills", func(ctx context.Context) (error) {
+    var err error
+    if bills, err = hub_api.Bills(ctx, serial_hash); err != nil {
+      handle_api_error(err, tokens, 0)
+      return err
+    }
+    committed, err = hub_api.ChannelSum(ctx, channel_id)
+    if err != nil {
+      handle_api_error(err, tokens, 0)
+    }
+    return err
+  })
+  var unpaid_sum = units.NewTokenAmount(nil, decimals)
+  var unpaid_bill_ids []int
+  for _, bill := range *bills {
+    amount, err := units.ParseTokenAmountCeil(bill.Amount.String(), decimals)
+    if err != nil {
+      log.Panic("Could not read amount of bill ", bill.BillId, ": ", err)
+    }
+    unpaid_sum = unpaid_sum.Add(amount)
+    unpaid_bill_ids = append(unpaid_bill_ids, bill.BillId)
+  }
+  deposit := units.NewTokenAmount(_deposit, decimals)
+  to_pay := units.NewTokenAmount(committed, decimals).Add(unpaid_sum)
+  if unpaid_sum.Sign() > 0 {
+    if to_pay.Cmp(deposit) > 0 {
+      log.Panic("Channel ", channel_id, " cannot cover the unpaid bills. Deposit funds or pay them before rotating.")
+    }
+    payload, err := payment(channel_id, to_pay.Hex(), unpaid_bill_ids, signer)
+    if err != nil {
+      log.Panic("Refusing to send payment with a bad signature: ", err)
+    }
+    retry_forever("Paying bills", func(ctx context.Context) (error) {
+      err, _, _ := hub_api.PayBills(ctx, payload)
+      if err != nil {
+        handle_api_error(err, tokens, 0)
+      }
+      return err
+    })
+    fmt.Printf("%s Paid %d bills from channel %s.\n", DateStr(), len(unpaid_bill_ids), channel_id)
+  }
+  refund := deposit.Sub(to_pay)
+  log.Printf("Channel %s settled at %s, %s left to be refunded to %s", channel_id, to_pay.Format(6), refund.Format(6), signer.Address())
+  fmt.Printf("%s Channel %s settled. \x1b[33m$%s\x1b[0m of the deposit goes back to the old wallet when the hub closes it.\n", DateStr(), channel_id, refund.Format(6))
+  if refund.Sign() <= 0 {
+    return nil
+  }
+  return &refund
+}
+
+/**
+ * Send a transaction and wait until it is mined. Panics if it fails, since
+ * the rotation can't go on without it.
+ *
+ * @param what      Description of the transaction, used for logging
+ * @param signer    Signer of the sender, to bump the gas price if needed
+ * @param sign      Signs the transaction
+ */
+func send_and_wait(what string, signer sig.Signer, sign func() (string, error)) {
+  rawtx, err := sign()
+  if err != nil {
+    log.Panic(what, ": ", err)
+  }
+  err, txhash := rpc.SendRaw(rawtx)
+  if txhash == "" {
+    log.Panic(what, ": ", err)
+  }
+  outcome, err := rpc.WaitOrBump(context.Background(), txhash, signer, rpc.DEFAULT_CONFIRMATIONS)
+  if err != nil {
+    log.Panic(what, ": ", err)
+  }
+  if outcome.Status != rpc.TxMined {
+    log.Panicf("%s: tx %s %s", what, txhash, outcome.Status)
+  }
+  log.Printf("%s: tx %s mined", what, txhash)
+}
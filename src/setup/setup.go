@@ -5,172 +5,278 @@ import (
   "api"
   "channels"
   "config"
+  "context"
+  "contracts"
+  "errors"
+  "events"
   "fmt"
+  "httpclient"
   "log"
-  "math"
+  "math/big"
   "os"
+  "retry"
   "rpc"
   "time"
   "sig"
+  "units"
 )
 
-func Init() ([]string){
-  // Setup logging
+// Longest a single hub API or RPC request may take
+const REQUEST_TIMEOUT = time.Second*30
+
+// Context that bounds a single request
+func request_ctx() (context.Context, context.CancelFunc) {
+  return context.WithTimeout(context.Background(), REQUEST_TIMEOUT)
+}
+
+// Log to agent.log instead of the terminal, or to stderr if it can't be opened
+func open_log() (*os.File) {
   f, err := os.OpenFile("agent.log", os.O_RDWR | os.O_CREATE | os.O_APPEND, 0666)
   if err != nil {
     fmt.Printf("\x1b[31;1mWARNING: Could not start logging process (%s)\x1b[0m\n", err)
+    log.SetOutput(os.Stderr)
+    return nil
   }
-  defer f.Close()
   log.SetOutput(f)
+  return f
+}
+
+func Init() ([]string, sig.Signer, bool){
+  // Setup logging
+  f := open_log()
+  defer f.Close()
 
   conf := config.Load()
   log.Println("Starting system. Agent serial number: ", conf.SerialNo)
   fmt.Printf("%s Starting system. Agent serial number: \x1b[4;49;33m%s\x1b[0m\n", DateStr(), conf.SerialNo)
-  rpc.ConnectToRPC(conf.Provider)
+  connect(conf)
+
+  hub_api := api.NewClient(conf.API)
+  hub_api.PersonalSign = conf.PersonalSign
 
   var registry_addr = ""
   var bolt_addr = ""
   fmt.Printf("%s Fetching routing addresses.\n", DateStr())
-  for registry_addr == "" || bolt_addr == "" {
-    _registry_addr, err1 := api.GetRegistry(conf.API)
-    if err1 != nil {
-      log.Println("Error fetching registry address", err1)
+  retry_forever("Fetching routing addresses", func(ctx context.Context) (error) {
+    var err error
+    registry_addr, err = hub_api.Registry(ctx)
+    if err != nil {
+      return err
     }
-    registry_addr = _registry_addr
-    _bolt_addr, err2 := api.GetBOLT(conf.API)
-    if err2 != nil {
-      log.Println("Error fetching BOLT address", err2)
+    bolt_addr, err = hub_api.BOLT(ctx)
+    if err != nil {
+      return err
     }
-    bolt_addr = _bolt_addr
-    if bolt_addr == "" || registry_addr == "" {
-      time.Sleep(time.Second*10)
+    if registry_addr == "" || bolt_addr == "" {
+      return errors.New("Hub returned an empty address")
     }
-  }
+    return nil
+  })
+
+  registry := contracts.NewRegistry(registry_addr)
 
   // If the setup keypair was not registered, something fishy is going on
-  check_registered(conf.HashedSerialNo, conf.WalletAddr, registry_addr)
+  check_registered(conf.HashedSerialNo, conf.WalletAddr, registry)
   // Add the wallet address to the registrar
-  add_wallet(conf.WalletAddr, conf.HashedSerialNo, conf.SetupAddr, conf.SetupPkey, registry_addr, conf.API)
+  add_wallet(conf.WalletAddr, conf.HashedSerialNo, conf.SetupAddr, conf.SetupSigner, registry, conf.API)
   // System cannot proceed until agent is registered
-  check_claimed(conf.HashedSerialNo, registry_addr)
+  check_claimed(conf.HashedSerialNo, registry)
   // Authenticate the agent to use the API
-  auth_token := authenticate(conf.WalletAddr, conf.WalletPkey, conf.API)
+  tokens := api.NewLoginSource(hub_api, conf.WalletSigner)
+  hub_api.Tokens = tokens
+  auth_token := authenticate(tokens)
   // Save the agent to the Grid+ API
-  save_agent(conf.HashedSerialNo, auth_token, conf.API)
+  save_agent(hub_api, conf.HashedSerialNo)
 
   // Get the ether balance
-  balance := rpc.EtherBalance(conf.WalletAddr)
-  fmt.Printf("%s Balance: \x1b[32m%d\x1b[0m wei\n", DateStr(), balance)
+  balance, err := rpc.EtherBalance(conf.WalletAddr)
+  if err != nil {
+    log.Println("Could not get ether balance", err)
+  }
+  fmt.Printf("%s Balance: \x1b[32m%s\x1b[0m ETH\n", DateStr(), units.FormatEther(balance))
   fmt.Printf("\x1b[32m%s Setup complete. Running.\x1b[0m\n", DateStr())
 
-  return []string{auth_token, conf.WalletAddr, conf.HashedSerialNo, bolt_addr, conf.API, conf.WalletPkey}
+  return []string{auth_token, conf.WalletAddr, conf.HashedSerialNo, bolt_addr, conf.API}, conf.WalletSigner, conf.PersonalSign
+}
+
+/**
+ * Connect to the RPC providers, apply the transaction and hub API settings and
+ * load the contract ABIs.
+ *
+ * @param conf    Loaded config
+ */
+func connect(conf config.Config) {
+  httpclient.Configure(conf.HTTP)
+  rpc.ConnectToRPC(conf.Providers...)
+  if conf.WSProvider != "" {
+    // Optional; without it we fall back to polling
+    err := rpc.ConnectWS(conf.WSProvider)
+    if err != nil {
+      log.Println("Could not subscribe over WebSocket, polling instead:", err)
+    }
+  }
+  // Keep track of in-flight transactions next to the wallet key
+  rpc.SetNonceStore(conf.WalletKeyPath)
+  if err := rpc.SetFeeMode(conf.FeeMode); err != nil {
+    log.Panic("Invalid transaction settings: ", err)
+  }
+  rpc.SetBumpPolicy(rpc.BumpPolicy{
+    Timeout: conf.BumpTimeout,
+    Percent: conf.BumpPercent,
+    MaxGasPrice: big.NewInt(conf.MaxGasPrice),
+    MaxFee: big.NewInt(conf.MaxFee),
+  })
+  // Without their ABIs, Registry and channel events aren't decoded
+  if conf.RegistryABI != "" {
+    if err := events.LoadRegistry(conf.RegistryABI); err != nil {
+      log.Panic("Could not load the Registry ABI: ", err)
+    }
+  }
+  if conf.ChannelsABI != "" {
+    if err := events.LoadChannels(conf.ChannelsABI); err != nil {
+      log.Panic("Could not load the channel contract ABI: ", err)
+    }
+  }
 }
 
 /**
  * Main event loop. Periodically check API for data.
  *
- * @param auth_token    Used to query authenticated routes
+ * @param auth_token    Token from setup. Refreshed automatically when it expires
  * @param wallet        Wallet address (identifier of the device)
  * @param serial_hash   Hash of agent's serial number
  * @param bolt          Address of BOLT token contract
  * @param hub           Full base url of the hub
- * @param pkey          Private key of the wallet
+ * @param signer        Signer of the wallet
+ * @param personal_sign Log in to the hub with personal_sign signatures
  */
-func Run(auth_token string, wallet string, serial_hash string, bolt string, hub string, pkey string) {
+func Run(auth_token string, wallet string, serial_hash string, bolt string, hub string, signer sig.Signer, personal_sign bool) {
+  hub_api := api.NewClient(hub)
+  hub_api.PersonalSign = personal_sign
+  // Keep using the token from setup until it needs refreshing
+  tokens := api.NewLoginSource(hub_api, signer)
+  tokens.SetToken(auth_token)
+  hub_api.Tokens = tokens
+
   var hub_addr = ""
   var channels_addr = ""
-  var channel_balance = 0
-
-  for hub_addr == "" || channels_addr == "" {
-    // Get the addresses from the API
-    _hub_addr, _ := api.GetHubAddr(hub)
-    hub_addr = _hub_addr
-    _channels_addr, _ := api.GetChannelsAddr(hub)
-    channels_addr = _channels_addr
+
+  // Get the addresses from the API
+  retry_forever("Fetching hub addresses", func(ctx context.Context) (error) {
+    var err error
+    hub_addr, err = hub_api.HubAddr(ctx)
+    if err != nil {
+      return err
+    }
+    channels_addr, err = hub_api.ChannelsAddr(ctx)
+    if err != nil {
+      return err
+    }
     if hub_addr == "" || channels_addr == "" {
-      time.Sleep(time.Second*10)
+      return errors.New("Hub returned an empty address")
     }
-  }
+    return nil
+  })
+
+  manager := contracts.NewChannelManager(channels_addr)
+  token := contracts.NewERC20(bolt)
 
-  channel_id := channels.CheckForChanneId(wallet, hub_addr, channels_addr)
+  channel_id := channels.CheckForChanneId(manager, wallet, hub_addr)
   if channel_id != "" {
     fmt.Printf("%s Found existing payment channel: \x1b[32m%s\x1b[0m \n", DateStr(), channel_id)
   }
 
+  backoff := retry.Backoff{Policy: retry.Forever}
   for true {
+    // Read everything we need from the chain in one round trip
+    state, err := read_state(wallet, token, hub_addr, manager)
+    if err != nil {
+      log.Println("Could not read agent state from chain", err)
+      backoff.Wait(context.Background())
+      continue
+    }
+    backoff.Reset()
+
     // Make sure ether balance is high enough to send a transaction.
     // NOTE: We won't be sending a transaction, but we need to make sure if
     // the tx gets played by the hub, it will go through
     gas, gasPrice := rpc.DefaultGas(hub)
-    needed := gas.Uint64()*gasPrice.Uint64()
-    check_ether(needed, wallet, serial_hash, auth_token, hub)
+    needed := new(big.Int).Mul(gas, gasPrice)
+    check_ether(needed, state.EtherBalance, wallet, serial_hash, hub_api)
 
     // Open a payment channel if one is needed. This will skip if the existing
     // channel is still good.
-    _channel_id := handle_channel(wallet, channels_addr, hub_addr, bolt, hub, pkey)
+    _channel_id := handle_channel(state.ChannelId, state.TokenBalance, wallet, manager, hub_addr, token, hub, signer)
     channel_id = _channel_id
 
     // 1. Ping the hub and ask if there are any unpaid bills. This will return
     //    amounts and ids for the bills.
-    bills, err := api.GetBills(serial_hash, hub, auth_token)
+    var wait = time.Second*10
+    ctx, cancel := request_ctx()
+    bills, err := hub_api.Bills(ctx, serial_hash)
     if err != nil {
-      fmt.Printf("\x1b[91m%s ERROR: Failed to get unpaid bills (%e)\x1b[0m\n", DateStr(), err)
-      log.Println("Encountered error getting bills (%s)", err)
+      fmt.Printf("\x1b[91m%s ERROR: Failed to get unpaid bills (%s)\x1b[0m\n", DateStr(), err)
+      wait = handle_api_error(err, tokens, wait)
     } else {
 
       // 3. Get the total amount committed to the channel
-      channel_sum, err3 := api.GetChannelSum(channel_id, hub, auth_token) // Total amount already commited to channel
+      channel_sum, err3 := hub_api.ChannelSum(ctx, channel_id) // Total amount already commited to channel
       if err3 != nil {
-        fmt.Printf("\x1b[91m%s ERROR: Failed to get channel sum (%e)\x1b[0m\n", DateStr(), err3)
-        log.Println("Encountered error getting bills (%s)", err)
+        fmt.Printf("\x1b[91m%s ERROR: Failed to get channel sum (%s)\x1b[0m\n", DateStr(), err3)
+        wait = handle_api_error(err3, tokens, wait)
       } else {
         // 2. Total the unpaid bills and sign a message that will move that many
         //    tokens to the address provided by the hub.
-        var unpaid_sum float64
+        var unpaid_sum = units.NewTokenAmount(nil, state.Decimals)
         var unpaid_bill_ids []int
         for _, bill := range *bills {
-          unpaid_sum += bill.Amount
+          // Round up to the nearest BOLT atomic unit
+          amount, err := units.ParseTokenAmountCeil(bill.Amount.String(), state.Decimals)
+          if err != nil {
+            log.Printf("Could not read amount of bill %d (%s)", bill.BillId, err)
+            continue
+          }
+          unpaid_sum = unpaid_sum.Add(amount)
           unpaid_bill_ids = append(unpaid_bill_ids, bill.BillId)
         }
 
-        if unpaid_sum > 0 {
+        if unpaid_sum.Sign() > 0 {
           // ascii colors: http://misc.flogisoft.com/_media/bash/colors_format/colors_and_formatting.sh.png
-          fmt.Printf("%s Unpaid amount: \x1b[91m$%.6f\x1b[0m\n", DateStr(), unpaid_sum)
+          fmt.Printf("%s Unpaid amount: \x1b[91m$%s\x1b[0m\n", DateStr(), unpaid_sum.Format(6))
 
           // 3. Get balance in the channel
-          decimals := float64(rpc.TokenDecimals(wallet, bolt))
           // Total amount available to channel
-          channel_deposit := float64(channels.GetDeposit())
+          channel_deposit := units.NewTokenAmount(channels.GetDeposit(), state.Decimals)
+          // Total amount already committed to the hub
+          committed := units.NewTokenAmount(channel_sum, state.Decimals)
           // Balance of the device (external to channel)
-          token_balance := float64(rpc.TokenBalance(wallet, bolt)) / math.Pow(10, decimals)
+          token_balance := units.NewTokenAmount(state.TokenBalance, state.Decimals)
           // Total remainder (in dollars) of the channel
-          var usd_balance = (channel_deposit-channel_sum)/(math.Pow(10, decimals))
+          var usd_balance = channel_deposit.Sub(committed)
 
 
-          if usd_balance >= unpaid_sum {
-            // Round to the nearest BOLT atomic unit
-            var to_pay = int(math.Ceil(channel_sum + (unpaid_sum * math.Pow(10, decimals)) ))
+          if usd_balance.Cmp(unpaid_sum) >= 0 {
+            // The hub is paid the running total committed to the channel
+            var to_pay = committed.Add(unpaid_sum)
             // Sign message that will be sent to the payment channel by the hub
-            to_pay_hex := fmt.Sprintf("%x", int64(to_pay))
-            proof := sig.SignPayment(channel_id, to_pay_hex, pkey)
-
-            // Load up the request payload
-            var payload = api.BillPayReq{}
-            payload.BillIds = unpaid_bill_ids
-            payload.Msg = proof.MsgHash
-            payload.V = proof.V
-            payload.R = proof.R
-            payload.S = proof.S
-            payload.Value = proof.Value
-
-            err, ids, remaining := api.PayBills(&payload, hub, auth_token)
+            payload, err := payment(channel_id, to_pay.Hex(), unpaid_bill_ids, signer)
             if err != nil {
-              fmt.Printf("\x1b[91m%s ERROR: Failed to pay bills.\x1b[0m\n", DateStr())
+              fmt.Printf("\x1b[31;1m%s ERROR: Refusing to send payment with a bad signature (%s)\x1b[0m\n", DateStr(), err)
+              log.Println("Payment signature did not verify", err)
             } else {
-              channel_balance = remaining
-              var channel_bal_disp = float64(channel_balance)/(math.Pow(10, decimals))
-              fmt.Printf("\x1b[32m%s Successfully paid %d bills.\x1b[0m\n", DateStr(), len(ids))
-              fmt.Printf("%s Channel balance: \x1b[32m$%.6f\x1b[0m BOLT reserve: \x1b[32m$%.6f\x1b[0m\n", DateStr(), channel_bal_disp, token_balance)
+              err, ids, remaining := hub_api.PayBills(ctx, payload)
+              if errors.Is(err, api.ErrInsufficientBalance) {
+                // The hub thinks the channel can't cover the bills, even though we do
+                fmt.Printf("\x1b[31;1m%s Hub reports insufficient channel balance to pay bills (%s). Please deposit funds.\x1b[0m\n", DateStr(), err)
+                log.Println("Hub rejected payment for insufficient balance", err)
+              } else if err != nil {
+                fmt.Printf("\x1b[91m%s ERROR: Failed to pay bills.\x1b[0m\n", DateStr())
+                wait = handle_api_error(err, tokens, wait)
+              } else {
+                channel_balance := units.NewTokenAmount(remaining, state.Decimals)
+                fmt.Printf("\x1b[32m%s Successfully paid %d bills.\x1b[0m\n", DateStr(), len(ids))
+                fmt.Printf("%s Channel balance: \x1b[32m$%s\x1b[0m BOLT reserve: \x1b[32m$%s\x1b[0m\n", DateStr(), channel_balance.Format(6), token_balance.Format(6))
+              }
             }
           } else {
             fmt.Printf("\x1b[91m%s ERROR: Insufficient balance to pay bills.\x1b[0m\n", DateStr())
@@ -180,34 +286,164 @@ func Run(auth_token string, wallet string, serial_hash string, bolt string, hub
       }
     }
 
-    // Wait 10 seconds and execute again
-    time.Sleep(time.Second*10)
+    cancel()
+
+    // Wait 10 seconds (or longer if the hub asked us to back off) and execute again
+    time.Sleep(wait)
   }
 }
 
+/**
+ * Sign a payment of bills and check the signature before it goes to the hub.
+ *
+ * @param channel_id    Id of the payment channel
+ * @param to_pay        Running total committed to the channel (hex)
+ * @param bill_ids      Bills the payment covers
+ * @param signer        Signer of the wallet that opened the channel
+ * @return              Request payload for /PayBills, error
+ */
+func payment(channel_id string, to_pay string, bill_ids []int, signer sig.Signer) (*api.BillPayReq, error) {
+  proof, err := sig.SignPayment(channel_id, to_pay, signer)
+  if err != nil {
+    return nil, err
+  }
+  // Never hand the hub a payment the channel won't honour
+  if err := sig.VerifyPayment(proof, channel_id, signer.Address()); err != nil {
+    return nil, err
+  }
+  // Load up the request payload
+  var payload = api.BillPayReq{}
+  payload.BillIds = bill_ids
+  payload.Msg = proof.MsgHash
+  payload.V = proof.V
+  payload.R = proof.R
+  payload.S = proof.S
+  payload.Value = proof.Value
+  return &payload, nil
+}
+
+/**
+ * Decide what to do about a failed hub request in the main loop.
+ *
+ * @param err       Error from the hub API client
+ * @param tokens    Token source of the client
+ * @param wait      Default wait before the next iteration
+ * @return          How long to wait before the next iteration
+ */
+func handle_api_error(err error, tokens *api.LoginSource, wait time.Duration) (time.Duration) {
+  log.Println("Hub request failed:", err)
+  var apiErr *api.Error
+  errors.As(err, &apiErr)
+  switch {
+  case errors.Is(err, api.ErrUnauthorized):
+    // The client already retried with a fresh token. Start over next time.
+    log.Println("Hub rejected our token. Logging in again.")
+    if tokens != nil {
+      tokens.Reset()
+    }
+  case errors.Is(err, api.ErrRateLimited):
+    wait = time.Minute
+    if apiErr != nil && apiErr.RetryAfter > wait {
+      wait = apiErr.RetryAfter
+    }
+    log.Println("Rate limited by hub. Backing off for", wait)
+  case errors.Is(err, api.ErrNotFound):
+    fmt.Printf("\x1b[31;1m%s Hub does not know this agent (%s). Please contact Grid+ with your serial number.\x1b[0m\n", DateStr(), err)
+    wait = time.Minute
+  case !api.Retryable(err):
+    fmt.Printf("\x1b[31;1m%s Hub request failed and will not succeed on retry (%s). Please contact Grid+.\x1b[0m\n", DateStr(), err)
+  }
+  return wait
+}
+
+// Chain data the main loop needs on every iteration
+type AgentState struct {
+  EtherBalance *big.Int     // Wei held by the wallet
+  TokenBalance *big.Int     // BOLT held by the wallet (outside the channel)
+  Decimals uint8            // Decimals of the BOLT token
+  ChannelId string          // Open channel to the hub, or ""
+  Deposit *big.Int          // Deposit in that channel
+}
+
+/**
+ * Read the wallet's balances and channel. Reads the agent only displays go out
+ * in a single batch request; the token balance and deposit, which it spends
+ * from, must be agreed on by a quorum of providers.
+ *
+ * @param wallet              Address of this device's wallet
+ * @param token               BOLT token contract
+ * @param hub_addr            Address of the admin to pay
+ * @param manager             Payment channel contract
+ * @return                    State, error
+ */
+func read_state(wallet string, token *contracts.ERC20, hub_addr string, manager *contracts.ChannelManager) (*AgentState, error) {
+  var ether, decimals, id string
+  decimals_call, err := token.DecimalsCall(wallet)
+  if err != nil { return nil, err }
+  id_call, err := manager.ChannelIdCall(wallet, hub_addr)
+  if err != nil { return nil, err }
+  batch := rpc.NewBatch()
+  batch.Balance(wallet, &ether)
+  batch.Call(decimals_call, &decimals)
+  batch.Call(id_call, &id)
+  ctx, cancel := request_ctx()
+  defer cancel()
+  err = batch.Send(ctx)
+  if err != nil {
+    return nil, err
+  }
+  for _, elem := range batch.Elems() {
+    if elem.Error != nil {
+      return nil, elem.Error
+    }
+  }
+
+  var state = AgentState{}
+  state.EtherBalance, err = rpc.ParseQuantity(ether)
+  if err != nil { return nil, err }
+  state.Decimals, err = token.ParseDecimals(decimals)
+  if err != nil { return nil, err }
+  id, err = manager.ParseChannelId(id)
+  if err != nil { return nil, err }
+  state.TokenBalance, err = token.BalanceOf(wallet)
+  if err != nil { return nil, err }
+  state.Deposit = new(big.Int)
+  if id != "" {
+    state.Deposit, err = manager.Deposit(wallet, id)
+    if err != nil { return nil, err }
+  }
+  channels.SetChannel(id, state.Deposit)
+  state.ChannelId = channels.GetChannelId()
+  return &state, nil
+}
+
 /**
  * Set up a payment channel if one does not exist. Load it up with a default
  * amount of BOLT tokens.
  *
+ * @param id                  Id of the existing channel, or ""
+ * @param balance             Token balance of the wallet
  * @param wallet              Address of this device's wallet
- * @param channels_addr       Address of the payment channel contract
+ * @param manager             Payment channel contract
  * @param hub_addr            Address of the admin to pay
- * @param bolt                Address of token contract
+ * @param token               BOLT token contract
  * @param hub                 Full base URI of the hub API
- * @param pkey                Private key of wallet
+ * @param signer              Signer of the wallet
  */
-func handle_channel(wallet string, channels_addr string, hub_addr string, bolt string,
-hub string, pkey string) (string) {
-  id := channels.CheckForChanneId(wallet, hub_addr, channels_addr)
+func handle_channel(id string, balance *big.Int, wallet string, manager *contracts.ChannelManager,
+hub_addr string, token *contracts.ERC20, hub string, signer sig.Signer) (string) {
   // Open a channel with the existing token balance
-  balance := rpc.TokenBalance(wallet, bolt)
-  HARD_MIN := uint64(500000000)  // Minimum of $5 deposited to open a channel
+  HARD_MIN := big.NewInt(500000000)  // Minimum of $5 deposited to open a channel
   err_disp := false
   if id == "" {
     // Make sure the balance is high enough
-    for balance < HARD_MIN {
-      _balance := rpc.TokenBalance(wallet, bolt)
-      if _balance < HARD_MIN {
+    for balance.Cmp(HARD_MIN) < 0 {
+      _balance, err := token.BalanceOf(wallet)
+      if err != nil {
+        log.Print("Could not get balance: ", err)
+        _balance = big.NewInt(0)
+      }
+      if _balance.Cmp(HARD_MIN) < 0 {
         if err_disp == false {
           fmt.Printf("\x1b[31;1mInsufficient token balance to open channel. Need at least %d, have %d. Please deposit funds.\x1b[0m\n", HARD_MIN, _balance)
           err_disp = true
@@ -218,7 +454,12 @@ hub string, pkey string) (string) {
       }
     }
     // If the balance is high enough, open a channel
-    id = channels.OpenChannel(wallet, channels_addr, bolt, hub_addr, balance, pkey, hub)
+    // A revert in the pre-flight gas estimate may only mean a node is behind
+    retry_forever("Opening channel", func(_ context.Context) (error) {
+      var err error
+      id, err = channels.OpenChannel(signer, manager, token, hub_addr, balance, hub)
+      return err
+    })
     fmt.Printf("%s Opened new payment channel: \x1b[32m%s\x1b[0m \n", DateStr(), id)
   }
   return id
@@ -229,28 +470,40 @@ hub string, pkey string) (string) {
  *
  * @param serial_hash    Serial number.
  * @param wallet         Address of the device's wallet
- * @param registry       Address of the registry contract
+ * @param registry       Registry contract
  */
-func check_registered(serial_hash string, wallet string, registry string) {
+func check_registered(serial_hash string, wallet string, registry *contracts.Registry) {
   fmt.Printf("%s Waiting for registration confirmation.\n", DateStr())
   // Check if the setup key is registered
-  reg := rpc.CheckRegistered(wallet, serial_hash, registry)
+  reg := is_registered(wallet, serial_hash, registry)
   if reg == false {
     log.Println("Serial hash not registered with Grid+ :", serial_hash)
     log.Println("Please contact Grid+ with your serial number for assistance.")
     log.Println("Pausing setup until serial number is registered.")
     fmt.Printf("\x1b[31;1mDevice not registered. Please contact Grid+ with your serial number.\x1b[0m\n")
   }
-  for reg == false {
+  if reg == false {
     // If it isn't registered, someone is probably trying to spoof some data.
-    // Nevertheless, throw it in a loop.
-    time.Sleep(time.Second*10)
-    _reg := rpc.CheckRegistered(wallet, serial_hash, registry)
-    reg = _reg
+    // Nevertheless, keep checking.
+    retry_forever("Waiting for registration", func(ctx context.Context) (error) {
+      if !is_registered(wallet, serial_hash, registry) {
+        return errors.New("Serial hash not registered")
+      }
+      return nil
+    })
   }
   return
 }
 
+// Check the registry for the serial number. Exits if the registry can't be read.
+func is_registered(wallet string, serial_hash string, registry *contracts.Registry) (bool) {
+  reg, err := registry.Registered(wallet, serial_hash)
+  if err != nil {
+    log.Fatal("Could not check if agent was registered: ", err)
+  }
+  return reg
+}
+
 
 /**
  * Add a wallet address to the registry contract and wait until the transaction
@@ -259,45 +512,62 @@ func check_registered(serial_hash string, wallet string, registry string) {
  * @param wallet_addr    Address of the wallet we want to register
  * @param hashed_serial  Keccak256 hash of the serial number
  * @param setup_addr     Address of the setup keypair
- * @param setup_pkey     Private key of the currently registered address
- * @param registry       Address of the registry contract
+ * @param setup          Signer of the currently registered address (nil if
+ *                       there is no setup key)
+ * @param registry       Registry contract
  * @param _api           Full base URI for the API
  */
 func add_wallet(wallet_addr string, hashed_serial string, setup_addr string,
-setup_pkey string, registry string, _api string) {
-  added := rpc.CheckRegistry(setup_addr, hashed_serial, wallet_addr, registry)
+setup sig.Signer, registry *contracts.Registry, _api string) {
+  added, err := registry.CheckRegistry(setup_addr, hashed_serial, wallet_addr)
+  if err != nil {
+    log.Fatal("Could not check if agent was registered: ", err)
+  }
   if added == false {
     log.Println("Adding wallet...")
     fmt.Printf("%s Adding wallet...\n", DateStr())
-
-    // Form a transaction to add the wallet
-    var data = "0xb993b3f5"+rpc.Zfill(wallet_addr)+hashed_serial
-    err, txhash := rpc.AddWallet(setup_addr, registry, data, _api, setup_pkey)
-    if err != nil {
-      log.Panic("Unable to add wallet to registry", err)
+    if setup == nil {
+      log.Panic("Wallet is not registered and there is no setup key. Set agent.pkey or agent.keystore in setup_keys.toml.")
     }
 
-    // Wait until the tx is mined
-    var mined = false
-    for mined == false {
-      success, err2 := rpc.CheckReceipt(txhash)
-      if err2 != nil {
-        log.Panic("Unable to get receipt ", err2)
-      }
-      if success == 1 {
-        mined = true
-        fmt.Printf("%s Wallet successfully added.\n", DateStr())
-        log.Println("Wallet successfully added.")
-      } else if success == -1 {
-        // Recursive call if the tx threw. Not sure what else to do here, since
-        // we can't proceed without a wallet
-        mined = true
-        log.Println("Wallet could not be added (tx threw). Reattempting in 10 seconds...")
-        time.Sleep(time.Second*10)
-        add_wallet(wallet_addr, hashed_serial, setup_addr, setup_pkey, registry, _api)
+    // Form a transaction to add the wallet and submit it
+    opts := &contracts.TransactOpts{Signer: setup, API: _api}
+    send := func() (error, string) {
+      rawtx, err := registry.SetWallet(opts, wallet_addr, hashed_serial)
+      if err != nil { return err, "" }
+      return rpc.SendRaw(rawtx)
+    }
+    err, txhash := send()
+    backoff := retry.Backoff{Policy: retry.Forever}
+    for txhash == "" {
+      if errors.Is(err, rpc.ErrInsufficientFunds) {
+        log.Println("Setup address has insufficient ether to add wallet", err)
+        fmt.Printf("\x1b[31;1mSetup address %s needs ether to add the wallet. Please send it some.\x1b[0m\n", setup_addr)
+      } else if errors.Is(err, rpc.ErrNonceTooLow) || errors.Is(err, rpc.ErrReplacementUnderpriced) {
+        log.Println("Setup address nonce already used, re-signing", err)
+      } else if errors.Is(err, rpc.ErrExecutionReverted) {
+        log.Panic("Adding wallet to registry would revert. Is the setup address registered? ", err)
       } else {
-        time.Sleep(time.Second*10)
+        log.Panic("Unable to add wallet to registry", err)
       }
+      backoff.Wait(context.Background())
+      err, txhash = send()
+    }
+
+    // Wait until the tx is mined
+    outcome, err2 := rpc.WaitOrBump(context.Background(), txhash, setup, rpc.DEFAULT_CONFIRMATIONS)
+    if err2 != nil {
+      log.Panic("Unable to get receipt ", err2)
+    }
+    if outcome.Status == rpc.TxMined {
+      fmt.Printf("%s Wallet successfully added.\n", DateStr())
+      log.Println("Wallet successfully added.")
+    } else {
+      // Recursive call if the tx threw or never made it. Not sure what else to
+      // do here, since we can't proceed without a wallet
+      log.Printf("Wallet could not be added (tx %s). Reattempting in 10 seconds...", outcome.Status)
+      time.Sleep(time.Second*10)
+      add_wallet(wallet_addr, hashed_serial, setup_addr, setup, registry, _api)
     }
   } else {
     log.Println("Wallet already registered. Skipping.")
@@ -310,16 +580,20 @@ setup_pkey string, registry string, _api string) {
  * Check if the agent has been claimed by an owner.
  *
  * @param  serial_hash    Keccak256 hash of the serial number
- * @param  registry      Address of the registry contract
+ * @param  registry      Registry contract
  */
-func check_claimed(serial_hash string, registry string) {
+func check_claimed(serial_hash string, registry *contracts.Registry) {
   log.Println("Waiting for agent to be claimed...")
   fmt.Printf("%s Waiting for agent to be claimed...\n", DateStr())
   var reg = false
   for reg == false {
-    _reg := rpc.CheckClaimed(serial_hash, registry)
+    _reg, err := registry.Claimed(serial_hash)
+    if err != nil {
+      log.Println("Could not check if agent was claimed: ", err)
+    }
     if _reg != true {
-      time.Sleep(time.Second*10)
+      // Check again once the next block is in
+      rpc.WaitForBlock(context.Background(), time.Second*10)
     } else {
       log.Println("Agent claimed and fully registered.")
       fmt.Printf("%s Agent claimed and fully registered.\n", DateStr())
@@ -330,30 +604,42 @@ func check_claimed(serial_hash string, registry string) {
 
 
 /**
- * Authenticate the device with the API. This should be called with the wallet
- * address and key.
+ * Authenticate the device with the API, retrying until it succeeds.
  *
- * @param _agent    Address of the agent's wallet
- * @param _pkey     Private key for the agent's wallet
- * @param _api      Full base URI of the API
+ * @param tokens    Token source that logs in with the wallet key
  * @return          JSON web token used for authenticated API endpoints
  */
-func authenticate(_agent string, _pkey string, _api string) (string) {
+func authenticate(tokens *api.LoginSource) (string) {
   token := ""
   log.Println("Waiting for authentication...")
-  for token == "" {
-    _token, err := api.GetAuthToken(_agent, _pkey, _api)
-    if err != nil {
-      log.Println(err)
-      time.Sleep(time.Second*10)
-    } else {
-      log.Println("Authentication successful.")
-      token = _token
-    }
-  }
+  retry_forever("Authentication", func(ctx context.Context) (error) {
+    var err error
+    token, err = tokens.Token(ctx)
+    return err
+  })
+  log.Println("Authentication successful.")
   return token
 }
 
+/**
+ * Run an operation until it succeeds, backing off between attempts. Each
+ * attempt gets its own request timeout.
+ *
+ * @param what    Description of the operation, used for logging
+ * @param op      Operation to run
+ */
+func retry_forever(what string, op func(ctx context.Context) (error)) {
+  policy := retry.Forever
+  policy.OnRetry = func(attempt int, err error, wait time.Duration) {
+    log.Printf("%s failed (attempt %d), retrying in %s: %s", what, attempt, wait.Round(time.Second), err)
+  }
+  policy.Do(context.Background(), func(_ context.Context) (error) {
+    ctx, cancel := request_ctx()
+    defer cancel()
+    return op(ctx)
+  })
+}
+
 func DateStr() (string) {
   return time.Now().UTC().Format(time.UnixDate)+": "
 }
@@ -364,43 +650,50 @@ func DateStr() (string) {
  * If it isn't call the faucet and wait until it is
  *
  * @param  needed        Number of wei needed to proceed
+ * @param  balance       Current wei balance of the wallet
  * @param  wallet        Address to check and call the faucet for
- * @param serial_hash   Hash of agent's serial number
- * @param  auth_token    JSON web token to call the faucet with
- * @param  API           Full base URI of API
+ * @param  serial_hash   Hash of agent's serial number
+ * @param  hub_api       Hub API client to call the faucet with
  */
-func check_ether(needed uint64, wallet string, serial_hash string, auth_token string, API string) {
-  balance := rpc.EtherBalance(wallet)
-  if balance < needed {
-    fmt.Printf("%s Balance: \x1b[91m%d\x1b[0m wei. Calling faucet.\n", DateStr(), balance)
+func check_ether(needed *big.Int, balance *big.Int, wallet string, serial_hash string, hub_api *api.Client) {
+  if balance.Cmp(needed) < 0 {
+    fmt.Printf("%s Balance: \x1b[91m%s\x1b[0m ETH. Calling faucet.\n", DateStr(), units.FormatEther(balance))
   }
-  for balance < needed {
+  backoff := retry.Backoff{Policy: retry.Forever}
+  for balance.Cmp(needed) < 0 {
     // Call the faucet and wait for the transaction to clear
-    var done = false
-    txhash, err := api.Faucet(serial_hash, wallet, auth_token, API)
+    ctx, cancel := request_ctx()
+    txhash, err := hub_api.Faucet(ctx, serial_hash, wallet)
+    cancel()
     if err != nil {
-      fmt.Printf("\x1b[91m%d\x1b[0m %s Error encountered calling /Faucet.\n", DateStr(), balance)
-      time.Sleep(time.Second*30)
-      check_ether(needed, wallet, serial_hash, auth_token, API)
+      fmt.Printf("\x1b[91m%s\x1b[0m %s Error encountered calling /Faucet.\n", DateStr(), units.FormatEther(balance))
+      log.Println("Faucet request failed", err)
+      backoff.Wait(context.Background())
+      continue
     }
-    for done == false {
-      _success, _ := rpc.CheckReceipt(txhash)
-      if _success == 0 {
-        time.Sleep(time.Second * 5)
-      } else {
-        done = true
-      }
+    outcome, err2 := rpc.WaitForReceipt(context.Background(), txhash, 1)
+    if err2 != nil {
+      log.Println("Error waiting for faucet transaction", err2)
+    } else if outcome.Status != rpc.TxMined {
+      log.Printf("Faucet transaction %s was %s", txhash, outcome.Status)
     }
     // Update the balance and see if we need more faucet (we shouldn't)
-    balance = rpc.EtherBalance(wallet)
-    fmt.Printf("%s New balance: \x1b[32m%d\x1b[0m wei\n", DateStr(), balance)
+    _balance, err3 := rpc.EtherBalance(wallet)
+    if err3 != nil {
+      log.Println("Could not get ether balance", err3)
+      continue
+    }
+    balance = _balance
+    fmt.Printf("%s New balance: \x1b[32m%s\x1b[0m ETH\n", DateStr(), units.FormatEther(balance))
   }
 }
 
 // Save the agent device to the Grid+ API
 // This allows Grid+ to start reading from the agent's owner's meter.
-func save_agent(serial_hash string, auth_token string, API string) {
-  success, err := api.SaveAgent(serial_hash, auth_token, API)
+func save_agent(hub_api *api.Client, serial_hash string) {
+  ctx, cancel := request_ctx()
+  defer cancel()
+  success, err := hub_api.SaveAgent(ctx, serial_hash)
   if err != nil || success != 1 {
     log.Println("Error saving Agent to Grid+ API", err)
     panic(err)
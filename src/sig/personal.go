@@ -0,0 +1,93 @@
+package sig
+
+import (
+  "encoding/hex"
+  "fmt"
+  "strconv"
+)
+
+// A decomposed ECDSA signature. All values are hex without a 0x prefix, and V
+// is 1b or 1c (27 or 28) as wallets and ecrecover expect.
+type Signature struct {
+  V string `json:"v"`
+  R string `json:"r"`
+  S string `json:"s"`
+}
+
+/**
+ * Get the signature in the 65 byte [R || S || V] form wallets produce.
+ */
+func (sig *Signature) Hex() (string) {
+  return "0x" + sig.R + sig.S + sig.V
+}
+
+/**
+ * Get the signature as 65 bytes [R || S || V] where V is 0 or 1, the form
+ * crypto.Sign returns and transactions are assembled from.
+ */
+func (sig *Signature) Bytes() ([]byte, error) {
+  r, err := hex.DecodeString(sig.R)
+  if err != nil || len(r) != 32 {
+    return nil, fmt.Errorf("Invalid signature R %q", sig.R)
+  }
+  s, err := hex.DecodeString(sig.S)
+  if err != nil || len(s) != 32 {
+    return nil, fmt.Errorf("Invalid signature S %q", sig.S)
+  }
+  v, err := strconv.ParseUint(sig.V, 16, 8)
+  if err != nil { return nil, fmt.Errorf("Invalid signature V %q", sig.V) }
+  if v >= 27 { v -= 27 }
+  if v > 1 { return nil, fmt.Errorf("Invalid signature V %q", sig.V) }
+  return append(append(r, s...), byte(v)), nil
+}
+
+// Decompose a 65 byte [R || S || V] signature, with V 0/1 or 27/28
+func signatureFromBytes(b []byte) (*Signature, error) {
+  if len(b) != 65 {
+    return nil, fmt.Errorf("Invalid signature length %d", len(b))
+  }
+  v := b[64]
+  if v < 27 { v += 27 }
+  return &Signature{V: fmt.Sprintf("%x", v), R: hex.EncodeToString(b[:32]), S: hex.EncodeToString(b[32:64])}, nil
+}
+
+// A signer that signs personal_sign messages itself, e.g. a remote signer
+// that shows the message to whoever approves it
+type TextSigner interface {
+  SignText(message []byte) (*Signature, error)
+}
+
+/**
+ * Hash a message the way eth_sign and personal_sign do (EIP-191), i.e.
+ * keccak256("\x19Ethereum Signed Message:\n" + len(message) + message).
+ * The prefix keeps a signed message from ever being a valid transaction.
+ */
+func PersonalHash(message []byte) ([]byte) {
+  prefix := fmt.Sprintf("\x19Ethereum Signed Message:\n%d", len(message))
+  return Keccak256Hash(append([]byte(prefix), message...))
+}
+
+/**
+ * Sign a message like Metamask's personal_sign.
+ *
+ * @param  message    Message to sign (not hashed)
+ * @param  signer     Signer of the message
+ * @return            Signature, error
+ */
+func SignPersonal(message []byte, signer Signer) (*Signature, error) {
+  if text, ok := signer.(TextSigner); ok {
+    return text.SignText(message)
+  }
+  return signer.SignHash(PersonalHash(message))
+}
+
+/**
+ * Recover the address that signed a message with personal_sign.
+ *
+ * @param  message    Message that was signed
+ * @param  sig        Signature of the message
+ * @return            0x-prefixed address of the signer, error
+ */
+func RecoverPersonal(message []byte, sig *Signature) (string, error) {
+  return RecoverSigner(hex.EncodeToString(PersonalHash(message)), sig.V, sig.R, sig.S)
+}
@@ -0,0 +1,265 @@
+package sig
+
+import (
+  "bufio"
+  "bytes"
+  "encoding/hex"
+  "encoding/json"
+  "errors"
+  "fmt"
+  "io/ioutil"
+  "math/big"
+  "net"
+  "net/http"
+  "strings"
+  "sync/atomic"
+  "time"
+)
+import "httpclient"
+
+// How long a remote signer may take to answer. Clef waits for a human to
+// approve each request, so this is generous.
+const REMOTE_SIGNER_TIMEOUT = 2*time.Minute
+
+// Content type of account_signData requests for channel payments. The data
+// is the channel id and the value, 32 bytes each, so the signer can check
+// what it pays before signing sha3(channel_id, value).
+const PAYMENT_CONTENT_TYPE = "application/x-channel-payment"
+
+// Signs through a Clef-compatible signer over JSON-RPC, so the key lives in
+// another process or on another machine. Transactions and messages go through
+// Clef's account_signTransaction and account_signData, and channel payments
+// through account_signData with PAYMENT_CONTENT_TYPE. Raw hashes are only
+// signed by signers that answer account_signHash.
+type RemoteSigner struct {
+  endpoint string
+  address string
+  id int64
+}
+
+type remoteReq struct {
+  JSONRPC string `json:"jsonrpc"`
+  Id int64 `json:"id"`
+  Method string `json:"method"`
+  Params []interface{} `json:"params"`
+}
+
+type remoteRes struct {
+  Result json.RawMessage `json:"result"`
+  Error *struct {
+    Code int `json:"code"`
+    Message string `json:"message"`
+  } `json:"error"`
+}
+
+// Transaction arguments of account_signTransaction (Clef's SendTxArgs)
+type SendTxArgs struct {
+  From string `json:"from"`
+  To string `json:"to"`
+  Gas string `json:"gas"`
+  GasPrice string `json:"gasPrice,omitempty"`
+  MaxFeePerGas string `json:"maxFeePerGas,omitempty"`
+  MaxPriorityFeePerGas string `json:"maxPriorityFeePerGas,omitempty"`
+  Value string `json:"value"`
+  Nonce string `json:"nonce"`
+  Data string `json:"data"`
+  ChainId string `json:"chainId"`
+}
+
+/**
+ * Connect to a remote signer.
+ *
+ * @param  endpoint    http(s):// URL, or the path of a UNIX socket (IPC)
+ * @param  address     Account to sign with. Empty for the first one the
+ *                     signer lists.
+ * @return             Signer, error
+ */
+func NewRemoteSigner(endpoint string, address string) (*RemoteSigner, error) {
+  r := &RemoteSigner{endpoint: endpoint, address: strings.ToLower(address)}
+  var accounts []string
+  if err := r.call("account_list", nil, &accounts); err != nil {
+    return nil, err
+  }
+  for _, account := range accounts {
+    if r.address == "" || strings.EqualFold(account, r.address) {
+      r.address = strings.ToLower(account)
+      return r, nil
+    }
+  }
+  if r.address == "" {
+    return nil, fmt.Errorf("Remote signer %s has no accounts", endpoint)
+  }
+  return nil, fmt.Errorf("Remote signer %s does not manage %s", endpoint, r.address)
+}
+
+func (r *RemoteSigner) Address() (string) {
+  return r.address
+}
+
+func (r *RemoteSigner) SignHash(hash []byte) (*Signature, error) {
+  var signature string
+  err := r.call("account_signHash", []interface{}{r.address, "0x" + hex.EncodeToString(hash)}, &signature)
+  if err != nil { return nil, err }
+  return parseRemoteSignature(signature)
+}
+
+func (r *RemoteSigner) SignTx(tx *Tx, chainID int64) (string, error) {
+  args := SendTxArgs{
+    From: r.address,
+    To: tx.To,
+    Gas: quantity(tx.Gas),
+    Value: quantity(tx.Value),
+    Nonce: fmt.Sprintf("0x%x", tx.Nonce),
+    Data: tx.Data,
+    ChainId: quantity(big.NewInt(chainID)),
+  }
+  if tx.Dynamic() {
+    args.MaxFeePerGas = quantity(tx.GasFeeCap)
+    args.MaxPriorityFeePerGas = quantity(tx.GasTipCap)
+  } else {
+    args.GasPrice = quantity(tx.GasPrice)
+  }
+  var result struct {
+    Raw string `json:"raw"`
+  }
+  if err := r.call("account_signTransaction", []interface{}{args}, &result); err != nil {
+    return "", err
+  }
+  if result.Raw == "" {
+    return "", errors.New("Remote signer returned no transaction")
+  }
+  return result.Raw, nil
+}
+
+/**
+ * Sign a channel payment, through account_signData (see PAYMENT_CONTENT_TYPE).
+ *
+ * @param  channel_id    0x-prefixed bytes32 id of payment channel
+ * @param  amount        amount to send (hex string)
+ * @return               Signature, error
+ */
+func (r *RemoteSigner) SignPaymentData(channel_id string, amount string) (*Signature, error) {
+  var signature string
+  params := []interface{}{PAYMENT_CONTENT_TYPE, r.address, "0x" + zfill(channel_id) + zfill(amount)}
+  if err := r.call("account_signData", params, &signature); err != nil {
+    return nil, err
+  }
+  return parseRemoteSignature(signature)
+}
+
+/**
+ * Sign a message like personal_sign, through Clef's account_signData.
+ *
+ * @param  message    Message to sign (not hashed)
+ * @return            Signature, error
+ */
+func (r *RemoteSigner) SignText(message []byte) (*Signature, error) {
+  var signature string
+  params := []interface{}{"text/plain", r.address, "0x" + hex.EncodeToString(message)}
+  if err := r.call("account_signData", params, &signature); err != nil {
+    return nil, err
+  }
+  return parseRemoteSignature(signature)
+}
+
+/**
+ * Get the transaction the arguments describe.
+ *
+ * @return    Unsigned transaction, chain id, error
+ */
+func (args *SendTxArgs) ToTx() (*Tx, int64, error) {
+  var tx = Tx{To: args.To, Data: args.Data}
+  var nonce, chainID *big.Int
+  fields := []struct {
+    name string
+    value string
+    dest **big.Int
+    optional bool
+  }{
+    {"gas", args.Gas, &tx.Gas, false},
+    {"gasPrice", args.GasPrice, &tx.GasPrice, true},
+    {"maxFeePerGas", args.MaxFeePerGas, &tx.GasFeeCap, true},
+    {"maxPriorityFeePerGas", args.MaxPriorityFeePerGas, &tx.GasTipCap, true},
+    {"value", args.Value, &tx.Value, true},
+    {"nonce", args.Nonce, &nonce, false},
+    {"chainId", args.ChainId, &chainID, false},
+  }
+  for _, field := range fields {
+    if field.value == "" && field.optional { continue }
+    var ok bool
+    *field.dest, ok = new(big.Int).SetString(field.value, 0)
+    if !ok || (*field.dest).Sign() < 0 {
+      return nil, 0, fmt.Errorf("Invalid %s %q", field.name, field.value)
+    }
+  }
+  if !nonce.IsUint64() || !chainID.IsInt64() {
+    return nil, 0, fmt.Errorf("Nonce or chain id out of range")
+  }
+  if tx.GasPrice == nil && !tx.Dynamic() {
+    return nil, 0, errors.New("Transaction has neither a gas price nor EIP-1559 fees")
+  }
+  tx.Nonce = nonce.Uint64()
+  return &tx, chainID.Int64(), nil
+}
+
+// Make a JSON-RPC call over HTTP or the socket
+func (r *RemoteSigner) call(method string, params []interface{}, result interface{}) (error) {
+  if params == nil { params = []interface{}{} }
+  req, err := json.Marshal(remoteReq{"2.0", atomic.AddInt64(&r.id, 1), method, params})
+  if err != nil { return err }
+  var body []byte
+  if strings.HasPrefix(r.endpoint, "http://") || strings.HasPrefix(r.endpoint, "https://") {
+    body, err = r.post(req)
+  } else {
+    body, err = r.ipc(req)
+  }
+  if err != nil {
+    return fmt.Errorf("Could not reach remote signer: (%w)", err)
+  }
+  var res remoteRes
+  if err := json.Unmarshal(body, &res); err != nil {
+    return fmt.Errorf("Invalid response from remote signer: (%s)", err)
+  }
+  if res.Error != nil {
+    return fmt.Errorf("Remote signer refused %s: %s", method, res.Error.Message)
+  }
+  return json.Unmarshal(res.Result, result)
+}
+
+func (r *RemoteSigner) post(req []byte) ([]byte, error) {
+  client := *httpclient.Client()
+  client.Timeout = REMOTE_SIGNER_TIMEOUT
+  res, err := client.Post(r.endpoint, "application/json", bytes.NewReader(req))
+  if err != nil { return nil, err }
+  defer res.Body.Close()
+  if res.StatusCode != http.StatusOK {
+    return nil, fmt.Errorf("%s", res.Status)
+  }
+  return ioutil.ReadAll(res.Body)
+}
+
+// One request per connection, answered with one line of JSON
+func (r *RemoteSigner) ipc(req []byte) ([]byte, error) {
+  conn, err := net.DialTimeout("unix", r.endpoint, 10*time.Second)
+  if err != nil { return nil, err }
+  defer conn.Close()
+  conn.SetDeadline(time.Now().Add(REMOTE_SIGNER_TIMEOUT))
+  if _, err := conn.Write(append(req, '\n')); err != nil {
+    return nil, err
+  }
+  return bufio.NewReader(conn).ReadBytes('\n')
+}
+
+// 0x-prefixed 65 byte signature, as Clef returns it
+func parseRemoteSignature(signature string) (*Signature, error) {
+  b, err := hex.DecodeString(strings.TrimPrefix(signature, "0x"))
+  if err != nil {
+    return nil, fmt.Errorf("Invalid signature from remote signer %q", signature)
+  }
+  return signatureFromBytes(b)
+}
+
+func quantity(i *big.Int) (string) {
+  if i == nil { return "0x0" }
+  return fmt.Sprintf("0x%x", i)
+}
@@ -9,13 +9,18 @@ import (
   "encoding/hex"
   "math/big"
   "strconv"
+  "strings"
 )
 import "fmt"
 import "github.com/ethereum/go-ethereum/core/types"
 import "github.com/ethereum/go-ethereum/common"
 import "github.com/ethereum/go-ethereum/crypto"
+import "github.com/ethereum/go-ethereum/rlp"
 
 
+// EIP-2718 type byte of EIP-1559 transactions
+const DYNAMIC_FEE_TX_TYPE = 0x02
+
 type ChannelMsg struct {
   MsgHash string `json:"msg_hash"`
   Value string `json:"value"`
@@ -50,75 +55,215 @@ func Ecsign(hash []byte, prv *ecdsa.PrivateKey) (string, error) {
   return hex.EncodeToString(sig), nil
 }
 
+// An unsigned transaction. GasPrice is used for legacy transactions, and
+// GasFeeCap/GasTipCap (both set) make it an EIP-1559 transaction.
+type Tx struct {
+  To string
+  Data string
+  Nonce uint64
+  Value *big.Int
+  Gas *big.Int
+  GasPrice *big.Int
+  GasFeeCap *big.Int
+  GasTipCap *big.Int
+}
+
+/**
+ * Check whether the transaction pays EIP-1559 fees instead of a gas price.
+ */
+func (tx *Tx) Dynamic() (bool) {
+  return tx.GasFeeCap != nil && tx.GasTipCap != nil
+}
+
 /**
- * Convert transaction parameters into a raw transaction string that can be sent
- * to our RPC provider directly.
+ * Get the hash a signer must sign to authorize the transaction.
+ *
+ * @param  tx         Unsigned transaction
+ * @param  chainID    Chain id (net.version from web3)
+ * @return            32 byte hash, error
  */
-func GetRawTx(
-    chainID int64,
-    from string,
-    _to string,
-    data string,
-    nonce uint64,
-    value int64,
-    gasLimit *big.Int,
-    gasPrice *big.Int,
-    privkey *ecdsa.PrivateKey) (string, error) {
-
-    var amount = big.NewInt(value)
-    var bytesto [20]byte
-    _bytesto, _ := hex.DecodeString(_to[2:])
-    copy(bytesto[:], _bytesto)
-    to := common.Address([20]byte(bytesto))
-
-    // Create a new signer with the chain id (this is net.version from web3)
-    signer := types.NewEIP155Signer(big.NewInt(chainID))
-    // Note the recasting of our data string to a geth common data type
-    tx := types.NewTransaction(nonce, to, amount, gasLimit, gasPrice, common.FromHex(data))
-    // Sign the tx with our private key and transform the Transaction object
-    signature, _ := crypto.Sign(tx.SigHash(signer).Bytes(), privkey)
-    signed_tx, _ := tx.WithSignature(signer, signature)
-    // Recast to a "Transactions" object and get the RLP payload (raw transaction)
-    t := types.Transactions{signed_tx}
-    return fmt.Sprintf("0x%x", t.GetRlp(0)), nil
+func TxSigHash(tx *Tx, chainID int64) ([]byte, error) {
+  if tx.Dynamic() {
+    // The signature covers the type byte and the unsigned fields
+    unsigned, err := rlp.EncodeToBytes(dynamicFeeFields(tx, chainID))
+    if err != nil { return nil, err }
+    return Keccak256Hash(append([]byte{DYNAMIC_FEE_TX_TYPE}, unsigned...)), nil
+  }
+  signer := types.NewEIP155Signer(big.NewInt(chainID))
+  return legacyTx(tx).SigHash(signer).Bytes(), nil
+}
+
+/**
+ * Attach a signature of TxSigHash to the transaction and get the raw
+ * transaction string that can be sent to our RPC provider directly.
+ *
+ * @param  tx           Unsigned transaction
+ * @param  chainID      Chain id the signature was made for
+ * @param  signature    Signature of TxSigHash(tx, chainID)
+ * @return              0x-prefixed raw transaction, error
+ */
+func SignedTx(tx *Tx, chainID int64, signature *Signature) (string, error) {
+  // [R || S || V] where V is the y parity (0 or 1)
+  _sig, err := signature.Bytes()
+  if err != nil { return "", err }
+  if tx.Dynamic() {
+    r := new(big.Int).SetBytes(_sig[:32])
+    s := new(big.Int).SetBytes(_sig[32:64])
+    fields := append(dynamicFeeFields(tx, chainID), uint64(_sig[64]), r, s)
+    signed, err := rlp.EncodeToBytes(fields)
+    if err != nil { return "", err }
+    return fmt.Sprintf("0x%x", append([]byte{DYNAMIC_FEE_TX_TYPE}, signed...)), nil
+  }
+  signer := types.NewEIP155Signer(big.NewInt(chainID))
+  signed_tx, err := legacyTx(tx).WithSignature(signer, _sig)
+  if err != nil { return "", err }
+  // Recast to a "Transactions" object and get the RLP payload (raw transaction)
+  t := types.Transactions{signed_tx}
+  return fmt.Sprintf("0x%x", t.GetRlp(0)), nil
+}
+
+// Note the recasting of our data string to a geth common data type
+func legacyTx(tx *Tx) (*types.Transaction) {
+  var amount = new(big.Int)
+  if tx.Value != nil { amount.Set(tx.Value) }
+  to := common.HexToAddress(tx.To)
+  return types.NewTransaction(tx.Nonce, to, amount, tx.Gas, tx.GasPrice, common.FromHex(tx.Data))
+}
+
+// Unsigned fields of an EIP-1559 (type 2) transaction, in RLP order
+func dynamicFeeFields(tx *Tx, chainID int64) ([]interface{}) {
+  var amount = new(big.Int)
+  if tx.Value != nil { amount.Set(tx.Value) }
+  return []interface{}{
+    big.NewInt(chainID),
+    tx.Nonce,
+    tx.GasTipCap,
+    tx.GasFeeCap,
+    tx.Gas,
+    common.HexToAddress(tx.To),
+    amount,
+    common.FromHex(tx.Data),
+    []interface{}{},          // access list
+  }
 }
 
 
+// A signer that signs channel payments itself, e.g. a remote signer that
+// only pays into one channel
+type PaymentSigner interface {
+  SignPaymentData(channel_id string, amount string) (*Signature, error)
+}
+
 /**
  * Sign a message that will be sent to a payment channel.
  *
  * @param  channel_id    0x-prefixed bytes32 id of payment channel
  * @param  amount        amount to send (hex string)
- * @param  pkey          Private key of signer
- * @return               Message, signature, and amount
+ * @param  signer        Signer of the channel's owner
+ * @return               Message, signature, and amount, error
  */
-func SignPayment(channel_id string, amount string, pkey string) (*ChannelMsg) {
+func SignPayment(channel_id string, amount string, signer Signer) (*ChannelMsg, error) {
   var resp = ChannelMsg{}
 
   // Form the message to be signed sha3(channel_id, value)
-  var str = zfill(channel_id) + zfill(amount)
-  msg, _ := hex.DecodeString(str)
-  msg_hash := Keccak256Hash(msg)
-
-  // Instantiate a private key oject for signature
-  privkey, _ := crypto.HexToECDSA(pkey)
-
-  // Sign the message and deconstruct the signature
-  sig, _ := Ecsign(msg_hash, privkey)
-  resp.R = sig[:64]
-  resp.S = sig[64:128]
-  v, _ := strconv.ParseUint(sig[129:], 0, 64)
-  resp.V = fmt.Sprintf("%x", v + 27)
+  msg_hash, err := paymentHash(channel_id, amount)
+  if err != nil { return nil, err }
+
+  // Sign the message and deconstruct the signature. Signers that check what
+  // they pay are given the payment itself rather than its hash.
+  var sig *Signature
+  if payer, ok := signer.(PaymentSigner); ok {
+    sig, err = payer.SignPaymentData(channel_id, amount)
+  } else {
+    sig, err = signer.SignHash(msg_hash)
+  }
+  if err != nil { return nil, err }
+  resp.R = sig.R
+  resp.S = sig.S
+  resp.V = sig.V
   resp.MsgHash = fmt.Sprintf("%x",msg_hash)
   resp.Value = amount
-  return &resp
+  return &resp, nil
+}
+
+
+/**
+ * Check that a payment message is for the channel and amount it claims, and
+ * that it was signed by the expected address.
+ *
+ * @param  msg           Signed payment message
+ * @param  channel_id    0x-prefixed bytes32 id of payment channel
+ * @param  signer        Address that should have signed the message
+ * @return               nil if the message checks out, error otherwise
+ */
+func VerifyPayment(msg *ChannelMsg, channel_id string, signer string) (error) {
+  msg_hash, err := paymentHash(channel_id, msg.Value)
+  if err != nil { return err }
+  if !strings.EqualFold(strings.TrimPrefix(msg.MsgHash, "0x"), hex.EncodeToString(msg_hash)) {
+    return fmt.Errorf("Payment hash %s does not match channel %s and value %s", msg.MsgHash, channel_id, msg.Value)
+  }
+  recovered, err := RecoverSigner(msg.MsgHash, msg.V, msg.R, msg.S)
+  if err != nil { return err }
+  if !strings.EqualFold(recovered, signer) {
+    return fmt.Errorf("Payment signed by %s, expected %s", recovered, signer)
+  }
+  return nil
+}
+
+
+/**
+ * Recover the address that signed a hash (ecrecover).
+ *
+ * @param  msg_hash    Hex hash that was signed
+ * @param  v           Hex recovery id: 1b/1c, or 0/1
+ * @param  r           Hex R value of the signature
+ * @param  s           Hex S value of the signature
+ * @return             0x-prefixed address of the signer, error
+ */
+func RecoverSigner(msg_hash string, v string, r string, s string) (string, error) {
+  hash, err := hex.DecodeString(strings.TrimPrefix(msg_hash, "0x"))
+  if err != nil || len(hash) != 32 {
+    return "", fmt.Errorf("Invalid message hash %q", msg_hash)
+  }
+  _r, err := hex.DecodeString(strings.TrimPrefix(r, "0x"))
+  if err != nil || len(_r) != 32 {
+    return "", fmt.Errorf("Invalid signature R %q", r)
+  }
+  _s, err := hex.DecodeString(strings.TrimPrefix(s, "0x"))
+  if err != nil || len(_s) != 32 {
+    return "", fmt.Errorf("Invalid signature S %q", s)
+  }
+  _v, err := strconv.ParseUint(strings.TrimPrefix(v, "0x"), 16, 8)
+  if err != nil {
+    return "", fmt.Errorf("Invalid signature V %q", v)
+  }
+  if _v >= 27 { _v -= 27 }
+  if _v > 1 {
+    return "", fmt.Errorf("Invalid signature V %q", v)
+  }
+  sig := append(append(_r, _s...), byte(_v))
+  pub, err := crypto.SigToPub(hash, sig)
+  if err != nil {
+    return "", fmt.Errorf("Could not recover signer: (%s)", err)
+  }
+  return strings.ToLower(crypto.PubkeyToAddress(*pub).Hex()), nil
+}
+
+
+// sha3(channel_id, value), the message a payment signs
+func paymentHash(channel_id string, amount string) ([]byte, error) {
+  msg, err := hex.DecodeString(zfill(channel_id) + zfill(amount))
+  if err != nil || len(msg) != 64 {
+    return nil, fmt.Errorf("Invalid channel id %q or amount %q", channel_id, amount)
+  }
+  return Keccak256Hash(msg), nil
 }
 
 
 // Same as rpc.Zfill, but rpc import isn't allowed in this module
 func zfill(s string) (string) {
   // Cut off any rouge 0x prefixes
-  if (s[:2] == "0x") { s = s[2:]}
+  s = strings.TrimPrefix(s, "0x")
   var pad = ""
   for i := 0; i < (64-len(s)); i++ {
 		pad += "0"
@@ -0,0 +1,105 @@
+package sig
+
+import (
+  "encoding/hex"
+  "math/big"
+  "testing"
+)
+
+// web3.js' documented example key, address 0x2c7536e3605d9c16a7a3d7b1898e529396a65c23
+const TEST_KEY = "4c0883a69102937d6231471b5dbb6204fe5129617082792ae468d01a3f362318"
+const TEST_ADDR = "0x2c7536e3605d9c16a7a3d7b1898e529396a65c23"
+
+func gwei(n int64) (*big.Int) {
+  return new(big.Int).Mul(big.NewInt(n), big.NewInt(1000000000))
+}
+
+// EIP-1559 transactions signed by TEST_KEY with an independent implementation
+// (umbracle/ethgo on btcec). Both sides sign with RFC 6979 nonces, so the
+// signatures must match byte for byte.
+var dynamicFeeTxs = []struct {
+  name string
+  tx Tx
+  chainID int64
+  r, s string
+  raw string
+}{
+  {
+    name: "ether transfer",
+    tx: Tx{
+      To: "0x3535353535353535353535353535353535353535",
+      Nonce: 9,
+      Value: new(big.Int).Mul(gwei(1), big.NewInt(1000000000)),
+      Gas: big.NewInt(21000),
+      GasTipCap: gwei(2),
+      GasFeeCap: gwei(30),
+    },
+    chainID: 1,
+    r: "de7bab948e0030460a300da80551186fdb77b89b1c3d4483d4553170e5b00fdf",
+    s: "41c507652276f75fb39bce1065455455925a212ff1dfa878b410be55cb3646ab",
+    raw: "0x02f873010984773594008506fc23ac00825208943535353535353535353535353535353535353535880de0b6b3a764000080c080a0de7bab948e0030460a300da80551186fdb77b89b1c3d4483d4553170e5b00fdfa041c507652276f75fb39bce1065455455925a212ff1dfa878b410be55cb3646ab",
+  },
+  {
+    name: "token approval",
+    tx: Tx{
+      To: "0x6b175474e89094c44da98b954eedeac495271d0f",
+      Data: "0x095ea7b30000000000000000000000001111111111111111111111111111111111111111000000000000000000000000000000000000000000000000000000001dcd6500",
+      Nonce: 0,
+      Gas: big.NewInt(60000),
+      GasTipCap: gwei(1),
+      GasFeeCap: gwei(50),
+    },
+    chainID: 3,
+    r: "9094e90c2cab195d7a5e8edb9396ece5fb1475910dc93485fdeb4ab0c118f37e",
+    s: "697dd60d792fd5a8f7300cd8a86719816a23cafc0ff44be4caadd9be21c6171b",
+    raw: "0x02f8b00380843b9aca00850ba43b740082ea60946b175474e89094c44da98b954eedeac495271d0f80b844095ea7b30000000000000000000000001111111111111111111111111111111111111111000000000000000000000000000000000000000000000000000000001dcd6500c080a09094e90c2cab195d7a5e8edb9396ece5fb1475910dc93485fdeb4ab0c118f37ea0697dd60d792fd5a8f7300cd8a86719816a23cafc0ff44be4caadd9be21c6171b",
+  },
+}
+
+func TestTxSigHashDynamicFee(t *testing.T) {
+  for _, c := range dynamicFeeTxs {
+    hash, err := TxSigHash(&c.tx, c.chainID)
+    if err != nil {
+      t.Fatalf("%s: %s", c.name, err)
+    }
+    // A different hash would recover a different address
+    signer, err := RecoverSigner(hex.EncodeToString(hash), "0", c.r, c.s)
+    if err != nil {
+      t.Fatalf("%s: %s", c.name, err)
+    }
+    if signer != TEST_ADDR {
+      t.Errorf("%s: signature recovers %s from the sig hash, want %s", c.name, signer, TEST_ADDR)
+    }
+  }
+}
+
+func TestSignedTxDynamicFee(t *testing.T) {
+  for _, c := range dynamicFeeTxs {
+    raw, err := SignedTx(&c.tx, c.chainID, &Signature{V: "00", R: c.r, S: c.s})
+    if err != nil {
+      t.Fatalf("%s: %s", c.name, err)
+    }
+    if raw != c.raw {
+      t.Errorf("%s: got raw tx\n%s\nwant\n%s", c.name, raw, c.raw)
+    }
+  }
+}
+
+func TestKeySignerSignTxDynamicFee(t *testing.T) {
+  signer, err := ParseKeySigner(TEST_KEY)
+  if err != nil {
+    t.Fatal(err)
+  }
+  if signer.Address() != TEST_ADDR {
+    t.Fatalf("Address() = %s, want %s", signer.Address(), TEST_ADDR)
+  }
+  for _, c := range dynamicFeeTxs {
+    raw, err := signer.SignTx(&c.tx, c.chainID)
+    if err != nil {
+      t.Fatalf("%s: %s", c.name, err)
+    }
+    if raw != c.raw {
+      t.Errorf("%s: got raw tx\n%s\nwant\n%s", c.name, raw, c.raw)
+    }
+  }
+}
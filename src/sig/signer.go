@@ -0,0 +1,93 @@
+package sig
+
+import (
+  "crypto/ecdsa"
+  "encoding/hex"
+  "fmt"
+  "strings"
+)
+import "keystore"
+import "github.com/ethereum/go-ethereum/crypto"
+
+// Anything that can sign for an address: a key in memory or a remote
+// signer. Callers never see the private key.
+type Signer interface {
+  // 0x-prefixed lowercase address of the key
+  Address() (string)
+  // Sign a 32 byte hash
+  SignHash(hash []byte) (*Signature, error)
+  // Sign a transaction and get the raw transaction to send
+  SignTx(tx *Tx, chainID int64) (string, error)
+}
+
+// Signs with a private key held in memory
+type KeySigner struct {
+  key *ecdsa.PrivateKey
+  address string
+}
+
+/**
+ * Make a signer from a raw private key.
+ *
+ * @param  key    32 byte private key
+ * @return        Signer, error
+ */
+func NewKeySigner(key []byte) (*KeySigner, error) {
+  privkey, err := crypto.ToECDSA(key)
+  if err != nil { return nil, fmt.Errorf("Could not parse private key: (%s)", err) }
+  address := strings.ToLower(crypto.PubkeyToAddress(privkey.PublicKey).Hex())
+  return &KeySigner{key: privkey, address: address}, nil
+}
+
+/**
+ * Same as NewKeySigner, for a hex private key, e.g. from setup_keys.toml.
+ */
+func ParseKeySigner(pkey string) (*KeySigner, error) {
+  key, err := hex.DecodeString(strings.TrimPrefix(pkey, "0x"))
+  if err != nil { return nil, fmt.Errorf("Could not parse private key: (%s)", err) }
+  defer zero(key)
+  return NewKeySigner(key)
+}
+
+func (k *KeySigner) Address() (string) {
+  return k.address
+}
+
+func (k *KeySigner) SignHash(hash []byte) (*Signature, error) {
+  _sig, err := crypto.Sign(hash, k.key)
+  if err != nil { return nil, fmt.Errorf("Could not sign with private key: (%s)", err) }
+  return signatureFromBytes(_sig)
+}
+
+func (k *KeySigner) SignTx(tx *Tx, chainID int64) (string, error) {
+  return signTxHash(k, tx, chainID)
+}
+
+/**
+ * Make a signer from a keystore file. The key is decrypted once and kept in
+ * memory, like NewKeySigner.
+ *
+ * @param  path          Path of the keystore file
+ * @param  passphrase    Passphrase it was encrypted with
+ * @return               Signer, error
+ */
+func LoadKeySigner(path string, passphrase string) (*KeySigner, error) {
+  key, err := keystore.Load(path, passphrase)
+  if err != nil { return nil, err }
+  defer zero(key)
+  return NewKeySigner(key)
+}
+
+// Sign a transaction by signing its hash, for signers that hold the key
+func signTxHash(signer Signer, tx *Tx, chainID int64) (string, error) {
+  hash, err := TxSigHash(tx, chainID)
+  if err != nil { return "", err }
+  signature, err := signer.SignHash(hash)
+  if err != nil { return "", err }
+  return SignedTx(tx, chainID, signature)
+}
+
+func zero(b []byte) {
+  for i := range b { b[i] = 0 }
+}
+
@@ -0,0 +1,261 @@
+package sig
+
+import (
+  "abi"
+  "encoding/hex"
+  "encoding/json"
+  "fmt"
+  "math/big"
+  "reflect"
+  "regexp"
+  "sort"
+  "strings"
+)
+
+type TypedDataField struct {
+  Name string `json:"name"`
+  Type string `json:"type"`
+}
+
+// Fields left empty are not part of the domain
+type TypedDataDomain struct {
+  Name string `json:"name,omitempty"`
+  Version string `json:"version,omitempty"`
+  ChainId *big.Int `json:"chainId,omitempty"`
+  VerifyingContract string `json:"verifyingContract,omitempty"`
+  Salt string `json:"salt,omitempty"`
+}
+
+// EIP-712 typed structured data, in the form eth_signTypedData_v4 takes
+type TypedData struct {
+  Types map[string][]TypedDataField `json:"types"`
+  PrimaryType string `json:"primaryType"`
+  Domain TypedDataDomain `json:"domain"`
+  Message map[string]interface{} `json:"message"`
+}
+
+// Array suffix of a type, e.g. "[]" or "[3]"
+var arraySuffix = regexp.MustCompile(`\[\d*\]$`)
+
+/**
+ * Get the hash to sign: keccak256("\x19\x01" || domainSeparator || hashStruct(message))
+ */
+func (td *TypedData) Hash() ([]byte, error) {
+  domain, err := td.DomainSeparator()
+  if err != nil { return nil, err }
+  message, err := td.HashStruct(td.PrimaryType, td.Message)
+  if err != nil { return nil, err }
+  data := append([]byte{0x19, 0x01}, domain...)
+  return Keccak256Hash(append(data, message...)), nil
+}
+
+/**
+ * Get the domain separator, which ties signatures to one application, chain
+ * and contract so they can't be replayed elsewhere.
+ */
+func (td *TypedData) DomainSeparator() ([]byte, error) {
+  var fields []TypedDataField
+  values := map[string]interface{}{}
+  if td.Domain.Name != "" {
+    fields = append(fields, TypedDataField{"name", "string"})
+    values["name"] = td.Domain.Name
+  }
+  if td.Domain.Version != "" {
+    fields = append(fields, TypedDataField{"version", "string"})
+    values["version"] = td.Domain.Version
+  }
+  if td.Domain.ChainId != nil {
+    fields = append(fields, TypedDataField{"chainId", "uint256"})
+    values["chainId"] = td.Domain.ChainId
+  }
+  if td.Domain.VerifyingContract != "" {
+    fields = append(fields, TypedDataField{"verifyingContract", "address"})
+    values["verifyingContract"] = td.Domain.VerifyingContract
+  }
+  if td.Domain.Salt != "" {
+    fields = append(fields, TypedDataField{"salt", "bytes32"})
+    values["salt"] = td.Domain.Salt
+  }
+  // The domain type is implied by which fields are set
+  domain := TypedData{Types: map[string][]TypedDataField{"EIP712Domain": fields}}
+  for name, t := range td.Types {
+    if name != "EIP712Domain" { domain.Types[name] = t }
+  }
+  return domain.HashStruct("EIP712Domain", values)
+}
+
+/**
+ * Hash a struct: keccak256(typeHash || encodeData(data))
+ *
+ * @param  primaryType    Name of the struct type
+ * @param  data           Field values, keyed by name
+ * @return                Hash, error
+ */
+func (td *TypedData) HashStruct(primaryType string, data map[string]interface{}) ([]byte, error) {
+  fields, ok := td.Types[primaryType]
+  if !ok {
+    return nil, fmt.Errorf("Unknown type %q", primaryType)
+  }
+  enc := td.TypeHash(primaryType)
+  for _, field := range fields {
+    value, ok := data[field.Name]
+    if !ok {
+      return nil, fmt.Errorf("Missing %s.%s", primaryType, field.Name)
+    }
+    word, err := td.encodeValue(field.Type, value)
+    if err != nil {
+      return nil, fmt.Errorf("Invalid %s.%s (%w)", primaryType, field.Name, err)
+    }
+    enc = append(enc, word...)
+  }
+  return Keccak256Hash(enc), nil
+}
+
+/**
+ * Get keccak256 of the type's encoding, e.g.
+ * keccak256("Payment(bytes32 channelId,uint256 value)")
+ */
+func (td *TypedData) TypeHash(primaryType string) ([]byte) {
+  return Keccak256Hash([]byte(td.EncodeType(primaryType)))
+}
+
+/**
+ * Encode a type and the struct types it references, which follow it in
+ * alphabetical order
+ */
+func (td *TypedData) EncodeType(primaryType string) (string) {
+  deps := td.dependencies(primaryType, map[string]bool{})
+  sort.Strings(deps)
+  var b strings.Builder
+  b.WriteString(td.encodeOne(primaryType))
+  for _, dep := range deps {
+    if dep != primaryType {
+      b.WriteString(td.encodeOne(dep))
+    }
+  }
+  return b.String()
+}
+
+func (td *TypedData) encodeOne(name string) (string) {
+  members := make([]string, len(td.Types[name]))
+  for i, field := range td.Types[name] {
+    members[i] = field.Type + " " + field.Name
+  }
+  return name + "(" + strings.Join(members, ",") + ")"
+}
+
+// Struct types referenced by a type, including itself
+func (td *TypedData) dependencies(name string, found map[string]bool) ([]string) {
+  name = baseType(name)
+  if found[name] {
+    return nil
+  }
+  if _, ok := td.Types[name]; !ok {
+    return nil
+  }
+  found[name] = true
+  deps := []string{name}
+  for _, field := range td.Types[name] {
+    deps = append(deps, td.dependencies(field.Type, found)...)
+  }
+  return deps
+}
+
+// Encode a value as a single 32 byte word
+func (td *TypedData) encodeValue(typ string, value interface{}) ([]byte, error) {
+  // Arrays hash the concatenated encodings of their elements
+  if arraySuffix.MatchString(typ) {
+    elem := arraySuffix.ReplaceAllString(typ, "")
+    rv := reflect.ValueOf(value)
+    if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+      return nil, fmt.Errorf("Expected an array for %s, got %T", typ, value)
+    }
+    var enc []byte
+    for i := 0; i < rv.Len(); i++ {
+      word, err := td.encodeValue(elem, rv.Index(i).Interface())
+      if err != nil { return nil, err }
+      enc = append(enc, word...)
+    }
+    return Keccak256Hash(enc), nil
+  }
+  // Nested structs are hashed
+  if _, ok := td.Types[typ]; ok {
+    data, ok := value.(map[string]interface{})
+    if !ok {
+      return nil, fmt.Errorf("Expected an object for %s, got %T", typ, value)
+    }
+    return td.HashStruct(typ, data)
+  }
+  // Dynamic values are hashed
+  switch typ {
+  case "string":
+    s, ok := value.(string)
+    if !ok { return nil, fmt.Errorf("Expected a string, got %T", value) }
+    return Keccak256Hash([]byte(s)), nil
+  case "bytes":
+    b, err := toBytes(value)
+    if err != nil { return nil, err }
+    return Keccak256Hash(b), nil
+  }
+  // Atomic values are ABI encoded
+  t, err := abi.ParseType(typ)
+  if err != nil { return nil, err }
+  return abi.Encode([]abi.Type{t}, []interface{}{normalize(value)})
+}
+
+// Turn values decoded from JSON into ones the abi package takes
+func normalize(value interface{}) (interface{}) {
+  switch v := value.(type) {
+  case json.Number:
+    return v.String()
+  case float64:
+    i, _ := new(big.Float).SetFloat64(v).Int(nil)
+    return i
+  }
+  return value
+}
+
+func toBytes(value interface{}) ([]byte, error) {
+  switch v := value.(type) {
+  case []byte:
+    return v, nil
+  case string:
+    b, err := hex.DecodeString(strings.TrimPrefix(v, "0x"))
+    if err != nil { return nil, fmt.Errorf("Invalid hex bytes %q", v) }
+    return b, nil
+  }
+  return nil, fmt.Errorf("Expected bytes, got %T", value)
+}
+
+func baseType(typ string) (string) {
+  for arraySuffix.MatchString(typ) {
+    typ = arraySuffix.ReplaceAllString(typ, "")
+  }
+  return typ
+}
+
+/**
+ * Sign typed data like eth_signTypedData_v4.
+ *
+ * @param  td      Typed data
+ * @param  signer    Signer of the data
+ * @return           Signature, error
+ */
+func SignTypedData(td *TypedData, signer Signer) (*Signature, error) {
+  hash, err := td.Hash()
+  if err != nil { return nil, err }
+  return signer.SignHash(hash)
+}
+
+/**
+ * Recover the address that signed typed data.
+ *
+ * @param  td     Typed data that was signed
+ * @param  sig    Signature of the data
+ * @return        0x-prefixed address of the signer, error
+ */
+func RecoverTypedData(td *TypedData, sig *Signature) (string, error) {
+  hash, err := td.Hash()
+  if err != nil { return "", err }
+  return RecoverSigner(hex.EncodeToString(hash), sig.V, sig.R, sig.S)
+}
@@ -0,0 +1,178 @@
+package sig
+
+import (
+  "encoding/hex"
+  "math/big"
+  "testing"
+)
+
+// keccak256("cow"), the key of the "Cow" account in the EIP-712 examples
+const COW_KEY = "c85ef7d79691fe79573b1a7064c19c1a9819ebdbd1faaab1a8ec92344438aaf4"
+const COW_ADDR = "0xcd2a3d9f938e13cd947ec05abc7fe734df8dd826"
+
+var mailDomain = TypedDataDomain{
+  Name: "Ether Mail",
+  Version: "1",
+  ChainId: big.NewInt(1),
+  VerifyingContract: "0xCcCCccccCCCCcCCCCCCcCcCccCcCCCcCcccccccC",
+}
+
+// The example from EIP-712 (assets/eip-712/Example.js)
+func mailExample() (*TypedData) {
+  return &TypedData{
+    Types: map[string][]TypedDataField{
+      "Person": {{"name", "string"}, {"wallet", "address"}},
+      "Mail": {{"from", "Person"}, {"to", "Person"}, {"contents", "string"}},
+    },
+    PrimaryType: "Mail",
+    Domain: mailDomain,
+    Message: map[string]interface{}{
+      "from": map[string]interface{}{"name": "Cow", "wallet": "0xCD2a3d9F938E13CD947Ec05AbC7FE734Df8DD826"},
+      "to": map[string]interface{}{"name": "Bob", "wallet": "0xbBbBBBBbbBBBbbbBbbBbbbbBBbBbbbbBbBbbBBbB"},
+      "contents": "Hello, Bob!",
+    },
+  }
+}
+
+// The eth_signTypedData_v4 example from MetaMask's eth-sig-util, which adds
+// arrays of structs and of addresses to the Mail example
+func mailArraysExample() (*TypedData) {
+  return &TypedData{
+    Types: map[string][]TypedDataField{
+      "Person": {{"name", "string"}, {"wallets", "address[]"}},
+      "Mail": {{"from", "Person"}, {"to", "Person[]"}, {"contents", "string"}},
+    },
+    PrimaryType: "Mail",
+    Domain: mailDomain,
+    Message: map[string]interface{}{
+      "from": map[string]interface{}{
+        "name": "Cow",
+        "wallets": []interface{}{
+          "0xCD2a3d9F938E13CD947Ec05AbC7FE734Df8DD826",
+          "0xDeaDbeefdEAdbeefdEadbEEFdeadbeEFdEaDbeeF",
+        },
+      },
+      "to": []interface{}{
+        map[string]interface{}{
+          "name": "Bob",
+          "wallets": []string{
+            "0xbBbBBBBbbBBBbbbBbbBbbbbBBbBbbbbBbBbbBBbB",
+            "0xB0BdaBea57B0BDABeA57b0bdABEA57b0BDabEa57",
+            "0xB0B0b0b0b0b0B000000000000000000000000000",
+          },
+        },
+      },
+      "contents": "Hello, Bob!",
+    },
+  }
+}
+
+func TestTypedDataMail(t *testing.T) {
+  td := mailExample()
+  if enc := td.EncodeType("Mail"); enc != "Mail(Person from,Person to,string contents)Person(string name,address wallet)" {
+    t.Errorf("EncodeType(Mail) = %s", enc)
+  }
+  if h := hex.EncodeToString(td.TypeHash("Mail")); h != "a0cedeb2dc280ba39b857546d74f5549c3a1d7bdc2dd96bf881f76108e23dac2" {
+    t.Errorf("TypeHash(Mail) = %s", h)
+  }
+  domain, err := td.DomainSeparator()
+  if err != nil {
+    t.Fatal(err)
+  }
+  if h := hex.EncodeToString(domain); h != "f2cee375fa42b42143804025fc449deafd50cc031ca257e0b194a650a912090f" {
+    t.Errorf("DomainSeparator() = %s", h)
+  }
+  message, err := td.HashStruct("Mail", td.Message)
+  if err != nil {
+    t.Fatal(err)
+  }
+  if h := hex.EncodeToString(message); h != "c52c0ee5d84264471806290a3f2c4cecfc5490626bf912d01f240d7a274b371e" {
+    t.Errorf("HashStruct(Mail) = %s", h)
+  }
+  hash, err := td.Hash()
+  if err != nil {
+    t.Fatal(err)
+  }
+  if h := hex.EncodeToString(hash); h != "be609aee343fb3c4b28e1df9e632fca64fcfaede20f02e86244efddf30957bd2" {
+    t.Errorf("Hash() = %s", h)
+  }
+}
+
+func TestTypedDataArrays(t *testing.T) {
+  td := mailArraysExample()
+  if enc := td.EncodeType("Mail"); enc != "Mail(Person from,Person[] to,string contents)Person(string name,address[] wallets)" {
+    t.Errorf("EncodeType(Mail) = %s", enc)
+  }
+  message, err := td.HashStruct("Mail", td.Message)
+  if err != nil {
+    t.Fatal(err)
+  }
+  if h := hex.EncodeToString(message); h != "eb4221181ff3f1a83ea7313993ca9218496e424604ba9492bb4052c03d5c3df8" {
+    t.Errorf("HashStruct(Mail) = %s", h)
+  }
+  hash, err := td.Hash()
+  if err != nil {
+    t.Fatal(err)
+  }
+  if h := hex.EncodeToString(hash); h != "a85c2e2b118698e88db68a8105b794a8cc7cec074e89ef991cb4f5f533819cc2" {
+    t.Errorf("Hash() = %s", h)
+  }
+}
+
+// Referenced types follow the primary type sorted by name, however deeply
+// they are nested, and each appears once
+func TestEncodeTypeOrder(t *testing.T) {
+  td := &TypedData{Types: map[string][]TypedDataField{
+    "Order": {{"zebra", "Zebra"}, {"assets", "Asset[2]"}, {"owner", "address"}},
+    "Zebra": {{"stripes", "uint8"}},
+    "Asset": {{"mid", "Mid[]"}, {"zebra", "Zebra"}},
+    "Mid": {{"x", "uint256"}},
+  }}
+  want := "Order(Zebra zebra,Asset[2] assets,address owner)Asset(Mid[] mid,Zebra zebra)Mid(uint256 x)Zebra(uint8 stripes)"
+  if enc := td.EncodeType("Order"); enc != want {
+    t.Errorf("EncodeType(Order) = %s, want %s", enc, want)
+  }
+  if enc := td.EncodeType("Zebra"); enc != "Zebra(uint8 stripes)" {
+    t.Errorf("EncodeType(Zebra) = %s", enc)
+  }
+}
+
+func TestSignTypedData(t *testing.T) {
+  signer, err := ParseKeySigner(COW_KEY)
+  if err != nil {
+    t.Fatal(err)
+  }
+  var cases = []struct {
+    td *TypedData
+    r, s, v string
+  }{
+    {
+      mailExample(),
+      "4355c47d63924e8a72e509b65029052eb6c299d53a04e167c5775fd466751c9d",
+      "07299936d304c153f6443dfa05f40ff007d72911b6f72307f996231605b91562",
+      "1c",
+    },
+    {
+      mailArraysExample(),
+      "65cbd956f2fae28a601bebc9b906cea0191744bd4c4247bcd27cd08f8eb6b71c",
+      "78efdf7a31dc9abee78f492292721f362d296cf86b4538e07b51303b67f74906",
+      "1b",
+    },
+  }
+  for _, c := range cases {
+    sig, err := SignTypedData(c.td, signer)
+    if err != nil {
+      t.Fatal(err)
+    }
+    if sig.R != c.r || sig.S != c.s || sig.V != c.v {
+      t.Errorf("Signed %s as %+v", c.td.PrimaryType, *sig)
+    }
+    addr, err := RecoverTypedData(c.td, sig)
+    if err != nil {
+      t.Fatal(err)
+    }
+    if addr != COW_ADDR {
+      t.Errorf("Recovered %s, want %s", addr, COW_ADDR)
+    }
+  }
+}
@@ -0,0 +1,144 @@
+package signer
+
+import (
+  "contracts"
+  "errors"
+  "fmt"
+  "math/big"
+  "sig"
+  "strings"
+)
+
+// What the daemon agrees to sign. Anything else is refused.
+type Rules struct {
+  ChannelId string          // Only channel payments into this channel
+  ChannelContract string    // Only approvals to, and channels opened on, this contract
+  Token string              // Only approvals of, and channels for, this token
+  Hub string                // Only channels opened to this recipient
+  AllowText bool            // Sign personal_sign messages, e.g. to log in to the hub
+  ChainId int64             // Only transactions for this chain
+  MaxGas *big.Int           // Highest gas limit of a transaction
+  MaxGasPrice *big.Int      // Highest gas price, or max fee per gas of EIP-1559 txs (wei)
+  MaxFee *big.Int           // Most a transaction may spend on fees (gas * price, wei)
+}
+
+/**
+ * Check that the rules are complete enough to enforce.
+ */
+func (r *Rules) Validate() (error) {
+  if len(strings.TrimPrefix(r.ChannelId, "0x")) != 64 {
+    return fmt.Errorf("Invalid channel id %q", r.ChannelId)
+  }
+  if !isAddress(r.ChannelContract) {
+    return fmt.Errorf("Invalid channel contract %q", r.ChannelContract)
+  }
+  if !isAddress(r.Token) {
+    return fmt.Errorf("Invalid token %q", r.Token)
+  }
+  if !isAddress(r.Hub) {
+    return fmt.Errorf("Invalid hub address %q", r.Hub)
+  }
+  if r.ChainId <= 0 {
+    return fmt.Errorf("Invalid chain id %d", r.ChainId)
+  }
+  limits := []struct {
+    name string
+    value *big.Int
+  }{
+    {"max_gas", r.MaxGas},
+    {"max_gas_price", r.MaxGasPrice},
+    {"max_fee", r.MaxFee},
+  }
+  for _, limit := range limits {
+    if limit.value == nil || limit.value.Sign() <= 0 {
+      return fmt.Errorf("No %s set", limit.name)
+    }
+  }
+  return nil
+}
+
+/**
+ * Check a transaction. Only two are allowed: an approval of the token with the
+ * channel contract as spender, and opening a channel for the token to the hub
+ * on the channel contract. Neither may send ether, and both must be for the
+ * configured chain and within the gas and fee limits.
+ *
+ * @param tx         Transaction to be signed
+ * @param chainID    Chain id it would be signed for
+ * @return           nil if it may be signed, error otherwise
+ */
+func (r *Rules) CheckTx(tx *sig.Tx, chainID int64) (error) {
+  if chainID != r.ChainId {
+    return fmt.Errorf("Transactions may only be signed for chain %d, not %d", r.ChainId, chainID)
+  }
+  if tx.Value != nil && tx.Value.Sign() != 0 {
+    return errors.New("Transactions may not send ether")
+  }
+  if err := r.checkFees(tx); err != nil {
+    return err
+  }
+  if strings.EqualFold(tx.To, r.ChannelContract) {
+    args, err := contracts.ChannelManagerABI.OpenChannel.UnpackInput(tx.Data)
+    if err != nil {
+      return fmt.Errorf("Only openChannel may be called on the channel contract (%s)", err)
+    }
+    if !strings.EqualFold(args[0].(string), r.Token) {
+      return fmt.Errorf("Channels may only be opened for token %s", r.Token)
+    }
+    if !strings.EqualFold(args[1].(string), r.Hub) {
+      return fmt.Errorf("Channels may only be opened to %s, not %s", r.Hub, args[1])
+    }
+    return nil
+  }
+  if !strings.EqualFold(tx.To, r.Token) {
+    return fmt.Errorf("Transactions to %s are not allowed", tx.To)
+  }
+  args, err := contracts.ERC20ABI.Approve.UnpackInput(tx.Data)
+  if err != nil {
+    return fmt.Errorf("Only approvals may be sent to %s (%s)", tx.To, err)
+  }
+  if !strings.EqualFold(args[0].(string), r.ChannelContract) {
+    return fmt.Errorf("Approvals may only be given to the channel contract %s, not %s", r.ChannelContract, args[0])
+  }
+  return nil
+}
+
+// Check the gas limit and what the transaction could spend on fees. EIP-1559
+// transactions may pay up to their max fee per gas.
+func (r *Rules) checkFees(tx *sig.Tx) (error) {
+  if tx.Gas == nil || tx.Gas.Cmp(r.MaxGas) > 0 {
+    return fmt.Errorf("Gas limit %s is above the maximum of %s", tx.Gas, r.MaxGas)
+  }
+  price := tx.GasPrice
+  if tx.Dynamic() {
+    price = tx.GasFeeCap
+    if tx.GasTipCap.Cmp(tx.GasFeeCap) > 0 {
+      return errors.New("Priority fee is above the max fee per gas")
+    }
+  }
+  if price == nil || price.Cmp(r.MaxGasPrice) > 0 {
+    return fmt.Errorf("Gas price %s is above the maximum of %s", price, r.MaxGasPrice)
+  }
+  if fee := new(big.Int).Mul(tx.Gas, price); fee.Cmp(r.MaxFee) > 0 {
+    return fmt.Errorf("Fee of up to %s wei is above the maximum of %s", fee, r.MaxFee)
+  }
+  return nil
+}
+
+/**
+ * Check a channel payment.
+ *
+ * @param channel_id    0x-prefixed bytes32 id of payment channel
+ * @param amount        amount to send (hex string)
+ * @return              nil if it may be signed, error otherwise
+ */
+func (r *Rules) CheckPayment(channel_id string, amount string) (error) {
+  if !strings.EqualFold(strings.TrimPrefix(channel_id, "0x"), strings.TrimPrefix(r.ChannelId, "0x")) {
+    return fmt.Errorf("Payments may only be made into channel %s, not %s", r.ChannelId, channel_id)
+  }
+  return nil
+}
+
+func isAddress(s string) (bool) {
+  return strings.HasPrefix(s, "0x") && len(s) == 42
+}
@@ -0,0 +1,170 @@
+package signer
+
+import (
+  "contracts"
+  "math/big"
+  "sig"
+  "strings"
+  "testing"
+)
+
+const (
+  CHANNEL_ID = "0x1111111111111111111111111111111111111111111111111111111111111111"
+  CHANNEL_CONTRACT = "0x2222222222222222222222222222222222222222"
+  TOKEN = "0xbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb"
+  HUB = "0xcccccccccccccccccccccccccccccccccccccccc"
+  OTHER = "0x5555555555555555555555555555555555555555"
+  CHAIN_ID = 1
+)
+
+func testRules() (*Rules) {
+  return &Rules{
+    ChannelId: CHANNEL_ID,
+    ChannelContract: CHANNEL_CONTRACT,
+    Token: TOKEN,
+    Hub: HUB,
+    ChainId: CHAIN_ID,
+    MaxGas: big.NewInt(200000),
+    MaxGasPrice: big.NewInt(100000000000),
+    MaxFee: big.NewInt(10000000000000000),
+  }
+}
+
+func approveTx(spender string) (*sig.Tx) {
+  return &sig.Tx{
+    To: TOKEN,
+    Data: contracts.ERC20ABI.Approve.MustPack(spender, big.NewInt(1000)),
+    Gas: big.NewInt(60000),
+    GasPrice: big.NewInt(20000000000),
+  }
+}
+
+func openChannelTx(token string, to string) (*sig.Tx) {
+  return &sig.Tx{
+    To: CHANNEL_CONTRACT,
+    Data: contracts.ChannelManagerABI.OpenChannel.MustPack(token, to, big.NewInt(1000)),
+    Gas: big.NewInt(150000),
+    GasFeeCap: big.NewInt(50000000000),
+    GasTipCap: big.NewInt(1000000000),
+  }
+}
+
+func TestValidate(t *testing.T) {
+  if err := testRules().Validate(); err != nil {
+    t.Fatalf("Valid rules refused: %s", err)
+  }
+  var incomplete = map[string]func(r *Rules){
+    "channel id": func(r *Rules) { r.ChannelId = "0x11" },
+    "channel contract": func(r *Rules) { r.ChannelContract = "" },
+    "token": func(r *Rules) { r.Token = "" },
+    "hub": func(r *Rules) { r.Hub = "" },
+    "chain id": func(r *Rules) { r.ChainId = 0 },
+    "max gas": func(r *Rules) { r.MaxGas = nil },
+    "max gas price": func(r *Rules) { r.MaxGasPrice = big.NewInt(0) },
+    "max fee": func(r *Rules) { r.MaxFee = nil },
+  }
+  for name, change := range incomplete {
+    r := testRules()
+    change(r)
+    if err := r.Validate(); err == nil {
+      t.Errorf("Rules without a %s accepted", name)
+    }
+  }
+}
+
+func TestCheckTxAllowed(t *testing.T) {
+  r := testRules()
+  for _, tx := range []*sig.Tx{approveTx(CHANNEL_CONTRACT), openChannelTx(TOKEN, HUB)} {
+    if err := r.CheckTx(tx, CHAIN_ID); err != nil {
+      t.Errorf("Refused an allowed tx to %s: %s", tx.To, err)
+    }
+  }
+  // Addresses are compared regardless of case
+  if err := r.CheckTx(openChannelTx("0x" + strings.ToUpper(TOKEN[2:]), "0x" + strings.ToUpper(HUB[2:])), CHAIN_ID); err != nil {
+    t.Errorf("Refused an allowed tx: %s", err)
+  }
+}
+
+func TestCheckTxRefused(t *testing.T) {
+  r := testRules()
+  var cases = []struct {
+    name string
+    tx func() (*sig.Tx)
+    chainID int64
+  }{
+    {"approval to another spender", func() (*sig.Tx) { return approveTx(OTHER) }, CHAIN_ID},
+    {"approval of another token", func() (*sig.Tx) {
+      tx := approveTx(CHANNEL_CONTRACT)
+      tx.To = OTHER
+      return tx
+    }, CHAIN_ID},
+    {"transfer of the token", func() (*sig.Tx) {
+      tx := approveTx(CHANNEL_CONTRACT)
+      tx.Data = contracts.ERC20ABI.Transfer.MustPack(OTHER, big.NewInt(1000))
+      return tx
+    }, CHAIN_ID},
+    {"channel for another token", func() (*sig.Tx) { return openChannelTx(OTHER, HUB) }, CHAIN_ID},
+    {"channel to another hub", func() (*sig.Tx) { return openChannelTx(TOKEN, OTHER) }, CHAIN_ID},
+    {"other call on the channel contract", func() (*sig.Tx) {
+      tx := openChannelTx(TOKEN, HUB)
+      tx.Data = contracts.ERC20ABI.Approve.MustPack(OTHER, big.NewInt(1000))
+      return tx
+    }, CHAIN_ID},
+    {"ether sent with an approval", func() (*sig.Tx) {
+      tx := approveTx(CHANNEL_CONTRACT)
+      tx.Value = big.NewInt(1)
+      return tx
+    }, CHAIN_ID},
+    {"ether sent to the hub", func() (*sig.Tx) {
+      return &sig.Tx{To: HUB, Value: big.NewInt(1), Gas: big.NewInt(21000), GasPrice: big.NewInt(1)}
+    }, CHAIN_ID},
+    {"another chain", func() (*sig.Tx) { return approveTx(CHANNEL_CONTRACT) }, 3},
+    {"gas limit too high", func() (*sig.Tx) {
+      tx := approveTx(CHANNEL_CONTRACT)
+      tx.Gas = big.NewInt(200001)
+      return tx
+    }, CHAIN_ID},
+    {"no gas limit", func() (*sig.Tx) {
+      tx := approveTx(CHANNEL_CONTRACT)
+      tx.Gas = nil
+      return tx
+    }, CHAIN_ID},
+    {"gas price too high", func() (*sig.Tx) {
+      tx := approveTx(CHANNEL_CONTRACT)
+      tx.GasPrice = big.NewInt(100000000001)
+      return tx
+    }, CHAIN_ID},
+    {"max fee per gas too high", func() (*sig.Tx) {
+      tx := openChannelTx(TOKEN, HUB)
+      tx.GasFeeCap = big.NewInt(100000000001)
+      return tx
+    }, CHAIN_ID},
+    {"priority fee above the max fee", func() (*sig.Tx) {
+      tx := openChannelTx(TOKEN, HUB)
+      tx.GasTipCap = big.NewInt(60000000000)
+      return tx
+    }, CHAIN_ID},
+    {"total fee too high", func() (*sig.Tx) {
+      // Both under their limits, but 200000 * 90 gwei = 0.018 ether
+      tx := openChannelTx(TOKEN, HUB)
+      tx.Gas = big.NewInt(200000)
+      tx.GasFeeCap = big.NewInt(90000000000)
+      return tx
+    }, CHAIN_ID},
+  }
+  for _, c := range cases {
+    if err := r.CheckTx(c.tx(), c.chainID); err == nil {
+      t.Errorf("Allowed %s", c.name)
+    }
+  }
+}
+
+func TestCheckPayment(t *testing.T) {
+  r := testRules()
+  if err := r.CheckPayment(strings.TrimPrefix(CHANNEL_ID, "0x"), "00ff"); err != nil {
+    t.Errorf("Refused a payment into the channel: %s", err)
+  }
+  if err := r.CheckPayment("0x" + strings.Repeat("22", 32), "00ff"); err == nil {
+    t.Errorf("Allowed a payment into another channel")
+  }
+}
@@ -0,0 +1,205 @@
+// A signing daemon that holds the wallet key in its own process and answers
+// Clef-compatible JSON-RPC requests from the agent over a UNIX socket. It
+// only signs what its rules allow.
+package signer
+
+import (
+  "encoding/hex"
+  "encoding/json"
+  "errors"
+  "fmt"
+  "io"
+  "log"
+  "net"
+  "sig"
+  "strings"
+)
+
+// JSON-RPC error codes
+const (
+  CODE_INVALID_REQUEST = -32600
+  CODE_METHOD_NOT_FOUND = -32601
+  CODE_INVALID_PARAMS = -32602
+  CODE_DENIED = -32000
+)
+
+type request struct {
+  JSONRPC string `json:"jsonrpc"`
+  Id json.RawMessage `json:"id"`
+  Method string `json:"method"`
+  Params []json.RawMessage `json:"params"`
+}
+
+type response struct {
+  JSONRPC string `json:"jsonrpc"`
+  Id json.RawMessage `json:"id"`
+  Result interface{} `json:"result,omitempty"`
+  Error *rpcError `json:"error,omitempty"`
+}
+
+type rpcError struct {
+  Code int `json:"code"`
+  Message string `json:"message"`
+}
+
+func (e *rpcError) Error() (string) {
+  return e.Message
+}
+
+// Answers signing requests for one key
+type Server struct {
+  signer sig.Signer
+  rules *Rules
+}
+
+/**
+ * Create a server.
+ *
+ * @param signer    Signer of the wallet key
+ * @param rules     What the server may sign
+ * @return          Server, error if the rules are incomplete
+ */
+func NewServer(signer sig.Signer, rules *Rules) (*Server, error) {
+  if err := rules.Validate(); err != nil {
+    return nil, err
+  }
+  return &Server{signer: signer, rules: rules}, nil
+}
+
+/**
+ * Accept connections until the listener is closed. Each connection may send
+ * any number of requests, answered in order, one JSON object per line.
+ *
+ * @param l    Listener, e.g. on a UNIX socket
+ * @return     Error that stopped the listener
+ */
+func (s *Server) Serve(l net.Listener) (error) {
+  for {
+    conn, err := l.Accept()
+    if err != nil {
+      return err
+    }
+    go s.serveConn(conn)
+  }
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+  defer conn.Close()
+  dec := json.NewDecoder(conn)
+  enc := json.NewEncoder(conn)
+  for {
+    var req request
+    err := dec.Decode(&req)
+    if err == io.EOF {
+      return
+    } else if err != nil {
+      enc.Encode(response{JSONRPC: "2.0", Error: &rpcError{CODE_INVALID_REQUEST, "Invalid request"}})
+      return
+    }
+    res := response{JSONRPC: "2.0", Id: req.Id}
+    result, err := s.handle(&req)
+    if err != nil {
+      var rpcErr *rpcError
+      if !errors.As(err, &rpcErr) {
+        rpcErr = &rpcError{CODE_DENIED, err.Error()}
+      }
+      log.Printf("Refused %s: %s", req.Method, rpcErr.Message)
+      res.Error = rpcErr
+    } else {
+      res.Result = result
+    }
+    if err := enc.Encode(res); err != nil {
+      return
+    }
+  }
+}
+
+// Dispatch a request to its method
+func (s *Server) handle(req *request) (interface{}, error) {
+  switch req.Method {
+  case "account_list":
+    return []string{s.signer.Address()}, nil
+  case "account_signTransaction":
+    var args sig.SendTxArgs
+    if err := s.params(req, &args); err != nil {
+      return nil, err
+    }
+    return s.signTransaction(&args)
+  case "account_signData":
+    var content_type, address, data string
+    if err := s.params(req, &content_type, &address, &data); err != nil {
+      return nil, err
+    }
+    if !strings.EqualFold(address, s.signer.Address()) {
+      return nil, fmt.Errorf("Unknown account %s", address)
+    }
+    return s.signData(content_type, data)
+  }
+  return nil, &rpcError{CODE_METHOD_NOT_FOUND, fmt.Sprintf("The method %s does not exist/is not available", req.Method)}
+}
+
+// Sign a transaction the rules allow
+func (s *Server) signTransaction(args *sig.SendTxArgs) (interface{}, error) {
+  if !strings.EqualFold(args.From, s.signer.Address()) {
+    return nil, fmt.Errorf("Unknown account %s", args.From)
+  }
+  tx, chainID, err := args.ToTx()
+  if err != nil {
+    return nil, &rpcError{CODE_INVALID_PARAMS, err.Error()}
+  }
+  if err := s.rules.CheckTx(tx, chainID); err != nil {
+    return nil, err
+  }
+  raw, err := s.signer.SignTx(tx, chainID)
+  if err != nil {
+    return nil, err
+  }
+  log.Printf("Signed tx to %s with nonce %d", tx.To, tx.Nonce)
+  return map[string]interface{}{"raw": raw, "tx": args}, nil
+}
+
+// Sign a personal message or a channel payment
+func (s *Server) signData(content_type string, data string) (interface{}, error) {
+  b, err := hex.DecodeString(strings.TrimPrefix(data, "0x"))
+  if err != nil {
+    return nil, &rpcError{CODE_INVALID_PARAMS, fmt.Sprintf("Invalid hex data (%s)", err)}
+  }
+  switch content_type {
+  case "text/plain":
+    if !s.rules.AllowText {
+      return nil, errors.New("Signing messages is not allowed")
+    }
+    signature, err := sig.SignPersonal(b, s.signer)
+    if err != nil { return nil, err }
+    log.Printf("Signed message %q", b)
+    return signature.Hex(), nil
+  case sig.PAYMENT_CONTENT_TYPE:
+    if len(b) != 64 {
+      return nil, &rpcError{CODE_INVALID_PARAMS, "Payment data must be a channel id and a value, 32 bytes each"}
+    }
+    channel_id := "0x" + hex.EncodeToString(b[:32])
+    amount := hex.EncodeToString(b[32:])
+    if err := s.rules.CheckPayment(channel_id, amount); err != nil {
+      return nil, err
+    }
+    msg, err := sig.SignPayment(channel_id, amount, s.signer)
+    if err != nil { return nil, err }
+    log.Printf("Signed payment of 0x%s into channel %s", strings.TrimLeft(amount, "0"), channel_id)
+    signature := sig.Signature{V: msg.V, R: msg.R, S: msg.S}
+    return signature.Hex(), nil
+  }
+  return nil, &rpcError{CODE_INVALID_PARAMS, fmt.Sprintf("Unsupported content type %q", content_type)}
+}
+
+// Decode positional parameters
+func (s *Server) params(req *request, dest ...interface{}) (error) {
+  if len(req.Params) != len(dest) {
+    return &rpcError{CODE_INVALID_PARAMS, fmt.Sprintf("Expected %d params, got %d", len(dest), len(req.Params))}
+  }
+  for i, param := range req.Params {
+    if err := json.Unmarshal(param, dest[i]); err != nil {
+      return &rpcError{CODE_INVALID_PARAMS, fmt.Sprintf("Invalid param %d (%s)", i, err)}
+    }
+  }
+  return nil
+}
@@ -0,0 +1,160 @@
+// Exact token and ether amounts. Amounts are held in atomic units (e.g. wei)
+// as big integers; decimals only come into play when parsing or displaying.
+package units
+
+import (
+  "fmt"
+  "math/big"
+  "strings"
+)
+
+// Decimals of ether, i.e. wei per ether is 10^18
+const ETHER_DECIMALS = 18
+
+type TokenAmount struct {
+  Value *big.Int          // Atomic units
+  Decimals uint8          // Atomic units per token is 10^Decimals
+}
+
+/**
+ * Wrap an amount in atomic units.
+ *
+ * @param value       Amount in atomic units
+ * @param decimals    Decimals of the token
+ * @return            Amount
+ */
+func NewTokenAmount(value *big.Int, decimals uint8) (TokenAmount) {
+  if value == nil { value = new(big.Int) }
+  return TokenAmount{Value: value, Decimals: decimals}
+}
+
+/**
+ * Parse a decimal amount of tokens, e.g. "12.5". The amount must be exactly
+ * representable in atomic units.
+ *
+ * @param s           Decimal amount (exponents such as "1.5e3" are allowed)
+ * @param decimals    Decimals of the token
+ * @return            Amount, error
+ */
+func ParseTokenAmount(s string, decimals uint8) (TokenAmount, error) {
+  atomic, err := toAtomic(s, decimals)
+  if err != nil { return TokenAmount{}, err }
+  if !atomic.IsInt() {
+    return TokenAmount{}, fmt.Errorf("%s has more than %d decimals", s, decimals)
+  }
+  return NewTokenAmount(new(big.Int).Set(atomic.Num()), decimals), nil
+}
+
+/**
+ * Same as ParseTokenAmount, but fractions of an atomic unit are rounded up
+ * instead of being an error. Use this for amounts owed, so payments never
+ * fall short.
+ */
+func ParseTokenAmountCeil(s string, decimals uint8) (TokenAmount, error) {
+  atomic, err := toAtomic(s, decimals)
+  if err != nil { return TokenAmount{}, err }
+  value := new(big.Int).Quo(atomic.Num(), atomic.Denom())
+  if !atomic.IsInt() && atomic.Sign() > 0 {
+    value.Add(value, big.NewInt(1))
+  }
+  return NewTokenAmount(value, decimals), nil
+}
+
+/**
+ * Parse a whole number of atomic units, e.g. a wei amount from an API. Exponent
+ * notation ("1.5e21") is accepted as long as the result is whole.
+ *
+ * @param s    Integer
+ * @return     Value, error
+ */
+func ParseInt(s string) (*big.Int, error) {
+  r, ok := new(big.Rat).SetString(strings.TrimSpace(s))
+  if !ok || !r.IsInt() {
+    return nil, fmt.Errorf("Invalid integer %q", s)
+  }
+  return new(big.Int).Set(r.Num()), nil
+}
+
+/**
+ * Format the amount in tokens with as many decimals as needed, e.g. "12.5"
+ */
+func (amount TokenAmount) String() (string) {
+  s := amount.Format(int(amount.Decimals))
+  if strings.Contains(s, ".") {
+    s = strings.TrimRight(strings.TrimRight(s, "0"), ".")
+  }
+  return s
+}
+
+/**
+ * Format the amount in tokens with a fixed number of decimals. Extra digits
+ * are truncated (rounded towards zero).
+ *
+ * @param places    Number of decimals to show
+ * @return          Formatted amount, e.g. "12.500000"
+ */
+func (amount TokenAmount) Format(places int) (string) {
+  value := amount.value()
+  negative := value.Sign() < 0
+  digits := new(big.Int).Abs(value).String()
+  // Make sure there is at least one digit before the decimal point
+  if len(digits) <= int(amount.Decimals) {
+    digits = strings.Repeat("0", int(amount.Decimals) - len(digits) + 1) + digits
+  }
+  point := len(digits) - int(amount.Decimals)
+  whole, frac := digits[:point], digits[point:]
+  if places < len(frac) {
+    frac = frac[:places]
+  } else {
+    frac += strings.Repeat("0", places - len(frac))
+  }
+  s := whole
+  if places > 0 { s += "." + frac }
+  if negative { s = "-" + s }
+  return s
+}
+
+// Hex string of the atomic value, without a 0x prefix
+func (amount TokenAmount) Hex() (string) {
+  return fmt.Sprintf("%x", amount.value())
+}
+
+func (amount TokenAmount) Add(other TokenAmount) (TokenAmount) {
+  return NewTokenAmount(new(big.Int).Add(amount.value(), other.value()), amount.Decimals)
+}
+
+func (amount TokenAmount) Sub(other TokenAmount) (TokenAmount) {
+  return NewTokenAmount(new(big.Int).Sub(amount.value(), other.value()), amount.Decimals)
+}
+
+// -1, 0 or 1 if the amount is less than, equal to or more than the other
+func (amount TokenAmount) Cmp(other TokenAmount) (int) {
+  return amount.value().Cmp(other.value())
+}
+
+func (amount TokenAmount) Sign() (int) {
+  return amount.value().Sign()
+}
+
+/**
+ * Format a wei amount in ether, e.g. "0.021"
+ */
+func FormatEther(wei *big.Int) (string) {
+  return NewTokenAmount(wei, ETHER_DECIMALS).String()
+}
+
+// The zero value of TokenAmount has a nil Value
+func (amount TokenAmount) value() (*big.Int) {
+  if amount.Value == nil { return new(big.Int) }
+  return amount.Value
+}
+
+// Scale a decimal amount up to atomic units
+func toAtomic(s string, decimals uint8) (*big.Rat, error) {
+  r, ok := new(big.Rat).SetString(strings.TrimSpace(s))
+  if !ok {
+    return nil, fmt.Errorf("Invalid amount %q", s)
+  }
+  scale := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(decimals)), nil)
+  return r.Mul(r, new(big.Rat).SetInt(scale)), nil
+}